@@ -0,0 +1,417 @@
+// Package app is the spinnaker-dd-bridge command line application. Programs
+// that register their own handler types with spinnaker.RegisterHandlerType
+// can run the bridge with New(version).Run(os.Args).
+package app
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/archive"
+	"github.com/bobbytables/spinnaker-datadog-bridge/server"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/ratelimit"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnakerdatadog"
+)
+
+// New initializes the bridge application with its flags and commands
+func New(version string) *cli.App {
+	app := cli.NewApp()
+	app.Name = "spinnaker-dd-bridge"
+	app.Version = version
+	app.Commands = []cli.Command{tailCommand, replayCommand}
+	app.Before = loadConfig
+	app.Action = serverAction
+	app.Authors = []cli.Author{
+		{
+			Name:  "Robert Ross",
+			Email: "robert.ross@namely.com",
+		},
+	}
+
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "config",
+			Usage:  "A YAML file configuring the whole bridge, flags and environment variables override its values",
+			EnvVar: "BRIDGE_CONFIG",
+		},
+		cli.StringFlag{
+			Name:   "datadog-api-key",
+			Usage:  "your datadog api key (Found at https://app.datadoghq.com/account/settings#api)",
+			EnvVar: "DATADOG_API_KEY",
+		},
+		cli.StringFlag{
+			Name:   "datadog-app-key",
+			Usage:  "your datadog app key (Found at https://app.datadoghq.com/account/settings#api)",
+			EnvVar: "DATADOG_APP_KEY",
+		},
+		cli.StringFlag{
+			Name:   "datadog-site",
+			Usage:  "The Datadog site to send data to (us1, us3, us5, eu, ap1, gov or its domain)",
+			EnvVar: "DD_SITE",
+			Value:  spinnakerdatadog.DefaultSite,
+		},
+		cli.StringFlag{
+			Name:   "datadog-api-url",
+			Usage:  "Overrides the Datadog API URL derived from --datadog-site, DATADOG_HOST is honored when it's unset",
+			EnvVar: "DATADOG_API_URL,DATADOG_HOST",
+		},
+		cli.StringFlag{
+			Name:   "datadog-proxy",
+			Usage:  "An HTTP proxy for requests to Datadog (HTTPS_PROXY is honored when unset)",
+			EnvVar: "DATADOG_PROXY",
+		},
+		cli.DurationFlag{
+			Name:   "datadog-timeout",
+			Usage:  "The timeout for requests to Datadog",
+			EnvVar: "DATADOG_TIMEOUT",
+			Value:  time.Second * 10,
+		},
+		cli.StringFlag{
+			Name:   "datadog-ca-bundle",
+			Usage:  "A PEM file with extra certificate authorities to trust for requests to Datadog",
+			EnvVar: "DATADOG_CA_BUNDLE",
+		},
+		cli.StringFlag{
+			Name:   "datadog-tls-min-version",
+			Usage:  "The minimum TLS version for requests to Datadog (1.0, 1.1, 1.2 or 1.3)",
+			EnvVar: "DATADOG_TLS_MIN_VERSION",
+		},
+		cli.BoolFlag{
+			Name:   "datadog-tls-insecure-skip-verify",
+			Usage:  "Don't verify the certificates of Datadog (or the proxy in front of it)",
+			EnvVar: "DATADOG_TLS_INSECURE_SKIP_VERIFY",
+		},
+		cli.StringFlag{
+			Name:   "datadog-routes",
+			Usage:  "A file routing applications to Datadog organizations with their own API keys",
+			EnvVar: "DATADOG_ROUTES",
+		},
+		cli.StringFlag{
+			Name:   "event-templates",
+			Usage:  "The file where your event templates are located for Spinnaker events",
+			EnvVar: "EVENT_TEMPLATES",
+		},
+		cli.StringFlag{
+			Name:   "deck-url",
+			Usage:  "The base URL of Deck (Spinnaker's UI) used to link events to executions",
+			EnvVar: "DECK_URL",
+		},
+		cli.StringFlag{
+			Name:   "addr",
+			Usage:  "The address the server listens on",
+			EnvVar: "ADDR",
+			Value:  ":3000",
+		},
+		cli.BoolFlag{
+			Name:   "datadog-metrics",
+			Usage:  "Submit webhook counts and pipeline/stage durations as Datadog metrics",
+			EnvVar: "DATADOG_METRICS",
+		},
+		cli.DurationFlag{
+			Name:   "datadog-metrics-flush-interval",
+			Usage:  "How often buffered metrics are submitted to Datadog",
+			EnvVar: "DATADOG_METRICS_FLUSH_INTERVAL",
+			Value:  time.Second * 10,
+		},
+		cli.IntFlag{
+			Name:   "datadog-metrics-max-series",
+			Usage:  "How many series are buffered before they are submitted regardless of the flush interval",
+			EnvVar: "DATADOG_METRICS_MAX_SERIES",
+			Value:  500,
+		},
+		cli.StringSliceFlag{
+			Name:   "datadog-metrics-tag",
+			Usage:  "Extra tags to add to every metric sent to Datadog (can be repeated)",
+			EnvVar: "DATADOG_METRICS_TAGS",
+		},
+		cli.BoolFlag{
+			Name:   "pipeline-summary",
+			Usage:  "Send a single event summarizing every stage when a pipeline completes or fails",
+			EnvVar: "PIPELINE_SUMMARY",
+		},
+		cli.BoolFlag{
+			Name:   "pipeline-summary-suppress-stages",
+			Usage:  "Stop sending an event for every stage when pipeline summaries are enabled",
+			EnvVar: "PIPELINE_SUMMARY_SUPPRESS_STAGES",
+		},
+		cli.StringFlag{
+			Name:   "pipeline-summary-title",
+			Usage:  "The title template of pipeline summary events",
+			EnvVar: "PIPELINE_SUMMARY_TITLE",
+		},
+		cli.BoolFlag{
+			Name:   "manual-judgments",
+			Usage:  "Send an event when a manual judgment starts waiting and record how long judgments wait as Datadog metrics",
+			EnvVar: "MANUAL_JUDGMENTS",
+		},
+		cli.DurationFlag{
+			Name:   "manual-judgment-reminder-interval",
+			Usage:  "How often a reminder event is sent while a manual judgment is waiting, 0 disables reminders",
+			EnvVar: "MANUAL_JUDGMENT_REMINDER_INTERVAL",
+			Value:  time.Hour,
+		},
+		cli.BoolFlag{
+			Name:   "canary-analysis",
+			Usage:  "Send an event with the verdict of Kayenta canary analysis stages and submit their scores as Datadog metrics",
+			EnvVar: "CANARY_ANALYSIS",
+		},
+		cli.StringFlag{
+			Name:   "kayenta-url",
+			Usage:  "The Kayenta API URL the group scores and metric results of canary analysis stages are read from",
+			EnvVar: "KAYENTA_URL",
+		},
+		cli.StringFlag{
+			Name:   "tls-cert",
+			Usage:  "Serve webhooks over TLS with this PEM certificate (requires --tls-key)",
+			EnvVar: "TLS_CERT",
+		},
+		cli.StringFlag{
+			Name:   "tls-key",
+			Usage:  "The PEM private key of --tls-cert",
+			EnvVar: "TLS_KEY",
+		},
+		cli.StringFlag{
+			Name:   "tls-client-ca",
+			Usage:  "Require webhook clients to present a certificate signed by one of the authorities in this PEM file",
+			EnvVar: "TLS_CLIENT_CA",
+		},
+		cli.StringSliceFlag{
+			Name:   "tls-allowed-client",
+			Usage:  "Only accept client certificates with a common name or DNS/URI SAN matching this glob (can be repeated)",
+			EnvVar: "TLS_ALLOWED_CLIENTS",
+		},
+		cli.DurationFlag{
+			Name:   "tls-reload-interval",
+			Usage:  "How often the TLS certificate, key and client CA files are checked for changes",
+			EnvVar: "TLS_RELOAD_INTERVAL",
+			Value:  server.DefaultTLSReloadInterval,
+		},
+		cli.StringFlag{
+			Name:   "admin-addr",
+			Usage:  "The address the read-only admin API listens on, it is disabled when empty",
+			EnvVar: "ADMIN_ADDR",
+		},
+		cli.IntFlag{
+			Name:   "recent-webhooks",
+			Usage:  "How many recent webhooks the admin API keeps for /admin/recent, 0 disables it",
+			EnvVar: "RECENT_WEBHOOKS",
+			Value:  server.DefaultRecentSize,
+		},
+		cli.StringFlag{
+			Name:   "archive-dir",
+			Usage:  "Record the raw body of every webhook to NDJSON files in this directory",
+			EnvVar: "ARCHIVE_DIR",
+		},
+		cli.Int64Flag{
+			Name:   "archive-max-bytes",
+			Usage:  "How large an archive file gets before it is rotated",
+			EnvVar: "ARCHIVE_MAX_BYTES",
+			Value:  100 << 20,
+		},
+		cli.DurationFlag{
+			Name:   "archive-rotate-interval",
+			Usage:  "How long an archive file is written to before it is rotated",
+			EnvVar: "ARCHIVE_ROTATE_INTERVAL",
+			Value:  time.Hour * 24,
+		},
+		cli.BoolFlag{
+			Name:   "archive-gzip",
+			Usage:  "Compress archive files with gzip",
+			EnvVar: "ARCHIVE_GZIP",
+		},
+		cli.IntFlag{
+			Name:   "archive-max-files",
+			Usage:  "How many archive files are kept, 0 keeps every file",
+			EnvVar: "ARCHIVE_MAX_FILES",
+		},
+		cli.DurationFlag{
+			Name:   "archive-retention",
+			Usage:  "How long archive files are kept, 0 keeps them forever",
+			EnvVar: "ARCHIVE_RETENTION",
+		},
+		cli.Int64Flag{
+			Name:   "max-body-bytes",
+			Usage:  "The largest webhook body accepted",
+			EnvVar: "MAX_BODY_BYTES",
+			Value:  server.DefaultMaxBodyBytes,
+		},
+		cli.DurationFlag{
+			Name:   "webhook-timeout",
+			Usage:  "How long the handlers of a webhook are waited for before responding with 503",
+			EnvVar: "WEBHOOK_TIMEOUT",
+			Value:  server.DefaultHandlerTimeout,
+		},
+		cli.BoolFlag{
+			Name:   "webhook-results",
+			Usage:  "Respond to webhooks with the result of every handler as JSON (for debugging)",
+			EnvVar: "WEBHOOK_RESULTS",
+		},
+		cli.BoolFlag{
+			Name:   "datadog-logs",
+			Usage:  "Send webhooks as structured logs to the Datadog logs intake",
+			EnvVar: "DATADOG_LOGS",
+		},
+		cli.StringFlag{
+			Name:   "datadog-logs-intake-url",
+			Usage:  "Overrides the Datadog HTTP logs intake endpoint derived from --datadog-site",
+			EnvVar: "DATADOG_LOGS_INTAKE_URL",
+		},
+		cli.BoolFlag{
+			Name:   "datadog-logs-templated-only",
+			Usage:  "Only send logs for hook types that have an event template",
+			EnvVar: "DATADOG_LOGS_TEMPLATED_ONLY",
+		},
+		cli.StringSliceFlag{
+			Name:   "datadog-logs-tag",
+			Usage:  "Extra tags to add to every log sent to Datadog (can be repeated)",
+			EnvVar: "DATADOG_LOGS_TAGS",
+		},
+		cli.IntFlag{
+			Name:   "datadog-logs-batch-size",
+			Usage:  "How many logs are buffered before sending them to Datadog",
+			EnvVar: "DATADOG_LOGS_BATCH_SIZE",
+			Value:  100,
+		},
+		cli.DurationFlag{
+			Name:   "datadog-logs-flush-interval",
+			Usage:  "How often buffered logs are sent to Datadog",
+			EnvVar: "DATADOG_LOGS_FLUSH_INTERVAL",
+			Value:  time.Second * 5,
+		},
+		cli.DurationFlag{
+			Name:   "forward-timeout",
+			Usage:  "The timeout for requests made by HTTP forwards, Slack/Teams notifications and PagerDuty alerts",
+			EnvVar: "FORWARD_TIMEOUT",
+			Value:  time.Second * 5,
+		},
+		cli.Float64Flag{
+			Name:   "rate-limit",
+			Usage:  "How many webhooks per second are sent to each destination, 0 is unlimited",
+			EnvVar: "RATE_LIMIT",
+		},
+		cli.IntFlag{
+			Name:   "rate-limit-burst",
+			Usage:  "How many webhooks can be sent to a destination at once before --rate-limit applies",
+			EnvVar: "RATE_LIMIT_BURST",
+			Value:  10,
+		},
+		cli.Float64Flag{
+			Name:   "rate-limit-per-application",
+			Usage:  "How many webhooks per second are sent to each destination for a single application, 0 is unlimited",
+			EnvVar: "RATE_LIMIT_PER_APPLICATION",
+		},
+		cli.IntFlag{
+			Name:   "rate-limit-application-burst",
+			Usage:  "How many webhooks for an application can be sent at once before --rate-limit-per-application applies",
+			EnvVar: "RATE_LIMIT_APPLICATION_BURST",
+			Value:  5,
+		},
+		cli.StringFlag{
+			Name:   "rate-limit-policy",
+			Usage:  "What happens to webhooks over the rate limit, queue or sample (drop them)",
+			EnvVar: "RATE_LIMIT_POLICY",
+			Value:  string(ratelimit.PolicyQueue),
+		},
+		cli.DurationFlag{
+			Name:   "rate-limit-max-wait",
+			Usage:  "How long a webhook is queued for before it is dropped, must be shorter than --webhook-timeout",
+			EnvVar: "RATE_LIMIT_MAX_WAIT",
+			Value:  time.Second * 5,
+		},
+		cli.BoolFlag{
+			Name:  "debug",
+			Usage: "Turn on DEBUG level logging",
+		},
+	}
+
+	return app
+}
+
+func serverAction(c *cli.Context) error {
+	// Webhooks queued by the rate limiter have to be sent before the server
+	// gives up on their handlers
+	timeout, maxWait := c.Duration("webhook-timeout"), c.Duration("rate-limit-max-wait")
+	if ratelimit.Policy(c.String("rate-limit-policy")) == ratelimit.PolicyQueue && maxWait >= timeout {
+		return fmt.Errorf("--rate-limit-max-wait (%s) must be shorter than --webhook-timeout (%s)", maxWait, timeout)
+	}
+
+	b, err := newBridge(c, false)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	dispatcher, spout := b.dispatcher, b.spout
+
+	srv := server.New(c.String("addr"), dispatcher)
+	srv.MaxBodyBytes = c.Int64("max-body-bytes")
+	srv.HandlerTimeout = c.Duration("webhook-timeout")
+	srv.ReturnResults = c.Bool("webhook-results")
+
+	if c.String("tls-cert") != "" || c.String("tls-key") != "" {
+		srv.TLS = &server.TLSOptions{
+			CertFile:       c.String("tls-cert"),
+			KeyFile:        c.String("tls-key"),
+			ClientCAFile:   c.String("tls-client-ca"),
+			AllowedClients: c.StringSlice("tls-allowed-client"),
+			ReloadInterval: c.Duration("tls-reload-interval"),
+		}
+		if err := srv.TLS.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if dir := c.String("archive-dir"); dir != "" {
+		archiver, err := archive.NewWriter(archive.Options{
+			Dir:            dir,
+			MaxBytes:       c.Int64("archive-max-bytes"),
+			RotateInterval: c.Duration("archive-rotate-interval"),
+			Gzip:           c.Bool("archive-gzip"),
+			MaxFiles:       c.Int("archive-max-files"),
+			Retention:      c.Duration("archive-retention"),
+		})
+		if err != nil {
+			return err
+		}
+		defer archiver.Close()
+
+		srv.Archive = archiver
+	}
+
+	if addr := c.String("admin-addr"); addr != "" {
+		admin := server.NewAdmin(addr, dispatcher)
+		admin.Version = c.App.Version
+		admin.Config = effectiveConfig(c)
+		admin.Templates = spout.Templates()
+		if size := c.Int("recent-webhooks"); size > 0 {
+			srv.Recent = server.NewRecent(size)
+			admin.HandleFunc("/admin/recent", srv.Recent.ServeHTTP)
+		}
+		srv.Stream = server.NewStream()
+		admin.HandleFunc("/admin/stream", srv.Stream.ServeHTTP)
+		srv.Admin = admin
+	}
+
+	return srv.Start()
+}
+
+// effectiveConfig returns the value of every flag, secrets are redacted by the
+// admin server
+func effectiveConfig(c *cli.Context) map[string]interface{} {
+	config := make(map[string]interface{})
+	for _, name := range c.GlobalFlagNames() {
+		value := c.Generic(name)
+		if getter, ok := value.(flag.Getter); ok {
+			config[name] = getter.Get()
+			if d, ok := config[name].(time.Duration); ok {
+				config[name] = d.String()
+			}
+		} else if value != nil {
+			config[name] = fmt.Sprint(value)
+		}
+	}
+
+	return config
+}
@@ -0,0 +1,230 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/config"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/ratelimit"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/templates"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnakerchat"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnakerdatadog"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnakerhttp"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnakerpagerduty"
+)
+
+// bridge is the dispatcher with every handler configured by the flags
+// attached, it is shared by the server and the replay command
+type bridge struct {
+	dispatcher *spinnaker.Dispatcher
+	spout      *spinnakerdatadog.Spout
+	// closers flush buffered handlers (logs and metrics)
+	closers []io.Closer
+}
+
+// newBridge builds the dispatcher from the global flags. When replaying,
+// metrics and logs aren't submitted since Datadog would date them now.
+func newBridge(c *cli.Context, replaying bool) (*bridge, error) {
+	defaultOrg := spinnakerdatadog.NewOrganization(
+		spinnakerdatadog.DefaultOrganization,
+		c.String("datadog-api-key"),
+		c.String("datadog-app-key"),
+	)
+	routes, err := configSource(c, "datadog-routes", (*config.Config).RoutesYAML)
+	if err != nil {
+		return nil, err
+	}
+	router, err := spinnakerdatadog.LoadRouterFromYAML(routes, defaultOrg)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := spinnakerdatadog.ConnectionOptions{
+		Site:               c.String("datadog-site"),
+		APIURL:             c.String("datadog-api-url"),
+		ProxyURL:           c.String("datadog-proxy"),
+		Timeout:            c.Duration("datadog-timeout"),
+		CABundle:           c.String("datadog-ca-bundle"),
+		TLSMinVersion:      c.String("datadog-tls-min-version"),
+		InsecureSkipVerify: c.Bool("datadog-tls-insecure-skip-verify"),
+	}
+	ddBaseURL, err := conn.BaseURL()
+	if err != nil {
+		return nil, err
+	}
+	ddHTTPClient, err := conn.HTTPClient()
+	if err != nil {
+		return nil, err
+	}
+
+	limits := ratelimit.Options{
+		Rate:             c.Float64("rate-limit"),
+		Burst:            c.Int("rate-limit-burst"),
+		ApplicationRate:  c.Float64("rate-limit-per-application"),
+		ApplicationBurst: c.Int("rate-limit-application-burst"),
+		Policy:           ratelimit.Policy(c.String("rate-limit-policy")),
+		MaxWait:          c.Duration("rate-limit-max-wait"),
+	}
+	if err := limits.Validate(); err != nil {
+		return nil, err
+	}
+	limiter := ratelimit.New(limits)
+	for _, org := range router.Organizations() {
+		org.Connect(ddBaseURL, limiter.Client(org.Destination(), ddHTTPClient))
+	}
+
+	deckURL := c.String("deck-url")
+
+	eventTemplates, err := configSource(c, "event-templates", (*config.Config).TemplatesYAML)
+	if err != nil {
+		return nil, err
+	}
+	templatesFile, err := templates.ParseFile(eventTemplates)
+	if err != nil {
+		return nil, err
+	}
+
+	dispatcher := spinnaker.NewDispatcher()
+	b := &bridge{dispatcher: dispatcher}
+	spout, err := spinnakerdatadog.NewSpoutFromFile(defaultOrg.Client(), templatesFile, deckURL)
+	if err != nil {
+		return nil, err
+	}
+	spout.UseRouter(router)
+	b.spout = spout
+
+	if c.Bool("debug") {
+		logrus.StandardLogger().SetLevel(logrus.DebugLevel)
+	}
+
+	if c.Bool("pipeline-summary") {
+		spout.EnableSummaries(spinnakerdatadog.SummaryOptions{
+			Title:               c.String("pipeline-summary-title"),
+			SuppressStageEvents: c.Bool("pipeline-summary-suppress-stages"),
+		})
+	}
+
+	var aggregator *spinnakerdatadog.MetricAggregator
+	metrics := func() *spinnakerdatadog.MetricAggregator {
+		if aggregator == nil && !replaying {
+			aggregator = spinnakerdatadog.NewMetricAggregator(spinnakerdatadog.MetricsOptions{
+				FlushInterval: c.Duration("datadog-metrics-flush-interval"),
+				MaxSeries:     c.Int("datadog-metrics-max-series"),
+				Tags:          c.StringSlice("datadog-metrics-tag"),
+			})
+			b.closers = append(b.closers, aggregator)
+		}
+		return aggregator
+	}
+
+	if c.Bool("datadog-metrics") && !replaying {
+		aggregator := metrics()
+		limiter.OnOutcome(func(destination, outcome string) {
+			aggregator.Count(defaultOrg, "spinnaker.bridge.rate_limited", 1,
+				fmt.Sprintf("destination:%s", destination),
+				fmt.Sprintf("outcome:%s", outcome),
+			)
+		})
+	}
+
+	if c.Bool("manual-judgments") {
+		opts := spinnakerdatadog.JudgmentOptions{
+			ReminderInterval: c.Duration("manual-judgment-reminder-interval"),
+		}
+		if replaying {
+			// Replayed judgments were resolved long ago, they would be
+			// reminded while the replay runs
+			opts.ReminderInterval = 0
+		}
+		judgments := spout.EnableManualJudgments(metrics(), opts)
+		b.closers = append(b.closers, judgments)
+	}
+
+	if c.Bool("canary-analysis") {
+		var opts spinnakerdatadog.CanaryOptions
+		if kayentaURL := c.String("kayenta-url"); kayentaURL != "" {
+			opts.Kayenta = spinnakerdatadog.NewKayentaClient(kayentaURL, &http.Client{Timeout: c.Duration("forward-timeout")})
+		}
+		spout.EnableCanaryAnalysis(metrics(), opts)
+	}
+
+	dispatcher.AddHandlers(limiter.Handlers(spout.Handlers()))
+
+	httpClient := &http.Client{Timeout: c.Duration("forward-timeout")}
+	forwarder, err := spinnakerhttp.NewForwarderFromFile(httpClient, templatesFile, deckURL)
+	if err != nil {
+		return nil, err
+	}
+	dispatcher.AddHandlers(limiter.Handlers(forwarder.Handlers()))
+
+	notifier, err := spinnakerchat.NewNotifierFromFile(httpClient, templatesFile, deckURL)
+	if err != nil {
+		return nil, err
+	}
+	dispatcher.AddHandlers(limiter.Handlers(notifier.Handlers()))
+
+	alerter, err := spinnakerpagerduty.NewAlerterFromFile(httpClient, templatesFile, deckURL)
+	if err != nil {
+		return nil, err
+	}
+	dispatcher.AddHandlers(limiter.Handlers(alerter.Handlers()))
+
+	if cfg := fileConfig(c); cfg != nil && len(cfg.Handlers) > 0 {
+		registry := spinnaker.NewRegistry()
+		spout.RegisterHandlerTypes(registry, metrics)
+		spinnakerhttp.RegisterHandlerTypes(registry, httpClient, deckURL)
+		spinnakerchat.RegisterHandlerTypes(registry, httpClient, deckURL)
+		spinnakerpagerduty.RegisterHandlerTypes(registry, httpClient, deckURL)
+		for _, name := range registry.Merge(spinnaker.DefaultRegistry) {
+			logrus.WithField("type", name).Info("registered handler type replaces the built-in one")
+		}
+
+		handlers, err := registry.Handlers(cfg.Handlers)
+		if err != nil {
+			return nil, err
+		}
+		dispatcher.AddHandlers(limiter.Handlers(handlers))
+	}
+
+	if c.Bool("datadog-logs") && !replaying {
+		intakeURL := c.String("datadog-logs-intake-url")
+		if intakeURL == "" {
+			if intakeURL, err = conn.LogsIntakeURL(); err != nil {
+				return nil, err
+			}
+		}
+
+		logs := spinnakerdatadog.NewDatadogLogsHandler(spout, spinnakerdatadog.LogsOptions{
+			APIKey:        c.String("datadog-api-key"),
+			IntakeURL:     intakeURL,
+			HTTPClient:    ddHTTPClient,
+			TemplatedOnly: c.Bool("datadog-logs-templated-only"),
+			Tags:          c.StringSlice("datadog-logs-tag"),
+			BatchSize:     c.Int("datadog-logs-batch-size"),
+			FlushInterval: c.Duration("datadog-logs-flush-interval"),
+		})
+		b.closers = append(b.closers, logs)
+
+		dispatcher.AddHandler(spinnaker.AllHookTypes, logs)
+	}
+
+	if c.Bool("datadog-metrics") && !replaying {
+		dispatcher.AddHandler(spinnaker.AllHookTypes, spinnakerdatadog.NewDatadogMetricsHandler(spout, metrics()))
+	}
+
+	return b, nil
+}
+
+// Close flushes every buffered handler
+func (b *bridge) Close() {
+	for i := len(b.closers) - 1; i >= 0; i-- {
+		if err := b.closers[i].Close(); err != nil {
+			logrus.WithError(err).Error("could not flush handler")
+		}
+	}
+}
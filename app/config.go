@@ -0,0 +1,54 @@
+package app
+
+import (
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/config"
+)
+
+// configMetadata is the key of the loaded --config file in the app metadata
+const configMetadata = "config"
+
+// loadConfig applies the --config file to every flag that wasn't set on the
+// command line or through its environment variable
+func loadConfig(c *cli.Context) error {
+	path := c.String("config")
+	if path == "" {
+		return nil
+	}
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+	if err := cfg.Apply(c); err != nil {
+		return err
+	}
+
+	c.App.Metadata[configMetadata] = cfg
+	return nil
+}
+
+// fileConfig returns the loaded --config file, or nil when there isn't one
+func fileConfig(c *cli.Context) *config.Config {
+	cfg, _ := c.App.Metadata[configMetadata].(*config.Config)
+	return cfg
+}
+
+// configSource returns the contents of the file named by the given flag, or
+// the inline section of the --config file when the flag isn't set
+func configSource(c *cli.Context, name string, section func(*config.Config) ([]byte, error)) ([]byte, error) {
+	if path := c.String(name); path != "" {
+		b, err := ioutil.ReadFile(path)
+		return b, errors.Wrapf(err, "could not read --%s", name)
+	}
+
+	if cfg := fileConfig(c); cfg != nil {
+		return section(cfg)
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,241 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/archive"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+)
+
+var replayCommand = cli.Command{
+	Name:      "replay",
+	Usage:     "Send archived or captured webhooks through the bridge again",
+	ArgsUsage: "<file or archive directory>...",
+	Action:    replayAction,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "since",
+			Usage: "Only replay webhooks received at or after this RFC3339 time",
+		},
+		cli.StringFlag{
+			Name:  "until",
+			Usage: "Only replay webhooks received before this RFC3339 time",
+		},
+		cli.StringFlag{
+			Name:  "application",
+			Usage: "Only replay webhooks for applications matching this glob",
+		},
+		cli.StringFlag{
+			Name:  "type",
+			Usage: "Only replay webhooks with hook types matching this glob",
+		},
+		cli.StringFlag{
+			Name:  "target",
+			Usage: "POST webhooks to the webhook URL of a running bridge instead of dispatching them locally",
+		},
+		cli.Float64Flag{
+			Name:  "rate",
+			Usage: "How many webhooks per second are replayed, 0 is as fast as possible",
+			Value: 5,
+		},
+		cli.BoolFlag{
+			Name:  "preserve-timestamps",
+			Usage: "Date Datadog events with the time their webhook was created (only when dispatching locally, reminders are still dated now)",
+		},
+	},
+}
+
+// replayFilter decides which webhooks are replayed
+type replayFilter struct {
+	since, until      time.Time
+	application, kind string
+}
+
+func (rf *replayFilter) matches(received time.Time, incoming *types.IncomingWebhook) bool {
+	if !rf.since.IsZero() && received.Before(rf.since) {
+		return false
+	}
+	if !rf.until.IsZero() && !received.Before(rf.until) {
+		return false
+	}
+
+	return globMatches(rf.application, incoming.Details.Application) && globMatches(rf.kind, incoming.Details.Type)
+}
+
+func globMatches(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+
+	ok, _ := path.Match(pattern, value)
+	return ok
+}
+
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	return t, errors.Wrapf(err, "could not parse time %q", value)
+}
+
+func replayAction(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return errors.New("at least one file or archive directory is required")
+	}
+
+	filter := &replayFilter{application: c.String("application"), kind: c.String("type")}
+	var err error
+	if filter.since, err = parseTime(c.String("since")); err != nil {
+		return err
+	}
+	if filter.until, err = parseTime(c.String("until")); err != nil {
+		return err
+	}
+
+	send, closeSender, err := replaySender(c)
+	if err != nil {
+		return err
+	}
+	defer closeSender()
+
+	var interval time.Duration
+	if rate := c.Float64("rate"); rate > 0 {
+		interval = time.Duration(float64(time.Second) / rate)
+	}
+
+	var replayed, skipped, failed int
+	var last time.Time
+	replay := func(record *archive.Record) error {
+		incoming, err := spinnaker.DecodeWebhook(bytes.NewReader(record.Body))
+		if err != nil {
+			logrus.WithError(err).Warn("skipping payload that isn't a webhook")
+			skipped++
+			return nil
+		}
+
+		received := record.Received
+		if received.IsZero() {
+			received = incoming.Details.Created.Time
+		}
+		if !filter.matches(received, incoming) {
+			skipped++
+			return nil
+		}
+
+		if wait := interval - time.Since(last); !last.IsZero() && wait > 0 {
+			time.Sleep(wait)
+		}
+		last = time.Now()
+
+		logger := logrus.WithFields(logrus.Fields{
+			"application": incoming.Details.Application,
+			"hook_type":   incoming.Details.Type,
+			"received":    received,
+		})
+		if err := send(record.Body, incoming); err != nil {
+			logger.WithError(err).Error("could not replay webhook")
+			failed++
+			return nil
+		}
+
+		logger.Info("replayed webhook")
+		replayed++
+		return nil
+	}
+
+	for _, file := range replayFiles(c.Args()) {
+		if err := archive.ReadFile(file, replay); err != nil {
+			return errors.Wrapf(err, "could not replay %s", file)
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"replayed": replayed,
+		"skipped":  skipped,
+		"failed":   failed,
+	}).Info("replay finished")
+
+	if failed > 0 {
+		return fmt.Errorf("%d webhooks could not be replayed", failed)
+	}
+
+	return nil
+}
+
+// replayFiles expands archive directories into their files, oldest first
+func replayFiles(args []string) []string {
+	var files []string
+	for _, arg := range args {
+		if info, err := os.Stat(arg); err == nil && info.IsDir() {
+			if archived, err := archive.Files(arg, ""); err == nil {
+				files = append(files, archived...)
+				continue
+			}
+		}
+
+		files = append(files, arg)
+	}
+
+	return files
+}
+
+// replaySender returns a function that sends a webhook either to a running
+// bridge or through a dispatcher built from the global flags
+func replaySender(c *cli.Context) (func(body []byte, incoming *types.IncomingWebhook) error, func(), error) {
+	if target := c.String("target"); target != "" {
+		if c.Bool("preserve-timestamps") {
+			logrus.Warn("timestamps can't be preserved when replaying to a running bridge")
+		}
+
+		client := &http.Client{Timeout: time.Second * 30}
+		return func(body []byte, _ *types.IncomingWebhook) error {
+			resp, err := client.Post(target, "application/json", bytes.NewReader(body))
+			if err != nil {
+				return errors.Wrap(err, "could not send webhook")
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNoContent {
+				return fmt.Errorf("bridge responded with %d", resp.StatusCode)
+			}
+
+			return nil
+		}, func() {}, nil
+	}
+
+	parent := c.Parent()
+	if parent.Bool("datadog-metrics") || parent.Bool("datadog-logs") {
+		logrus.Warn("metrics and logs aren't submitted when replaying, Datadog would date them now")
+	}
+
+	b, err := newBridge(parent, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.Bool("preserve-timestamps") {
+		b.spout.PreserveTimestamps()
+	}
+
+	return func(_ []byte, incoming *types.IncomingWebhook) error {
+		var failed error
+		for result := range b.dispatcher.Dispatch(incoming) {
+			if result.Err != nil {
+				failed = errors.Wrapf(result.Err, "%s failed", result.HandlerName)
+			}
+		}
+
+		return failed
+	}, b.Close, nil
+}
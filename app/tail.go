@@ -0,0 +1,141 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/server"
+)
+
+// ANSI colors used by the tail feed
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorGray   = "\033[90m"
+)
+
+// tailReconnect is how long tail waits before reconnecting to the stream
+const tailReconnect = time.Second * 2
+
+var tailCommand = cli.Command{
+	Name:   "tail",
+	Usage:  "Print the webhooks a running bridge dispatches as they happen",
+	Action: tailAction,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:   "server",
+			Usage:  "The address of the bridge's admin API",
+			EnvVar: "TAIL_SERVER",
+			Value:  "http://localhost:3001",
+		},
+		cli.StringFlag{
+			Name:  "application",
+			Usage: "Only print webhooks for applications matching this glob",
+		},
+		cli.StringFlag{
+			Name:  "type",
+			Usage: "Only print webhooks with hook types matching this glob",
+		},
+		cli.BoolFlag{
+			Name:  "no-color",
+			Usage: "Print the feed without colors",
+		},
+	},
+}
+
+func tailAction(c *cli.Context) error {
+	streamURL, err := url.Parse(strings.TrimSuffix(c.String("server"), "/") + "/admin/stream")
+	if err != nil {
+		return errors.Wrap(err, "could not parse server address")
+	}
+	query := streamURL.Query()
+	if application := c.String("application"); application != "" {
+		query.Set("application", application)
+	}
+	if hookType := c.String("type"); hookType != "" {
+		query.Set("type", hookType)
+	}
+	streamURL.RawQuery = query.Encode()
+
+	p := &tailPrinter{out: os.Stdout, color: !c.Bool("no-color")}
+	for {
+		if err := tail(streamURL.String(), p); err != nil {
+			logrus.WithError(err).Warn("lost connection to the bridge, reconnecting")
+		}
+		time.Sleep(tailReconnect)
+	}
+}
+
+func tail(streamURL string, p *tailPrinter) error {
+	resp, err := http.Get(streamURL)
+	if err != nil {
+		return errors.Wrap(err, "could not connect to stream")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("stream responded with %d", resp.StatusCode)
+	}
+
+	return server.ReadStream(resp.Body, p.print)
+}
+
+// tailPrinter prints a line for every webhook, followed by an indented line
+// for every handler result
+type tailPrinter struct {
+	out   io.Writer
+	color bool
+}
+
+func (p *tailPrinter) paint(color, s string) string {
+	if !p.color {
+		return s
+	}
+
+	return color + s + colorReset
+}
+
+func (p *tailPrinter) print(webhook *server.RecentWebhook) error {
+	status := fmt.Sprintf("%d", webhook.Status)
+	switch {
+	case webhook.Status >= 500:
+		status = p.paint(colorRed, status)
+	case webhook.Status >= 400:
+		status = p.paint(colorYellow, status)
+	default:
+		status = p.paint(colorGreen, status)
+	}
+
+	line := fmt.Sprintf("%s %s %s %s",
+		p.paint(colorGray, webhook.Received.Local().Format("15:04:05")),
+		status,
+		webhook.Application,
+		webhook.HookType,
+	)
+	if webhook.Error != "" {
+		line += " " + p.paint(colorYellow, webhook.Error)
+	}
+	fmt.Fprintln(p.out, line)
+
+	for _, result := range webhook.Results {
+		outcome := p.paint(colorGreen, "ok")
+		if result.Error != "" {
+			outcome = p.paint(colorRed, "error: "+result.Error)
+		}
+
+		fmt.Fprintf(p.out, "  %s %s %s\n", result.Handler, outcome, p.paint(colorGray, result.Duration))
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ReadFile calls f with every record in the file, in order. Besides archive
+// files it reads files of raw webhooks: a single webhook, a JSON array of
+// webhooks or one webhook per line. Raw webhooks have no received time.
+// Files ending in .gz are decompressed, a truncated stream (the file of a
+// writer that didn't close it) is read up to its last complete record.
+func ReadFile(path string, f func(*Record) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "could not open payload file")
+	}
+	defer file.Close()
+
+	var r io.Reader = bufio.NewReader(file)
+	compressed := strings.HasSuffix(path, gzipExtension)
+	if compressed {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return errors.Wrap(err, "could not decompress payload file")
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	err = Read(r, f)
+	if compressed && errors.Cause(err) == io.ErrUnexpectedEOF {
+		logrus.WithField("file", path).Warn("compressed payload file is truncated, skipping its incomplete end")
+		return nil
+	}
+
+	return err
+}
+
+// Read calls f with every record read from r, see ReadFile
+func Read(r io.Reader, f func(*Record) error) error {
+	dec := json.NewDecoder(r)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "could not decode payload")
+		}
+
+		values := []json.RawMessage{raw}
+		if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+			values = nil
+			if err := json.Unmarshal(raw, &values); err != nil {
+				return errors.Wrap(err, "could not decode payloads")
+			}
+		}
+
+		for _, value := range values {
+			record, err := toRecord(value)
+			if err != nil {
+				return err
+			}
+			if err := f(record); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// toRecord returns the archive record the value is, or wraps the value in a
+// record when it is a raw webhook
+func toRecord(value json.RawMessage) (*Record, error) {
+	record := new(Record)
+	if err := json.Unmarshal(value, record); err != nil {
+		return nil, errors.Wrap(err, "could not decode payload")
+	}
+
+	if len(record.Body) == 0 || record.Received.IsZero() {
+		return &Record{Body: value}, nil
+	}
+
+	return record, nil
+}
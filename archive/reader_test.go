@@ -0,0 +1,134 @@
+package archive_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/archive"
+)
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		scenario string
+		input    string
+		bodies   []string
+		received []bool
+	}{
+		{
+			scenario: "Archive records",
+			input: `{"received": "2018-02-09T22:06:40Z", "body": {"details": {"type": "a"}}}
+{"received": "2018-02-09T22:06:41Z", "body": "not json"}`,
+			bodies:   []string{`{"details": {"type": "a"}}`, `"not json"`},
+			received: []bool{true, true},
+		},
+		{
+			scenario: "A single raw webhook",
+			input:    `{"details": {"type": "a"}}`,
+			bodies:   []string{`{"details": {"type": "a"}}`},
+			received: []bool{false},
+		},
+		{
+			scenario: "An array of raw webhooks",
+			input:    `[{"details": {"type": "a"}}, {"details": {"type": "b"}}]`,
+			bodies:   []string{`{"details": {"type": "a"}}`, `{"details": {"type": "b"}}`},
+			received: []bool{false, false},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			var records []*archive.Record
+			require.NoError(t, archive.Read(strings.NewReader(test.input), func(record *archive.Record) error {
+				records = append(records, record)
+				return nil
+			}))
+
+			require.Len(t, records, len(test.bodies))
+			for i, record := range records {
+				assert.JSONEq(t, test.bodies[i], string(record.Body))
+				assert.Equal(t, test.received[i], !record.Received.IsZero())
+			}
+		})
+	}
+}
+
+func TestReadFileReadsWhatWasWritten(t *testing.T) {
+	dir := t.TempDir()
+	w, err := archive.NewWriter(archive.Options{Dir: dir, Gzip: true})
+	require.NoError(t, err)
+
+	received := time.Date(2018, 2, 9, 22, 6, 40, 0, time.UTC)
+	require.NoError(t, w.Write(archive.NewRecord(received, nil, []byte(`{"details": {"type": "a"}}`))))
+	require.NoError(t, w.Close())
+
+	files, err := archive.Files(dir, "")
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	var records []*archive.Record
+	require.NoError(t, archive.ReadFile(files[0], func(record *archive.Record) error {
+		records = append(records, record)
+		return nil
+	}))
+	require.Len(t, records, 1)
+	assert.True(t, received.Equal(records[0].Received))
+
+	t.Run("Fixtures can be replayed", func(t *testing.T) {
+		var count int
+		require.NoError(t, archive.ReadFile(filepath.Join("..", "spinnaker", "testdata", "valid-webhook.json"), func(*archive.Record) error {
+			count++
+			return nil
+		}))
+		assert.Equal(t, 1, count)
+	})
+}
+
+func TestReadFileToleratesTruncatedGzip(t *testing.T) {
+	dir := t.TempDir()
+	w, err := archive.NewWriter(archive.Options{Dir: dir, Gzip: true})
+	require.NoError(t, err)
+
+	received := time.Date(2018, 2, 9, 22, 6, 40, 0, time.UTC)
+	for _, hookType := range []string{"a", "b"} {
+		body := `{"details": {"type": "` + hookType + `"}}`
+		require.NoError(t, w.Write(archive.NewRecord(received, nil, []byte(body))))
+	}
+	require.NoError(t, w.Close())
+
+	files, err := archive.Files(dir, "")
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	// Records are flushed as they are written, cutting the end of the stream
+	// and its trailer is what's left when the bridge is killed
+	info, err := os.Stat(files[0])
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(files[0], info.Size()-10))
+
+	var records int
+	require.NoError(t, archive.ReadFile(files[0], func(*archive.Record) error {
+		records++
+		return nil
+	}))
+	assert.Equal(t, 2, records)
+
+	t.Run("Records cut in the middle are skipped", func(t *testing.T) {
+		require.NoError(t, os.Truncate(files[0], info.Size()/2))
+
+		assert.NoError(t, archive.ReadFile(files[0], func(*archive.Record) error { return nil }))
+	})
+
+	t.Run("Truncated plain files are still an error", func(t *testing.T) {
+		file := filepath.Join(dir, "truncated.ndjson")
+		require.NoError(t, ioutil.WriteFile(file, []byte(`{"details": {"type": "a"}}`+"\n"+`{"details": `), 0644))
+
+		assert.Error(t, archive.ReadFile(file, func(*archive.Record) error { return nil }))
+	})
+}
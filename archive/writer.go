@@ -0,0 +1,286 @@
+// Package archive records raw webhooks to rotating NDJSON files so they can be
+// used to debug the bridge, build fixtures or replay webhooks later.
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultPrefix         = "webhooks"
+	defaultMaxBytes       = 100 << 20
+	defaultRotateInterval = time.Hour * 24
+
+	// fileTimeFormat sorts lexically in time order
+	fileTimeFormat = "20060102T150405.000"
+	extension      = ".ndjson"
+	gzipExtension  = ".gz"
+	redactedHeader = "[REDACTED]"
+)
+
+// redactedHeaders are never written to the archive
+var redactedHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+// Record is a single archived webhook
+type Record struct {
+	Received time.Time   `json:"received"`
+	Headers  http.Header `json:"headers,omitempty"`
+	// Body is the raw request body, bodies that aren't JSON are archived as a
+	// JSON string
+	Body json.RawMessage `json:"body"`
+}
+
+// NewRecord builds a record for a raw request
+func NewRecord(received time.Time, headers http.Header, body []byte) *Record {
+	record := &Record{Received: received, Headers: make(http.Header, len(headers))}
+	for name, values := range headers {
+		record.Headers[name] = values
+	}
+	for _, name := range redactedHeaders {
+		if record.Headers.Get(name) != "" {
+			record.Headers.Set(name, redactedHeader)
+		}
+	}
+
+	if json.Valid(body) {
+		record.Body = json.RawMessage(body)
+	} else {
+		record.Body, _ = json.Marshal(string(body))
+	}
+
+	return record
+}
+
+// Options configures where records are written and when files are rotated
+// and removed
+type Options struct {
+	// Dir is the directory archive files are written to
+	Dir string
+	// Prefix of every archive file name, defaults to "webhooks"
+	Prefix string
+	// MaxBytes is how large (uncompressed) a file gets before it is rotated,
+	// defaults to 100MiB
+	MaxBytes int64
+	// RotateInterval is how long a file is written to before it is rotated,
+	// defaults to 24 hours
+	RotateInterval time.Duration
+	// Gzip compresses archive files
+	Gzip bool
+	// MaxFiles is how many archive files are kept, zero keeps every file
+	MaxFiles int
+	// Retention is how long archive files are kept, zero keeps them forever
+	Retention time.Duration
+}
+
+// Writer appends records to the current archive file, rotating it when it is
+// too large or too old
+type Writer struct {
+	opts Options
+	now  func() time.Time
+
+	mu      sync.Mutex
+	file    *os.File
+	gz      *gzip.Writer
+	buf     *bufio.Writer
+	opened  time.Time
+	written int64
+}
+
+// NewWriter initializes a writer, creating the archive directory if needed.
+// Files are only created once the first record is written.
+func NewWriter(opts Options) (*Writer, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("archive directory is required")
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = defaultPrefix
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = defaultMaxBytes
+	}
+	if opts.RotateInterval <= 0 {
+		opts.RotateInterval = defaultRotateInterval
+	}
+
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "could not create archive directory")
+	}
+
+	return &Writer{opts: opts, now: time.Now}, nil
+}
+
+// Write appends the record to the archive
+func (w *Writer) Write(record *Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "could not encode record")
+	}
+	line = append(line, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := w.now()
+	if w.file != nil && (w.written+int64(len(line)) > w.opts.MaxBytes || now.Sub(w.opened) >= w.opts.RotateInterval) {
+		if err := w.close(); err != nil {
+			return err
+		}
+	}
+
+	if w.file == nil {
+		if err := w.open(now); err != nil {
+			return err
+		}
+	}
+
+	if _, err := w.buf.Write(line); err != nil {
+		return errors.Wrap(err, "could not write record")
+	}
+	w.written += int64(len(line))
+
+	// Every record is flushed so a crash loses as little as possible
+	return w.flush()
+}
+
+func (w *Writer) open(now time.Time) error {
+	name := w.opts.Prefix + "-" + now.UTC().Format(fileTimeFormat) + extension
+	if w.opts.Gzip {
+		name += gzipExtension
+	}
+
+	file, err := os.OpenFile(filepath.Join(w.opts.Dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrap(err, "could not create archive file")
+	}
+
+	var out io.Writer = file
+	w.gz = nil
+	if w.opts.Gzip {
+		w.gz = gzip.NewWriter(file)
+		out = w.gz
+	}
+
+	w.file, w.buf, w.opened, w.written = file, bufio.NewWriter(out), now, 0
+
+	return w.removeExpired(now)
+}
+
+func (w *Writer) flush() error {
+	if err := w.buf.Flush(); err != nil {
+		return errors.Wrap(err, "could not flush archive file")
+	}
+	if w.gz != nil {
+		if err := w.gz.Flush(); err != nil {
+			return errors.Wrap(err, "could not flush archive file")
+		}
+	}
+
+	return nil
+}
+
+func (w *Writer) close() error {
+	if w.file == nil {
+		return nil
+	}
+
+	err := w.flush()
+	if w.gz != nil {
+		if gzErr := w.gz.Close(); err == nil {
+			err = gzErr
+		}
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	w.file, w.gz, w.buf = nil, nil, nil
+
+	return errors.Wrap(err, "could not close archive file")
+}
+
+// Close closes the current archive file
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.close()
+}
+
+// Files returns the archive files in the directory, oldest first
+func (w *Writer) Files() ([]string, error) {
+	return Files(w.opts.Dir, w.opts.Prefix)
+}
+
+// Files returns the archive files with the given prefix in the directory,
+// oldest first
+func Files(dir, prefix string) ([]string, error) {
+	if prefix == "" {
+		prefix = defaultPrefix
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list archive directory")
+	}
+
+	var files []string
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasPrefix(name, prefix+"-") {
+			continue
+		}
+		if strings.HasSuffix(name, extension) || strings.HasSuffix(name, extension+gzipExtension) {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// removeExpired deletes the files beyond the retention limits. The current
+// file is never removed.
+func (w *Writer) removeExpired(now time.Time) error {
+	if w.opts.MaxFiles <= 0 && w.opts.Retention <= 0 {
+		return nil
+	}
+
+	files, err := w.Files()
+	if err != nil {
+		return err
+	}
+
+	for i, file := range files {
+		if file == w.file.Name() {
+			continue
+		}
+
+		expired := w.opts.MaxFiles > 0 && len(files)-i > w.opts.MaxFiles
+		if !expired && w.opts.Retention > 0 {
+			if info, err := os.Stat(file); err == nil && now.Sub(info.ModTime()) > w.opts.Retention {
+				expired = true
+			}
+		}
+
+		if expired {
+			if err := os.Remove(file); err != nil {
+				logrus.WithError(err).WithField("file", file).Warn("could not remove expired archive file")
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package archive_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/archive"
+)
+
+func readRecords(t *testing.T, file string) []*archive.Record {
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(file, ".gz") {
+		gz, err := gzip.NewReader(f)
+		require.NoError(t, err)
+		r = gz
+	}
+
+	var records []*archive.Record
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		record := new(archive.Record)
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), record))
+		records = append(records, record)
+	}
+	require.NoError(t, scanner.Err())
+
+	return records
+}
+
+func TestNewRecord(t *testing.T) {
+	received := time.Unix(1518214000, 0)
+	headers := http.Header{"Authorization": {"Basic c2VjcmV0"}, "Content-Type": {"application/json"}}
+
+	record := archive.NewRecord(received, headers, []byte(`{"details": {}}`))
+	assert.Equal(t, "[REDACTED]", record.Headers.Get("Authorization"))
+	assert.Equal(t, "application/json", record.Headers.Get("Content-Type"))
+	assert.Equal(t, "Basic c2VjcmV0", headers.Get("Authorization"), "the request headers are left alone")
+	assert.JSONEq(t, `{"details": {}}`, string(record.Body))
+
+	record = archive.NewRecord(received, nil, []byte(`not json`))
+	assert.Equal(t, `"not json"`, string(record.Body))
+}
+
+func TestWriter(t *testing.T) {
+	tests := []struct {
+		scenario string
+		opts     archive.Options
+		files    int
+		records  int
+	}{
+		{
+			scenario: "Records are appended to a single file",
+			opts:     archive.Options{},
+			files:    1,
+			records:  3,
+		},
+		{
+			scenario: "Files are rotated when they get too large",
+			opts:     archive.Options{MaxBytes: 100},
+			files:    3,
+			records:  3,
+		},
+		{
+			scenario: "Files are rotated when they get too old",
+			opts:     archive.Options{RotateInterval: time.Nanosecond, Gzip: true},
+			files:    3,
+			records:  3,
+		},
+		{
+			scenario: "Only the newest files are kept",
+			opts:     archive.Options{MaxBytes: 100, MaxFiles: 2},
+			files:    2,
+			records:  2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "archive")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			test.opts.Dir = dir
+			w, err := archive.NewWriter(test.opts)
+			require.NoError(t, err)
+
+			for i := 0; i < 3; i++ {
+				body := `{"details": {"type": "orca:pipeline:complete"}, "content": {"executionId": "` + strings.Repeat("a", 20) + `"}}`
+				require.NoError(t, w.Write(archive.NewRecord(time.Now(), nil, []byte(body))))
+				// File names have millisecond precision
+				time.Sleep(time.Millisecond * 2)
+			}
+			require.NoError(t, w.Close())
+
+			files, err := w.Files()
+			require.NoError(t, err)
+			require.Len(t, files, test.files)
+
+			var records []*archive.Record
+			for _, file := range files {
+				if test.opts.Gzip {
+					assert.True(t, strings.HasSuffix(file, ".ndjson.gz"), file)
+				}
+				records = append(records, readRecords(t, file)...)
+			}
+			assert.Len(t, records, test.records)
+		})
+	}
+}
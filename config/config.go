@@ -0,0 +1,562 @@
+// Package config reads the configuration of the whole bridge from a single
+// YAML file. Every setting of the file maps to a command line flag, flags (and
+// their environment variables) win over the file.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnakerchat"
+	spinnakerdatadog "github.com/bobbytables/spinnaker-datadog-bridge/spinnakerdatadog"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnakerhttp"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnakerpagerduty"
+)
+
+// Config is the representation of the configuration file. Fields tagged with
+// "flag" are applied to the flag of that name by Apply.
+type Config struct {
+	Server    Server    `yaml:"server"`
+	Datadog   Datadog   `yaml:"datadog"`
+	Forward   Forward   `yaml:"forward"`
+	RateLimit RateLimit `yaml:"rateLimit"`
+	Archive   Archive   `yaml:"archive"`
+
+	// TemplatesFile is an event templates file to load instead of Templates
+	TemplatesFile *string `yaml:"templatesFile" flag:"event-templates"`
+	// Templates are the event templates and the handlers (forward, slack,
+	// teams, pagerduty) registered for each hook type, in the format of the
+	// event templates file
+	Templates map[string]interface{} `yaml:"templates"`
+	// Handlers are built by the handler type registry, each one is attached
+	// to the listed hook types
+	Handlers []spinnaker.HandlerConfig `yaml:"handlers"`
+}
+
+// Server configures the webhook and admin servers
+type Server struct {
+	Addr           *string        `yaml:"addr" flag:"addr"`
+	AdminAddr      *string        `yaml:"adminAddr" flag:"admin-addr"`
+	MaxBodyBytes   *int64         `yaml:"maxBodyBytes" flag:"max-body-bytes"`
+	WebhookTimeout *time.Duration `yaml:"webhookTimeout" flag:"webhook-timeout"`
+	WebhookResults *bool          `yaml:"webhookResults" flag:"webhook-results"`
+	RecentWebhooks *int           `yaml:"recentWebhooks" flag:"recent-webhooks"`
+	DeckURL        *string        `yaml:"deckURL" flag:"deck-url"`
+	Debug          *bool          `yaml:"debug" flag:"debug"`
+	TLS            TLS            `yaml:"tls"`
+}
+
+// TLS configures serving webhooks over TLS and verifying client certificates
+type TLS struct {
+	Cert           *string        `yaml:"cert" flag:"tls-cert"`
+	Key            *string        `yaml:"key" flag:"tls-key"`
+	ClientCA       *string        `yaml:"clientCA" flag:"tls-client-ca"`
+	AllowedClients []string       `yaml:"allowedClients" flag:"tls-allowed-client"`
+	ReloadInterval *time.Duration `yaml:"reloadInterval" flag:"tls-reload-interval"`
+}
+
+// Datadog configures the credentials of the Datadog organizations and how
+// the bridge connects to them
+type Datadog struct {
+	APIKey                *string        `yaml:"apiKey" flag:"datadog-api-key"`
+	AppKey                *string        `yaml:"appKey" flag:"datadog-app-key"`
+	Site                  *string        `yaml:"site" flag:"datadog-site"`
+	APIURL                *string        `yaml:"apiURL" flag:"datadog-api-url"`
+	Proxy                 *string        `yaml:"proxy" flag:"datadog-proxy"`
+	Timeout               *time.Duration `yaml:"timeout" flag:"datadog-timeout"`
+	CABundle              *string        `yaml:"caBundle" flag:"datadog-ca-bundle"`
+	TLSMinVersion         *string        `yaml:"tlsMinVersion" flag:"datadog-tls-min-version"`
+	TLSInsecureSkipVerify *bool          `yaml:"tlsInsecureSkipVerify" flag:"datadog-tls-insecure-skip-verify"`
+
+	// RoutesFile is a routing file to load instead of Default, Organizations
+	// and Routes
+	RoutesFile    *string                 `yaml:"routesFile" flag:"datadog-routes"`
+	Default       string                  `yaml:"default"`
+	Organizations map[string]Organization `yaml:"organizations"`
+	Routes        []Route                 `yaml:"routes"`
+
+	Logs            DatadogLogs     `yaml:"logs"`
+	Metrics         DatadogMetrics  `yaml:"metrics"`
+	PipelineSummary PipelineSummary `yaml:"pipelineSummary"`
+	ManualJudgments ManualJudgments `yaml:"manualJudgments"`
+	CanaryAnalysis  CanaryAnalysis  `yaml:"canaryAnalysis"`
+}
+
+// Organization is a set of Datadog credentials applications are routed to
+type Organization struct {
+	APIKey string `yaml:"apiKey"`
+	AppKey string `yaml:"appKey,omitempty"`
+}
+
+// Route sends the applications matching any of the glob patterns to an
+// organization
+type Route struct {
+	Applications []string `yaml:"applications"`
+	Organization string   `yaml:"organization"`
+}
+
+// DatadogLogs configures sending webhooks to the Datadog logs intake
+type DatadogLogs struct {
+	Enabled       *bool          `yaml:"enabled" flag:"datadog-logs"`
+	IntakeURL     *string        `yaml:"intakeURL" flag:"datadog-logs-intake-url"`
+	TemplatedOnly *bool          `yaml:"templatedOnly" flag:"datadog-logs-templated-only"`
+	Tags          []string       `yaml:"tags" flag:"datadog-logs-tag"`
+	BatchSize     *int           `yaml:"batchSize" flag:"datadog-logs-batch-size"`
+	FlushInterval *time.Duration `yaml:"flushInterval" flag:"datadog-logs-flush-interval"`
+}
+
+// DatadogMetrics configures submitting webhook metrics to Datadog
+type DatadogMetrics struct {
+	Enabled       *bool          `yaml:"enabled" flag:"datadog-metrics"`
+	FlushInterval *time.Duration `yaml:"flushInterval" flag:"datadog-metrics-flush-interval"`
+	MaxSeries     *int           `yaml:"maxSeries" flag:"datadog-metrics-max-series"`
+	Tags          []string       `yaml:"tags" flag:"datadog-metrics-tag"`
+}
+
+// PipelineSummary configures pipeline summary events
+type PipelineSummary struct {
+	Enabled        *bool   `yaml:"enabled" flag:"pipeline-summary"`
+	SuppressStages *bool   `yaml:"suppressStages" flag:"pipeline-summary-suppress-stages"`
+	Title          *string `yaml:"title" flag:"pipeline-summary-title"`
+}
+
+// ManualJudgments configures manual judgment events, reminders and metrics
+type ManualJudgments struct {
+	Enabled          *bool          `yaml:"enabled" flag:"manual-judgments"`
+	ReminderInterval *time.Duration `yaml:"reminderInterval" flag:"manual-judgment-reminder-interval"`
+}
+
+// CanaryAnalysis configures canary analysis events and metrics
+type CanaryAnalysis struct {
+	Enabled    *bool   `yaml:"enabled" flag:"canary-analysis"`
+	KayentaURL *string `yaml:"kayentaURL" flag:"kayenta-url"`
+}
+
+// Forward configures the HTTP sinks: forwards, chat notifications and alerts
+type Forward struct {
+	Timeout *time.Duration `yaml:"timeout" flag:"forward-timeout"`
+}
+
+// RateLimit configures how fast webhooks are sent to each destination
+type RateLimit struct {
+	Rate             *float64       `yaml:"rate" flag:"rate-limit"`
+	Burst            *int           `yaml:"burst" flag:"rate-limit-burst"`
+	PerApplication   *float64       `yaml:"perApplication" flag:"rate-limit-per-application"`
+	ApplicationBurst *int           `yaml:"applicationBurst" flag:"rate-limit-application-burst"`
+	Policy           *string        `yaml:"policy" flag:"rate-limit-policy"`
+	MaxWait          *time.Duration `yaml:"maxWait" flag:"rate-limit-max-wait"`
+}
+
+// Archive configures recording raw webhooks to disk
+type Archive struct {
+	Dir            *string        `yaml:"dir" flag:"archive-dir"`
+	MaxBytes       *int64         `yaml:"maxBytes" flag:"archive-max-bytes"`
+	RotateInterval *time.Duration `yaml:"rotateInterval" flag:"archive-rotate-interval"`
+	Gzip           *bool          `yaml:"gzip" flag:"archive-gzip"`
+	MaxFiles       *int           `yaml:"maxFiles" flag:"archive-max-files"`
+	Retention      *time.Duration `yaml:"retention" flag:"archive-retention"`
+}
+
+// templateEntry is what the handlers read from a hook type entry of the
+// templates, it is only used to validate them
+type templateEntry struct {
+	spinnakerdatadog.EventTemplate
+	Forward   []spinnakerhttp.ForwardConfig     `json:"forward"`
+	Slack     []spinnakerchat.DestinationConfig `json:"slack"`
+	Teams     []spinnakerchat.DestinationConfig `json:"teams"`
+	PagerDuty []spinnakerpagerduty.AlertConfig  `json:"pagerduty"`
+}
+
+// handlerOptions are the options of the built-in handler types. Options of
+// types registered with spinnaker.RegisterHandlerType, which replace the
+// built-in types of the same name, are checked when they are built.
+var handlerOptions = map[string]reflect.Type{
+	spinnakerdatadog.EventHandlerType:   reflect.TypeOf(spinnakerdatadog.EventTemplate{}),
+	spinnakerdatadog.MetricHandlerType:  reflect.TypeOf(struct{}{}),
+	spinnakerhttp.ForwardHandlerType:    reflect.TypeOf(spinnakerhttp.ForwardConfig{}),
+	spinnakerchat.SlackHandlerType:      reflect.TypeOf(spinnakerchat.DestinationConfig{}),
+	spinnakerchat.TeamsHandlerType:      reflect.TypeOf(spinnakerchat.DestinationConfig{}),
+	spinnakerpagerduty.AlertHandlerType: reflect.TypeOf(spinnakerpagerduty.AlertConfig{}),
+}
+
+// Load reads the configuration file at the given path, environment variables
+// are interpolated from the process environment
+func Load(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read config file")
+	}
+
+	cfg, err := Parse(b, os.LookupEnv)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid config file %s", path)
+	}
+
+	return cfg, nil
+}
+
+// Parse reads a configuration from YAML. ${VAR} and ${VAR:-default} in values
+// are replaced with the variable returned by lookup, $${ is a literal ${.
+// Unknown fields and values of the wrong type are rejected, including the ones
+// of the templates and of the options of the built-in handler types.
+func Parse(b []byte, lookup func(string) (string, bool)) (*Config, error) {
+	cfg := &Config{}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind == 0 {
+		return cfg, nil
+	}
+
+	if err := interpolate(&doc, lookup); err != nil {
+		return nil, err
+	}
+	if err := checkFields(&doc, reflect.TypeOf(cfg), ""); err != nil {
+		return nil, err
+	}
+	if err := checkSections(doc.Content[0]); err != nil {
+		return nil, err
+	}
+	if err := doc.Decode(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Validate checks sections that can come from a separate file aren't also
+// configured inline
+func (c *Config) Validate() error {
+	if c.TemplatesFile != nil && len(c.Templates) > 0 {
+		return errors.New("templates and templatesFile can't both be set")
+	}
+
+	if c.Datadog.RoutesFile != nil && c.hasRoutes() {
+		return errors.New("datadog.routesFile can't be set with datadog.default, organizations or routes")
+	}
+
+	return nil
+}
+
+func (c *Config) hasRoutes() bool {
+	return c.Datadog.Default != "" || len(c.Datadog.Organizations) > 0 || len(c.Datadog.Routes) > 0
+}
+
+// TemplatesYAML returns the inline templates in the format of the event
+// templates file, or nil when there aren't any
+func (c *Config) TemplatesYAML() ([]byte, error) {
+	if len(c.Templates) == 0 {
+		return nil, nil
+	}
+
+	b, err := yaml.Marshal(c.Templates)
+	return b, errors.Wrap(err, "could not marshal templates")
+}
+
+// RoutesYAML returns the inline organizations and routes in the format of the
+// routing file, or nil when there aren't any
+func (c *Config) RoutesYAML() ([]byte, error) {
+	if !c.hasRoutes() {
+		return nil, nil
+	}
+
+	b, err := yaml.Marshal(struct {
+		Default       string                  `yaml:"default,omitempty"`
+		Organizations map[string]Organization `yaml:"organizations"`
+		Routes        []Route                 `yaml:"routes"`
+	}{c.Datadog.Default, c.Datadog.Organizations, c.Datadog.Routes})
+	return b, errors.Wrap(err, "could not marshal routes")
+}
+
+// FlagSet is the set of flags a configuration is applied to, it is
+// implemented by *cli.Context
+type FlagSet interface {
+	IsSet(name string) bool
+	Set(name, value string) error
+}
+
+// Apply sets every flag configured in the file that wasn't set on the command
+// line or through its environment variable
+func (c *Config) Apply(flags FlagSet) error {
+	return apply(reflect.ValueOf(c).Elem(), flags)
+}
+
+func apply(v reflect.Value, flags FlagSet) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field, value := t.Field(i), v.Field(i)
+
+		name := field.Tag.Get("flag")
+		if name == "" {
+			if value.Kind() == reflect.Struct {
+				if err := apply(value, flags); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if value.IsNil() || flags.IsSet(name) {
+			continue
+		}
+
+		for _, s := range flagValues(value) {
+			if err := flags.Set(name, s); err != nil {
+				return errors.Wrapf(err, "could not set %s from config", name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// flagValues formats a configured value the way its flag parses it. Slices
+// are set one element at a time.
+func flagValues(v reflect.Value) []string {
+	switch value := v.Interface().(type) {
+	case []string:
+		return value
+	case *time.Duration:
+		return []string{value.String()}
+	}
+
+	return []string{fmt.Sprint(v.Elem().Interface())}
+}
+
+var envPattern = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// interpolate replaces environment variables in every scalar of the document
+func interpolate(n *yaml.Node, lookup func(string) (string, bool)) error {
+	if n.Kind == yaml.ScalarNode && strings.Contains(n.Value, "${") {
+		var missing []string
+		value := envPattern.ReplaceAllStringFunc(n.Value, func(match string) string {
+			if match == "$${" {
+				return "${"
+			}
+
+			groups := envPattern.FindStringSubmatch(match)
+			if v, ok := lookup(groups[1]); ok {
+				return v
+			}
+			if groups[2] != "" {
+				return groups[3]
+			}
+
+			missing = append(missing, groups[1])
+			return match
+		})
+		if len(missing) > 0 {
+			return errors.Errorf("line %d: environment variable %s is not set", n.Line, strings.Join(missing, ", "))
+		}
+
+		n.Value = value
+		// Plain values are resolved again so they can become numbers or
+		// booleans, quoted values stay strings
+		if n.Style&(yaml.SingleQuotedStyle|yaml.DoubleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+			n.Tag = ""
+		}
+	}
+
+	for _, child := range n.Content {
+		if err := interpolate(child, lookup); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkFields rejects the keys of the document that aren't fields of the type
+// they are decoded into and values of the wrong kind, path is the position of
+// the node in the document
+func checkFields(n *yaml.Node, t reflect.Type, path string) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch n.Kind {
+	case yaml.DocumentNode:
+		for _, child := range n.Content {
+			if err := checkFields(child, t, path); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		if t.Kind() == reflect.Interface {
+			return nil
+		}
+		if t.Kind() != reflect.Slice {
+			return errors.Errorf("line %d: %s can't be a list", n.Line, path)
+		}
+		for i, child := range n.Content {
+			if err := checkFields(child, t.Elem(), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		if t.Kind() != reflect.Map && t.Kind() != reflect.Struct && t.Kind() != reflect.Interface {
+			return errors.Errorf("line %d: %s can't be a mapping", n.Line, path)
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+
+			switch t.Kind() {
+			case reflect.Map:
+				if err := checkFields(value, t.Elem(), join(path, key.Value)); err != nil {
+					return err
+				}
+			case reflect.Struct:
+				field, ok := fieldByKey(t, key.Value)
+				if !ok {
+					return errors.Errorf("line %d: unknown field %s", key.Line, join(path, key.Value))
+				}
+				if err := checkFields(value, field.Type, join(path, key.Value)); err != nil {
+					return err
+				}
+			}
+		}
+	case yaml.ScalarNode:
+		return checkScalar(n, t, path)
+	}
+
+	return nil
+}
+
+// checkScalar rejects scalars that can't be decoded into booleans and numbers,
+// types that decode themselves (such as durations) are left to the decoder
+func checkScalar(n *yaml.Node, t reflect.Type, path string) error {
+	tag := n.ShortTag()
+	if tag == "!!null" || t == reflect.TypeOf(time.Duration(0)) {
+		return nil
+	}
+
+	var expected string
+	switch t.Kind() {
+	case reflect.Map, reflect.Struct:
+		return errors.Errorf("line %d: %s must be a mapping", n.Line, path)
+	case reflect.Slice:
+		return errors.Errorf("line %d: %s must be a list", n.Line, path)
+	case reflect.Bool:
+		if tag != "!!bool" {
+			expected = "a boolean"
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if tag != "!!int" {
+			expected = "an integer"
+		}
+	case reflect.Float32, reflect.Float64:
+		if tag != "!!int" && tag != "!!float" {
+			expected = "a number"
+		}
+	}
+	if expected == "" {
+		return nil
+	}
+
+	return errors.Errorf("line %d: %s must be %s, got %q", n.Line, path, expected, n.Value)
+}
+
+// checkSections checks the templates and the options of the handlers against
+// the types their handlers decode them into. Both are kept as plain values in
+// Config so they can be handed to the handlers as is.
+func checkSections(root *yaml.Node) error {
+	if root.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+
+		switch key.Value {
+		case "templates":
+			if err := checkFields(value, reflect.TypeOf(map[string]*templateEntry{}), key.Value); err != nil {
+				return err
+			}
+		case "handlers":
+			for j, handler := range value.Content {
+				if err := checkHandler(handler, fmt.Sprintf("handlers[%d]", j)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func checkHandler(n *yaml.Node, path string) error {
+	var handlerType string
+	var options *yaml.Node
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		switch n.Content[i].Value {
+		case "type":
+			handlerType = n.Content[i+1].Value
+		case "options":
+			options = n.Content[i+1]
+		}
+	}
+
+	t, ok := handlerOptions[handlerType]
+	if !ok || options == nil {
+		return nil
+	}
+	for _, registered := range spinnaker.DefaultRegistry.Types() {
+		if registered == handlerType {
+			return nil
+		}
+	}
+
+	return checkFields(options, t, path+".options")
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// fieldByKey finds the field of the struct decoded from the key. Fields of
+// the handlers are decoded from their json tags and embedded structs are
+// searched too.
+func fieldByKey(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if embedded, ok := fieldByKey(field.Type, key); ok {
+				return embedded, true
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+
+		tag, ok := field.Tag.Lookup("yaml")
+		if !ok {
+			tag = field.Tag.Get("json")
+		}
+		if name := strings.Split(tag, ",")[0]; name != "" && name != "-" && name == key {
+			return field, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
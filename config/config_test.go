@@ -0,0 +1,204 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/config"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnakerdatadog"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnakerhttp"
+)
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func readTestdata(t *testing.T, name string) []byte {
+	wd, _ := os.Getwd()
+	b, err := ioutil.ReadFile(filepath.Join(wd, "testdata", name))
+	require.NoError(t, err)
+	return b
+}
+
+func TestParsingConfig(t *testing.T) {
+	cfg, err := config.Parse(readTestdata(t, "bridge.yml"), env(map[string]string{
+		"DATADOG_API_KEY": "abc123",
+		"ENVIRONMENT":     "prod",
+		"HOOK_PATH":       "deploys",
+	}))
+	require.NoError(t, err)
+
+	assert.Equal(t, ":3000", *cfg.Server.Addr)
+	assert.True(t, *cfg.Server.WebhookResults)
+	assert.Nil(t, cfg.Server.MaxBodyBytes)
+	assert.Equal(t, "abc123", *cfg.Datadog.APIKey)
+	assert.Equal(t, 5*time.Second, *cfg.Datadog.Timeout)
+	assert.Equal(t, "platformkey", cfg.Datadog.Organizations["platform"].APIKey)
+	assert.Equal(t, []string{"env:prod", "team:delivery"}, cfg.Datadog.Metrics.Tags)
+	assert.Equal(t, 30*time.Minute, *cfg.Datadog.ManualJudgments.ReminderInterval)
+	assert.Equal(t, 2.5, *cfg.RateLimit.Rate)
+
+	t.Run("Inline templates load into every handler", func(t *testing.T) {
+		b, err := cfg.TemplatesYAML()
+		require.NoError(t, err)
+
+		spout, err := spinnakerdatadog.NewSpoutFromYAML(nil, b)
+		require.NoError(t, err)
+		require.Equal(t, 1, spout.TotalTemplates())
+		assert.Equal(t, "Cost ${COST} for {{ $.Content.ExecutionID }}", spout.Template("orca:pipeline:failed").Text)
+
+		forwarder, err := spinnakerhttp.NewForwarderFromYAML(nil, b)
+		require.NoError(t, err)
+		assert.Len(t, forwarder.Handlers()["orca:pipeline:failed"], 1)
+	})
+
+	t.Run("Inline routes load into a router", func(t *testing.T) {
+		b, err := cfg.RoutesYAML()
+		require.NoError(t, err)
+
+		router, err := spinnakerdatadog.LoadRouterFromYAML(b, spinnakerdatadog.NewOrganization(spinnakerdatadog.DefaultOrganization, "", ""))
+		require.NoError(t, err)
+		assert.Equal(t, "platform", router.Route("billing-api").Name)
+		assert.Equal(t, "platform", router.Route("anything").Name)
+	})
+}
+
+func TestInvalidConfig(t *testing.T) {
+	tests := []struct {
+		scenario string
+		yaml     string
+		err      string
+	}{
+		{
+			scenario: "Unknown fields are rejected with their line",
+			yaml:     "server:\n  addr: \":3000\"\n  adress: \":4000\"\n",
+			err:      "line 3: unknown field server.adress",
+		},
+		{
+			scenario: "Unknown sections are rejected",
+			yaml:     "statsd:\n  addr: localhost:8125\n",
+			err:      "line 1: unknown field statsd",
+		},
+		{
+			scenario: "Unknown fields of organizations are rejected",
+			yaml:     "datadog:\n  organizations:\n    platform:\n      apiKey: abc\n      apiSecret: def\n",
+			err:      "line 5: unknown field datadog.organizations.platform.apiSecret",
+		},
+		{
+			scenario: "Values of the wrong type are rejected with their line",
+			yaml:     "rateLimit:\n  burst: lots\n",
+			err:      "line 2",
+		},
+		{
+			scenario: "Interpolated values are type checked",
+			yaml:     "archive:\n  maxFiles: ${MAX_FILES}\n",
+			err:      "line 2",
+		},
+		{
+			scenario: "Sections of the wrong kind are rejected",
+			yaml:     "server:\n  tls: enabled\n",
+			err:      "line 2: server.tls must be a mapping",
+		},
+		{
+			scenario: "Unknown fields of templates are rejected",
+			yaml:     "templates:\n  orca:pipeline:failed:\n    titel: failed\n",
+			err:      "line 3: unknown field templates.orca:pipeline:failed.titel",
+		},
+		{
+			scenario: "Unknown fields of template handlers are rejected",
+			yaml:     "templates:\n  orca:pipeline:failed:\n    title: failed\n    forward:\n      - url: https://hooks.example.com\n        header:\n          X-Token: abc\n",
+			err:      "line 6: unknown field templates.orca:pipeline:failed.forward[0].header",
+		},
+		{
+			scenario: "Values of templates of the wrong type are rejected",
+			yaml:     "templates:\n  orca:pipeline:failed:\n    markdown: sometimes\n",
+			err:      "line 3: templates.orca:pipeline:failed.markdown must be a boolean",
+		},
+		{
+			scenario: "Unknown options of built-in handler types are rejected",
+			yaml:     "handlers:\n  - type: slack\n    hookTypes: [\"*\"]\n    options:\n      webhookURL: https://hooks.slack.com/x\n",
+			err:      "line 5: unknown field handlers[0].options.webhookURL",
+		},
+		{
+			scenario: "Options of handler types that take none are rejected",
+			yaml:     "handlers:\n  - type: datadog-metric\n    hookTypes: [\"*\"]\n    options:\n      tags: [team:platform]\n",
+			err:      "line 5: unknown field handlers[0].options.tags",
+		},
+		{
+			scenario: "Missing environment variables are rejected with their line",
+			yaml:     "datadog:\n  apiKey: ${NOPE}\n",
+			err:      "line 2: environment variable NOPE is not set",
+		},
+		{
+			scenario: "Inline templates can't be mixed with a templates file",
+			yaml:     "templatesFile: templates.yml\ntemplates:\n  orca:pipeline:failed:\n    title: failed\n",
+			err:      "templates and templatesFile can't both be set",
+		},
+		{
+			scenario: "Inline routes can't be mixed with a routes file",
+			yaml:     "datadog:\n  routesFile: routes.yml\n  default: platform\n",
+			err:      "datadog.routesFile can't be set",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			_, err := config.Parse([]byte(test.yaml), env(map[string]string{"MAX_FILES": "many"}))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.err)
+		})
+	}
+}
+
+// flagSet records the flags set by a config
+type flagSet struct {
+	set    map[string]bool
+	values map[string][]string
+}
+
+func (f *flagSet) IsSet(name string) bool {
+	return f.set[name]
+}
+
+func (f *flagSet) Set(name, value string) error {
+	f.values[name] = append(f.values[name], value)
+	return nil
+}
+
+func TestApplyingConfig(t *testing.T) {
+	cfg, err := config.Parse(readTestdata(t, "bridge.yml"), env(map[string]string{
+		"DATADOG_API_KEY": "abc123",
+		"ENVIRONMENT":     "prod",
+		"HOOK_PATH":       "deploys",
+	}))
+	require.NoError(t, err)
+
+	flags := &flagSet{set: map[string]bool{"datadog-site": true}, values: make(map[string][]string)}
+	require.NoError(t, cfg.Apply(flags))
+
+	assert.Equal(t, []string{":3000"}, flags.values["addr"])
+	assert.Equal(t, []string{"true"}, flags.values["webhook-results"])
+	assert.Equal(t, []string{"5s"}, flags.values["datadog-timeout"])
+	assert.Equal(t, []string{"2.5"}, flags.values["rate-limit"])
+	assert.Equal(t, []string{"env:prod", "team:delivery"}, flags.values["datadog-metrics-tag"])
+	assert.NotContains(t, flags.values, "datadog-site", "flags set on the command line win")
+	assert.NotContains(t, flags.values, "max-body-bytes", "flags missing from the file keep their default")
+}
+
+func TestEmptyConfig(t *testing.T) {
+	cfg, err := config.Parse(nil, env(nil))
+	require.NoError(t, err)
+
+	b, err := cfg.TemplatesYAML()
+	require.NoError(t, err)
+	assert.Nil(t, b)
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+)
+
+// DefaultRecentSize is how many webhooks are kept by default
+const DefaultRecentSize = 100
+
+// RecentWebhook is everything the server knows about a webhook it received
+type RecentWebhook struct {
+	Received    time.Time       `json:"received"`
+	Status      int             `json:"status"`
+	Error       string          `json:"error,omitempty"`
+	Application string          `json:"application,omitempty"`
+	HookType    string          `json:"hookType,omitempty"`
+	Handlers    []string        `json:"handlers"`
+	Results     []HandlerResult `json:"results"`
+	// Outputs are the previews of every handler that supports them, keyed by
+	// handler name
+	Outputs map[string]interface{} `json:"outputs,omitempty"`
+	Body    json.RawMessage        `json:"body,omitempty"`
+}
+
+// Recent is a ring buffer of the last webhooks the server received
+type Recent struct {
+	mu       sync.RWMutex
+	webhooks []*RecentWebhook
+	next     int
+	full     bool
+}
+
+// NewRecent initializes a ring buffer that keeps the given number of webhooks
+func NewRecent(size int) *Recent {
+	if size <= 0 {
+		size = DefaultRecentSize
+	}
+
+	return &Recent{webhooks: make([]*RecentWebhook, size)}
+}
+
+// Add records a webhook, replacing the oldest one when the buffer is full
+func (r *Recent) Add(webhook *RecentWebhook) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.webhooks[r.next] = webhook
+	r.next = (r.next + 1) % len(r.webhooks)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// List returns the recorded webhooks, newest first, that match the given
+// application and hook type patterns (empty patterns match everything)
+func (r *Recent) List(application, hookType string) []*RecentWebhook {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	count := r.next
+	if r.full {
+		count = len(r.webhooks)
+	}
+
+	webhooks := make([]*RecentWebhook, 0, count)
+	for i := 1; i <= count; i++ {
+		webhook := r.webhooks[(r.next-i+len(r.webhooks))%len(r.webhooks)]
+		if matches(application, webhook.Application) && matches(hookType, webhook.HookType) {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+
+	return webhooks
+}
+
+func matches(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+
+	ok, _ := path.Match(pattern, value)
+	return ok
+}
+
+// ServeHTTP lists the recorded webhooks, filtered by the application and
+// type query parameters and limited by limit
+func (r *Recent) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	webhooks := r.List(query.Get("application"), query.Get("type"))
+
+	if limit, err := strconv.Atoi(query.Get("limit")); err == nil && limit >= 0 && limit < len(webhooks) {
+		webhooks = webhooks[:limit]
+	}
+
+	WriteJSON(w, http.StatusOK, webhooks)
+}
+
+// previews renders the output of every handler that supports previews
+func previews(handlers []spinnaker.Handler, incoming *types.IncomingWebhook) map[string]interface{} {
+	outputs := make(map[string]interface{})
+	for _, handler := range handlers {
+		previewer, ok := handler.(spinnaker.Previewer)
+		if !ok {
+			continue
+		}
+
+		// Handlers of the same kind (two Slack destinations for example) share
+		// a name so they're numbered
+		name := handler.Name()
+		for i := 2; outputs[name] != nil; i++ {
+			name = handler.Name() + "#" + strconv.Itoa(i)
+		}
+
+		output, err := previewer.Preview(incoming)
+		if err != nil {
+			outputs[name] = map[string]string{"error": err.Error()}
+		} else if output != nil {
+			outputs[name] = output
+		}
+	}
+
+	return outputs
+}
@@ -1,18 +1,55 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 
+	"github.com/bobbytables/spinnaker-datadog-bridge/archive"
 	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
 )
 
+// DefaultMaxBodyBytes is the largest webhook body accepted by default
+const DefaultMaxBodyBytes = 1 << 20
+
+// DefaultHandlerTimeout is how long handlers are waited for by default
+const DefaultHandlerTimeout = time.Second * 10
+
 // Server handles incoming webhook requests and pushes them to a dispatcher
 type Server struct {
-	Addr       string
+	Addr string
+	// MaxBodyBytes is the largest webhook body accepted, larger bodies are
+	// rejected with 413
+	MaxBodyBytes int64
+	// HandlerTimeout is how long the handlers of a webhook are waited for
+	// before responding with 503
+	HandlerTimeout time.Duration
+	// ReturnResults responds to webhooks with the result of every handler as
+	// JSON, which is useful for debugging templates
+	ReturnResults bool
+	// Recent records every webhook when it is set
+	Recent *Recent
+	// Stream broadcasts every webhook when it is set
+	Stream *Stream
+	// Admin is started and shut down along with the server when it is set
+	Admin *Admin
+	// Archive records the raw body of every webhook when it is set
+	Archive *archive.Writer
+	// TLS serves webhooks over TLS when it is set
+	TLS *TLSOptions
+
 	mux        *mux.Router
 	dispatcher *spinnaker.Dispatcher
 }
@@ -21,17 +58,78 @@ type Server struct {
 // and dispatch events from incoming webhooks
 func New(address string, d *spinnaker.Dispatcher) *Server {
 	return &Server{
-		Addr:       address,
-		dispatcher: d,
+		Addr:           address,
+		MaxBodyBytes:   DefaultMaxBodyBytes,
+		HandlerTimeout: DefaultHandlerTimeout,
+		dispatcher:     d,
 	}
 }
 
-// Start starts a server to accept Spinnaker webhook events
+// shutdownTimeout is how long in flight webhooks are given to finish when the
+// server is shutting down
+const shutdownTimeout = time.Second * 15
+
+// Start starts a server to accept Spinnaker webhook events. It returns nil
+// once the server has shut down gracefully after SIGINT or SIGTERM.
 func (s *Server) Start() error {
 	s.prepare()
-	logrus.WithField("addr", s.Addr).Info("starting server")
+	logrus.WithFields(logrus.Fields{"addr": s.Addr, "tls": s.TLS != nil}).Info("starting server")
+
+	srv := &http.Server{Addr: s.Addr, Handler: s}
+	listen := srv.ListenAndServe
+
+	if s.TLS != nil {
+		reloader, err := NewCertReloader(*s.TLS)
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = reloader.TLSConfig()
+		listen = func() error { return srv.ListenAndServeTLS("", "") }
+
+		stop := make(chan struct{})
+		defer close(stop)
+		go reloader.Watch(stop)
+	}
 
-	return http.ListenAndServe(s.Addr, s.mux)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	if s.Admin != nil {
+		s.Admin.Start()
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- listen()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-signals:
+		logrus.WithField("signal", sig.String()).Info("shutting down server")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if s.Stream != nil {
+		s.Stream.Close()
+	}
+	if s.Admin != nil {
+		if err := s.Admin.Shutdown(ctx); err != nil {
+			logrus.WithError(err).Warn("could not shut down admin server")
+		}
+	}
+
+	return srv.Shutdown(ctx)
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.prepare()
+	s.mux.ServeHTTP(w, req)
 }
 
 func (s *Server) prepare() {
@@ -40,31 +138,123 @@ func (s *Server) prepare() {
 	}
 
 	router := mux.NewRouter()
-	router.HandleFunc("/webhook", s.handleWebhook)
-	router.HandleFunc("/webhook/", s.handleWebhook)
+	router.HandleFunc("/webhook", s.handleWebhook).Methods(http.MethodPost)
+	router.HandleFunc("/webhook/", s.handleWebhook).Methods(http.MethodPost)
 	router.Use(s.loggingMiddleware)
+	if s.TLS != nil && len(s.TLS.AllowedClients) > 0 {
+		router.Use(s.clientMiddleware)
+	}
 
 	s.mux = router
 }
 
+// webhookResponse is the JSON body of webhook responses
+type webhookResponse struct {
+	Error    string          `json:"error,omitempty"`
+	HookType string          `json:"hookType,omitempty"`
+	Results  []HandlerResult `json:"results,omitempty"`
+}
+
+// HandlerResult is the JSON representation of a spinnaker.DispatchResult
+type HandlerResult struct {
+	Handler  string `json:"handler"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
 func (s *Server) handleWebhook(w http.ResponseWriter, req *http.Request) {
-	results, err := s.dispatcher.HandleIncomingRequest(req)
+	recent := &RecentWebhook{Received: time.Now()}
+	defer func() {
+		if s.Recent != nil {
+			s.Recent.Add(recent)
+		}
+		if s.Stream != nil {
+			s.Stream.Publish(recent)
+		}
+	}()
+
+	respond := func(status int, response webhookResponse) {
+		recent.Status, recent.Error, recent.Results = status, response.Error, response.Results
+		s.respond(w, status, response)
+	}
+
+	if !isJSON(req.Header.Get("Content-Type")) {
+		respond(http.StatusUnsupportedMediaType, webhookResponse{Error: "content type must be application/json"})
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, s.MaxBodyBytes+1))
 	if err != nil {
-		logrus.WithError(err).Error("could not handle incoming request")
-		w.WriteHeader(http.StatusInternalServerError)
+		respond(http.StatusBadRequest, webhookResponse{Error: "could not read request body"})
+		return
+	}
+	if int64(len(body)) > s.MaxBodyBytes {
+		respond(http.StatusRequestEntityTooLarge, webhookResponse{Error: "request body is too large"})
 		return
 	}
 
-	deadline := time.After(time.Second * 10)
+	if s.Archive != nil {
+		if err := s.Archive.Write(archive.NewRecord(recent.Received, req.Header, body)); err != nil {
+			logrus.WithError(err).Error("could not archive webhook")
+		}
+	}
+
+	incoming, err := spinnaker.DecodeWebhook(bytes.NewReader(body))
+	if err != nil {
+		// The body isn't JSON so it's kept as a string
+		recent.Body, _ = json.Marshal(string(body))
+		logrus.WithError(err).Warn("could not decode incoming webhook")
+		respond(http.StatusBadRequest, webhookResponse{Error: err.Error()})
+		return
+	}
+
+	recent.Body = json.RawMessage(body)
+	recent.Application = incoming.Details.Application
+	recent.HookType = incoming.Details.Type
+
+	response := webhookResponse{HookType: incoming.Details.Type}
+	handlers := s.dispatcher.HandlersFor(incoming.Details.Type)
+	if len(handlers) == 0 {
+		// Spinnaker sends every hook type, the ones nothing is configured for
+		// aren't errors
+		respond(http.StatusNoContent, response)
+		return
+	}
+
+	for _, handler := range handlers {
+		recent.Handlers = append(recent.Handlers, handler.Name())
+	}
+	if s.Recent != nil || s.Stream != nil {
+		recent.Outputs = previews(handlers, incoming)
+	}
+
+	failed := 0
+	results := s.dispatcher.Dispatch(incoming)
+	timeout := s.HandlerTimeout
+	if timeout <= 0 {
+		timeout = DefaultHandlerTimeout
+	}
+	deadline := time.After(timeout)
 	for {
 		select {
 		case res, more := <-results:
 			if !more {
-				w.WriteHeader(http.StatusAccepted)
+				// Partial failures are still accepted, retrying the webhook
+				// would duplicate what the other handlers sent
+				if failed == len(handlers) {
+					response.Error = "every handler failed"
+					respond(http.StatusBadGateway, response)
+					return
+				}
+
+				respond(http.StatusAccepted, response)
 				return
 			}
 
+			result := HandlerResult{Handler: res.HandlerName, Duration: res.Duration.String()}
 			if res.Err != nil {
+				failed++
+				result.Error = res.Err.Error()
 				logrus.WithError(res.Err).WithField("handler", res.HandlerName).Error("handler error")
 			} else {
 				logrus.WithFields(logrus.Fields{
@@ -72,14 +262,43 @@ func (s *Server) handleWebhook(w http.ResponseWriter, req *http.Request) {
 					"duration": res.Duration.String(),
 				}).Debug("handler succeeded")
 			}
+			response.Results = append(response.Results, result)
 		case <-deadline:
 			logrus.Error("timed out while waiting for dispatcher results")
-			w.WriteHeader(http.StatusServiceUnavailable)
+			response.Error = "timed out while waiting for handlers"
+			respond(http.StatusServiceUnavailable, response)
 			return
 		}
 	}
 }
 
+// respond writes the status and, when errors or results should be returned,
+// the JSON body
+func (s *Server) respond(w http.ResponseWriter, status int, response webhookResponse) {
+	if !s.ReturnResults {
+		response.Results = nil
+	}
+
+	if response.Error == "" && response.Results == nil {
+		w.WriteHeader(status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// isJSON returns true for application/json and +json content types
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
 func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
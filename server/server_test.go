@@ -0,0 +1,178 @@
+package server_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/server"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+)
+
+type stubHandler struct{ err error }
+
+func (h *stubHandler) Name() string                        { return "StubHandler" }
+func (h *stubHandler) Handle(*types.IncomingWebhook) error { return h.err }
+
+func TestWebhookHTTPSemantics(t *testing.T) {
+	tests := []struct {
+		scenario    string
+		method      string
+		contentType string
+		body        string
+		status      int
+		wantError   string
+	}{
+		{
+			scenario:    "Handled hook types are accepted",
+			body:        `{"details": {"type": "orca:pipeline:complete"}}`,
+			contentType: "application/json; charset=utf-8",
+			status:      http.StatusAccepted,
+		},
+		{
+			scenario:    "Unhandled hook types have no content",
+			body:        `{"details": {"type": "orca:task:complete"}}`,
+			contentType: "application/json",
+			status:      http.StatusNoContent,
+		},
+		{
+			scenario:    "Malformed bodies are bad requests",
+			body:        `{"details": `,
+			contentType: "application/json",
+			status:      http.StatusBadRequest,
+			wantError:   "could not decode incoming webhook",
+		},
+		{
+			scenario:    "Other content types are unsupported",
+			body:        `{}`,
+			contentType: "text/plain",
+			status:      http.StatusUnsupportedMediaType,
+			wantError:   "content type must be application/json",
+		},
+		{
+			scenario:    "Bodies over the limit are too large",
+			body:        `{"details": {"type": "orca:pipeline:complete"}, "padding": "` + strings.Repeat("a", 100) + `"}`,
+			contentType: "application/json",
+			status:      http.StatusRequestEntityTooLarge,
+			wantError:   "request body is too large",
+		},
+		{
+			scenario: "Only POST is allowed",
+			method:   http.MethodGet,
+			status:   http.StatusMethodNotAllowed,
+		},
+	}
+
+	d := spinnaker.NewDispatcher()
+	d.AddHandler("orca:pipeline:complete", &stubHandler{})
+	srv := server.New(":0", d)
+	srv.MaxBodyBytes = 100
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			method := test.method
+			if method == "" {
+				method = http.MethodPost
+			}
+
+			req := httptest.NewRequest(method, "/webhook", strings.NewReader(test.body))
+			req.Header.Set("Content-Type", test.contentType)
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, req)
+
+			assert.Equal(t, test.status, w.Code)
+			if test.wantError != "" {
+				var response map[string]interface{}
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+				assert.Contains(t, response["error"], test.wantError)
+			}
+		})
+	}
+
+	t.Run("Unknown routes are not found", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(`{"details": {"type": "orca:pipeline:complete"}}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestWebhookReturnsResults(t *testing.T) {
+	d := spinnaker.NewDispatcher()
+	d.AddHandler("orca:pipeline:complete", &stubHandler{err: errors.New("well that sucks")})
+	d.AddHandler("orca:pipeline:complete", &stubHandler{})
+	srv := server.New(":0", d)
+	srv.ReturnResults = true
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook/", strings.NewReader(`{"details": {"type": "orca:pipeline:complete"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var response struct {
+		HookType string `json:"hookType"`
+		Results  []struct {
+			Handler string `json:"handler"`
+			Error   string `json:"error"`
+		} `json:"results"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, "orca:pipeline:complete", response.HookType)
+	require.Len(t, response.Results, 2)
+	errs := []string{response.Results[0].Error, response.Results[1].Error}
+	assert.ElementsMatch(t, []string{"well that sucks", ""}, errs)
+	assert.Equal(t, "StubHandler", response.Results[0].Handler)
+
+	t.Run("Webhooks every handler failed are a bad gateway", func(t *testing.T) {
+		d := spinnaker.NewDispatcher()
+		d.AddHandler("orca:pipeline:complete", &stubHandler{err: errors.New("well that sucks")})
+		srv := server.New(":0", d)
+
+		req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(`{"details": {"type": "orca:pipeline:complete"}}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadGateway, w.Code)
+		assert.Contains(t, w.Body.String(), "every handler failed")
+	})
+}
+
+type blockingHandler struct{ release chan struct{} }
+
+func (h *blockingHandler) Name() string { return "BlockingHandler" }
+func (h *blockingHandler) Handle(*types.IncomingWebhook) error {
+	<-h.release
+	return nil
+}
+
+func TestWebhookHandlerTimeout(t *testing.T) {
+	handler := &blockingHandler{release: make(chan struct{})}
+	defer close(handler.release)
+
+	d := spinnaker.NewDispatcher()
+	d.AddHandler("orca:pipeline:complete", handler)
+	srv := server.New(":0", d)
+	srv.HandlerTimeout = time.Millisecond * 10
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(`{"details": {"type": "orca:pipeline:complete"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "timed out while waiting for handlers")
+}
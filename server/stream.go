@@ -0,0 +1,178 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// streamEvent is the SSE event name of dispatched webhooks
+	streamEvent = "webhook"
+	// streamBuffer is how many webhooks are buffered for each subscriber, slow
+	// subscribers miss webhooks rather than holding up the server
+	streamBuffer = 64
+	// streamHeartbeat is how often a comment is sent to keep idle connections
+	// (and the proxies in front of them) open
+	streamHeartbeat = time.Second * 15
+)
+
+// Stream broadcasts every webhook the server handles to its subscribers
+type Stream struct {
+	mu          sync.Mutex
+	subscribers map[chan *RecentWebhook]struct{}
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+// NewStream initializes a stream without subscribers
+func NewStream() *Stream {
+	return &Stream{
+		subscribers: make(map[chan *RecentWebhook]struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// Close ends the response of every subscriber, and of the ones connecting
+// afterwards, so the server they're served by can shut down
+func (s *Stream) Close() {
+	s.closeOnce.Do(func() { close(s.done) })
+}
+
+// Publish sends the webhook to every subscriber that has room for it
+func (s *Stream) Publish(webhook *RecentWebhook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ch := range s.subscribers {
+		select {
+		case ch <- webhook:
+		default:
+		}
+	}
+}
+
+func (s *Stream) subscribe() chan *RecentWebhook {
+	ch := make(chan *RecentWebhook, streamBuffer)
+
+	s.mu.Lock()
+	s.subscribers[ch] = struct{}{}
+	s.mu.Unlock()
+
+	return ch
+}
+
+func (s *Stream) unsubscribe(ch chan *RecentWebhook) {
+	s.mu.Lock()
+	delete(s.subscribers, ch)
+	s.mu.Unlock()
+}
+
+// ServeHTTP streams webhooks as Server-Sent Events until the client
+// disconnects. The application and type query parameters filter the stream
+// and raw bodies are only included with body=true.
+func (s *Stream) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "streaming is not supported"})
+		return
+	}
+
+	query := req.URL.Query()
+	application, hookType := query.Get("application"), query.Get("type")
+	withBody := query.Get("body") == "true"
+
+	ch := s.subscribe()
+	defer s.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case webhook := <-ch:
+			if !matches(application, webhook.Application) || !matches(hookType, webhook.HookType) {
+				continue
+			}
+
+			if !withBody {
+				withoutBody := *webhook
+				withoutBody.Body = nil
+				webhook = &withoutBody
+			}
+
+			data, err := json.Marshal(webhook)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", streamEvent, data)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// ReadStream reads webhooks from a Server-Sent Events stream served by Stream
+// and calls f with each one until the stream ends or f returns an error
+func ReadStream(r io.Reader, f func(*RecentWebhook) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), DefaultMaxBodyBytes*2)
+
+	var event string
+	var data bytes.Buffer
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case line == "":
+			if event == streamEvent && data.Len() > 0 {
+				webhook := new(RecentWebhook)
+				if err := json.Unmarshal(data.Bytes(), webhook); err != nil {
+					return errors.Wrap(err, "could not decode streamed webhook")
+				}
+				if err := f(webhook); err != nil {
+					return err
+				}
+			}
+			event = ""
+			data.Reset()
+		case len(line) > 6 && line[:6] == "event:":
+			event = trimField(line[6:])
+		case len(line) > 5 && line[:5] == "data:":
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(trimField(line[5:]))
+		}
+	}
+
+	return scanner.Err()
+}
+
+// trimField removes the optional space after the colon of an SSE field
+func trimField(value string) string {
+	if len(value) > 0 && value[0] == ' ' {
+		return value[1:]
+	}
+
+	return value
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultTLSReloadInterval is how often certificate files are checked for
+// changes by default
+const DefaultTLSReloadInterval = time.Second * 30
+
+// TLSOptions configures serving webhooks over TLS
+type TLSOptions struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile turns on mutual TLS, clients must present a certificate
+	// signed by one of the authorities in the file
+	ClientCAFile string
+	// AllowedClients restricts clients to certificates with a common name or
+	// DNS/URI SAN matching one of the names, glob patterns are supported.
+	// Other clients are rejected with 403.
+	AllowedClients []string
+	// ReloadInterval is how often the files are checked for changes, they are
+	// reloaded without restarting the server
+	ReloadInterval time.Duration
+}
+
+// Validate checks the options can be used to serve TLS
+func (o *TLSOptions) Validate() error {
+	if o.CertFile == "" || o.KeyFile == "" {
+		return errors.New("a certificate and a key are required to serve TLS")
+	}
+	if len(o.AllowedClients) > 0 && o.ClientCAFile == "" {
+		return errors.New("allowed clients require a client CA")
+	}
+
+	for _, pattern := range o.AllowedClients {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Wrapf(err, "invalid allowed client %q", pattern)
+		}
+	}
+
+	return nil
+}
+
+// AllowsClient returns true when one of the names of the certificate matches
+// the allowed clients, or when any client is allowed
+func (o *TLSOptions) AllowsClient(cert *x509.Certificate) bool {
+	if len(o.AllowedClients) == 0 {
+		return true
+	}
+	if cert == nil {
+		return false
+	}
+
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+
+	for _, pattern := range o.AllowedClients {
+		for _, name := range names {
+			if ok, _ := path.Match(pattern, name); ok && name != "" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// CertReloader serves the certificate and client authorities of TLSOptions
+// and reloads them when their files change
+type CertReloader struct {
+	opts TLSOptions
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+}
+
+// NewCertReloader loads the files of the options
+func NewCertReloader(opts TLSOptions) (*CertReloader, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	cr := &CertReloader{opts: opts}
+	if _, err := cr.Reload(); err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
+// Reload loads the files again when one of them changed since they were last
+// loaded. The current certificate is kept when loading fails.
+func (cr *CertReloader) Reload() (bool, error) {
+	modTimes := make(map[string]time.Time)
+	for _, file := range []string{cr.opts.CertFile, cr.opts.KeyFile, cr.opts.ClientCAFile} {
+		if file == "" {
+			continue
+		}
+
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, errors.Wrap(err, "could not stat certificate file")
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	cr.mu.RLock()
+	changed := cr.modTimes == nil
+	for file, modTime := range modTimes {
+		if !cr.modTimes[file].Equal(modTime) {
+			changed = true
+		}
+	}
+	cr.mu.RUnlock()
+	if !changed {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.opts.CertFile, cr.opts.KeyFile)
+	if err != nil {
+		return false, errors.Wrap(err, "could not load certificate")
+	}
+
+	var clientCAs *x509.CertPool
+	if cr.opts.ClientCAFile != "" {
+		b, err := ioutil.ReadFile(cr.opts.ClientCAFile)
+		if err != nil {
+			return false, errors.Wrap(err, "could not read client CA file")
+		}
+
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(b) {
+			return false, errors.New("no certificates found in client CA file")
+		}
+	}
+
+	cr.mu.Lock()
+	cr.cert, cr.clientCAs, cr.modTimes = &cert, clientCAs, modTimes
+	cr.mu.Unlock()
+
+	return true, nil
+}
+
+// Watch reloads the files every ReloadInterval until stop is closed
+func (cr *CertReloader) Watch(stop <-chan struct{}) {
+	interval := cr.opts.ReloadInterval
+	if interval <= 0 {
+		interval = DefaultTLSReloadInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			reloaded, err := cr.Reload()
+			if err != nil {
+				logrus.WithError(err).Error("could not reload TLS certificates, keeping the current ones")
+			} else if reloaded {
+				logrus.Info("reloaded TLS certificates")
+			}
+		}
+	}
+}
+
+// TLSConfig returns a TLS configuration that always uses the latest loaded
+// certificate and client authorities
+func (cr *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cr.mu.RLock()
+			defer cr.mu.RUnlock()
+
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cr.cert},
+			}
+			if cr.clientCAs != nil {
+				config.ClientCAs = cr.clientCAs
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+
+			return config, nil
+		},
+	}
+}
+
+// clientMiddleware rejects clients whose certificate isn't allowed
+func (s *Server) clientMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var cert *x509.Certificate
+		if req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
+			cert = req.TLS.PeerCertificates[0]
+		}
+
+		if !s.TLS.AllowsClient(cert) {
+			fields := logrus.Fields{"remote": req.RemoteAddr}
+			if cert != nil {
+				fields["commonName"] = cert.Subject.CommonName
+			}
+			logrus.WithFields(fields).Warn("rejected client certificate")
+
+			s.respond(w, http.StatusForbidden, webhookResponse{Error: "client certificate is not allowed"})
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
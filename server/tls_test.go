@@ -0,0 +1,243 @@
+package server_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/server"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+)
+
+// testCA issues certificates for TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the PEM certificate and key for the common name
+func (ca *testCA) issue(t *testing.T, commonName string, usage x509.ExtKeyUsage, dnsNames ...string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func (ca *testCA) client(t *testing.T, serverCA *testCA, commonName string, dnsNames ...string) *http.Client {
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCA.cert)
+
+	config := &tls.Config{RootCAs: roots}
+	if ca != nil {
+		certPEM, keyPEM := ca.issue(t, commonName, x509.ExtKeyUsageClientAuth, dnsNames...)
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		require.NoError(t, err)
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+}
+
+func writeFile(t *testing.T, dir, name string, b []byte) string {
+	file := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(file, b, 0600))
+	return file
+}
+
+func startTLSServer(t *testing.T, opts server.TLSOptions) (*httptest.Server, *server.CertReloader) {
+	d := spinnaker.NewDispatcher()
+	d.AddHandler("orca:pipeline:complete", &stubHandler{})
+	srv := server.New("", d)
+	srv.TLS = &opts
+
+	reloader, err := server.NewCertReloader(opts)
+	require.NoError(t, err)
+
+	ts := httptest.NewUnstartedServer(srv)
+	ts.TLS = reloader.TLSConfig()
+	ts.StartTLS()
+
+	return ts, reloader
+}
+
+func postWebhook(client *http.Client, url string) (*http.Response, error) {
+	return client.Post(url+"/webhook", "application/json", strings.NewReader(`{"details": {"type": "orca:pipeline:complete"}}`))
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	serverCA, clientCA, otherCA := newTestCA(t, "server-ca"), newTestCA(t, "client-ca"), newTestCA(t, "other-ca")
+	certPEM, keyPEM := serverCA.issue(t, "bridge", x509.ExtKeyUsageServerAuth)
+
+	ts, _ := startTLSServer(t, server.TLSOptions{
+		CertFile:       writeFile(t, dir, "tls.crt", certPEM),
+		KeyFile:        writeFile(t, dir, "tls.key", keyPEM),
+		ClientCAFile:   writeFile(t, dir, "ca.crt", clientCA.pem),
+		AllowedClients: []string{"echo.spinnaker", "*.echo.example.com"},
+	})
+	defer ts.Close()
+
+	tests := []struct {
+		scenario  string
+		client    *http.Client
+		status    int
+		handshake bool
+	}{
+		{
+			scenario: "Allowed common names are accepted",
+			client:   clientCA.client(t, serverCA, "echo.spinnaker"),
+			status:   http.StatusAccepted,
+		},
+		{
+			scenario: "Allowed DNS SANs are accepted",
+			client:   clientCA.client(t, serverCA, "echo", "us-east.echo.example.com"),
+			status:   http.StatusAccepted,
+		},
+		{
+			scenario: "Other clients of the CA are forbidden",
+			client:   clientCA.client(t, serverCA, "deck.spinnaker"),
+			status:   http.StatusForbidden,
+		},
+		{
+			scenario:  "Clients signed by another CA fail the handshake",
+			client:    otherCA.client(t, serverCA, "echo.spinnaker"),
+			handshake: true,
+		},
+		{
+			scenario:  "Clients without a certificate fail the handshake",
+			client:    (*testCA)(nil).client(t, serverCA, ""),
+			handshake: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			resp, err := postWebhook(test.client, ts.URL)
+			if test.handshake {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, test.status, resp.StatusCode)
+		})
+	}
+}
+
+func TestCertificatesReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	oldCA, newCA := newTestCA(t, "old-ca"), newTestCA(t, "new-ca")
+	certPEM, keyPEM := oldCA.issue(t, "bridge", x509.ExtKeyUsageServerAuth)
+	opts := server.TLSOptions{
+		CertFile: writeFile(t, dir, "tls.crt", certPEM),
+		KeyFile:  writeFile(t, dir, "tls.key", keyPEM),
+	}
+
+	ts, reloader := startTLSServer(t, opts)
+	defer ts.Close()
+
+	resp, err := postWebhook((*testCA)(nil).client(t, oldCA, ""), ts.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	reloaded, err := reloader.Reload()
+	require.NoError(t, err)
+	assert.False(t, reloaded, "unchanged files aren't reloaded")
+
+	certPEM, keyPEM = newCA.issue(t, "bridge", x509.ExtKeyUsageServerAuth)
+	writeFile(t, dir, "tls.crt", certPEM)
+	writeFile(t, dir, "tls.key", keyPEM)
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(opts.CertFile, future, future))
+
+	reloaded, err = reloader.Reload()
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+
+	resp, err = postWebhook((*testCA)(nil).client(t, newCA, ""), ts.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	t.Run("Broken files keep the current certificate", func(t *testing.T) {
+		writeFile(t, dir, "tls.key", []byte("nope"))
+		future := time.Now().Add(time.Hour)
+		require.NoError(t, os.Chtimes(opts.KeyFile, future, future))
+
+		_, err := reloader.Reload()
+		require.Error(t, err)
+
+		resp, err := postWebhook((*testCA)(nil).client(t, newCA, ""), ts.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+	})
+}
+
+func TestTLSOptionsValidation(t *testing.T) {
+	assert.Error(t, (&server.TLSOptions{CertFile: "tls.crt"}).Validate())
+	assert.Error(t, (&server.TLSOptions{CertFile: "tls.crt", KeyFile: "tls.key", AllowedClients: []string{"echo"}}).Validate())
+	assert.Error(t, (&server.TLSOptions{CertFile: "tls.crt", KeyFile: "tls.key", ClientCAFile: "ca.crt", AllowedClients: []string{"[echo"}}).Validate())
+	assert.NoError(t, (&server.TLSOptions{CertFile: "tls.crt", KeyFile: "tls.key", ClientCAFile: "ca.crt", AllowedClients: []string{"echo"}}).Validate())
+}
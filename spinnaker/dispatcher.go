@@ -2,11 +2,11 @@ package spinnaker
 
 import (
 	"encoding/json"
+	"io"
 	"net/http"
 	"sync"
 	"time"
 
-	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
 	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
@@ -20,6 +20,17 @@ type Handler interface {
 	Name() string
 }
 
+// Previewer is implemented by handlers that can render what they would send
+// for a webhook without sending it. Previews are kept with recent webhooks to
+// debug templates.
+type Previewer interface {
+	Preview(incoming *types.IncomingWebhook) (interface{}, error)
+}
+
+// AllHookTypes can be used as the hook type when adding a handler to have it
+// receive every incoming webhook regardless of its detail type
+const AllHookTypes = "*"
+
 // HandlerMap contains all of the handlers and the type of detail they are used for
 type HandlerMap map[string][]Handler
 
@@ -61,18 +72,77 @@ func (d *Dispatcher) AddHandler(hookType string, h Handler) {
 	d.handlers[hookType] = append(d.handlers[hookType], h)
 }
 
+// AddHandlers registers handlers keyed by the hook types they handle, as
+// returned by the Handlers method of the handler packages
+func (d *Dispatcher) AddHandlers(hs map[string][]Handler) {
+	for hookType, handlers := range hs {
+		for _, handler := range handlers {
+			d.AddHandler(hookType, handler)
+		}
+	}
+}
+
+// HandlersFor returns the handlers registered for the given hook type along
+// with any handlers registered for AllHookTypes
+func (d *Dispatcher) HandlersFor(hookType string) []Handler {
+	handlers := make([]Handler, 0, len(d.handlers[hookType])+len(d.handlers[AllHookTypes]))
+	handlers = append(handlers, d.handlers[hookType]...)
+	if hookType != AllHookTypes {
+		handlers = append(handlers, d.handlers[AllHookTypes]...)
+	}
+
+	return handlers
+}
+
+// Handles returns true when at least one handler is registered for the given
+// hook type (including handlers for AllHookTypes)
+func (d *Dispatcher) Handles(hookType string) bool {
+	return len(d.HandlersFor(hookType)) > 0
+}
+
+// DecodeError is returned when an incoming webhook isn't valid JSON
+type DecodeError struct {
+	Err error
+}
+
+func (de *DecodeError) Error() string {
+	return "could not decode incoming webhook: " + de.Err.Error()
+}
+
+// Cause returns the underlying decoding error
+func (de *DecodeError) Cause() error {
+	return de.Err
+}
+
+// DecodeWebhook decodes a webhook from the given reader. Errors are always a
+// *DecodeError.
+func DecodeWebhook(r io.Reader) (*types.IncomingWebhook, error) {
+	incoming := new(types.IncomingWebhook)
+	if err := json.NewDecoder(r).Decode(incoming); err != nil {
+		return nil, &DecodeError{Err: err}
+	}
+
+	return incoming, nil
+}
+
 // HandleIncomingRequest reads a given http request object and dispatches the
 // appropriate handlers for it (if any exists). If it fails to decode the
-// incoming request body it will return an error. Otherwise, a channel is returned
-// that results are sent to as the given handlers complete or fail.
+// incoming request body it will return a *DecodeError. Otherwise, a channel is
+// returned that results are sent to as the given handlers complete or fail.
 func (d *Dispatcher) HandleIncomingRequest(req *http.Request) (<-chan DispatchResult, error) {
-	incoming := new(types.IncomingWebhook)
-
-	if err := json.NewDecoder(req.Body).Decode(incoming); err != nil {
-		return nil, errors.Wrap(err, "could not decode incoming webhook")
+	incoming, err := DecodeWebhook(req.Body)
+	if err != nil {
+		return nil, err
 	}
 
-	handlers := d.Handlers()[incoming.Details.Type]
+	return d.Dispatch(incoming), nil
+}
+
+// Dispatch sends the webhook to every handler registered for its hook type.
+// Results are sent to the returned channel as the handlers complete or fail,
+// it is closed once all of them are done.
+func (d *Dispatcher) Dispatch(incoming *types.IncomingWebhook) <-chan DispatchResult {
+	handlers := d.HandlersFor(incoming.Details.Type)
 	logrus.WithFields(logrus.Fields{
 		"hook_type": incoming.Details.Type,
 		"handlers":  len(handlers),
@@ -81,7 +151,9 @@ func (d *Dispatcher) HandleIncomingRequest(req *http.Request) (<-chan DispatchRe
 	var wg sync.WaitGroup
 	wg.Add(len(handlers))
 
-	results := make(chan DispatchResult)
+	// Buffered so handlers that finish after the receiver stopped listening
+	// don't leak their goroutine
+	results := make(chan DispatchResult, len(handlers))
 	for _, handler := range handlers {
 		go func(handler Handler) {
 			start := time.Now()
@@ -105,5 +177,5 @@ func (d *Dispatcher) HandleIncomingRequest(req *http.Request) (<-chan DispatchRe
 		close(results)
 	}()
 
-	return results, nil
+	return results
 }
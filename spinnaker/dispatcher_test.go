@@ -53,6 +53,31 @@ func TestDispatcherHandlesRequests(t *testing.T) {
 				}
 			},
 		},
+		{
+			scenario:        "A handler registered for all hook types receives the hook",
+			requestBodyFile: "valid-webhook.json",
+			hookType:        spinnaker.AllHookTypes,
+			mockFactory: func(ctrl *gomock.Controller, t *testing.T) *mocks.MockHandler {
+				m := mocks.NewMockHandler(ctrl)
+				m.EXPECT().Handle(gomock.Any()).Do(func(incoming *types.IncomingWebhook) {
+					assert.Equal(t, "orca:stage:complete", incoming.Details.Type)
+				})
+				m.EXPECT().Name().Return("MockHandler")
+
+				return m
+			},
+			assertion: func(d *spinnaker.Dispatcher, req *http.Request, t *testing.T) {
+				results, err := d.HandleIncomingRequest(req)
+				require.NoError(t, err)
+
+				select {
+				case result := <-results:
+					require.NoError(t, result.Err)
+				case <-time.After(time.Millisecond * 100):
+					t.Error("channel never closed")
+				}
+			},
+		},
 		{
 			scenario:        "The handler fails to handle the incoming webhook",
 			requestBodyFile: "valid-webhook.json",
@@ -87,6 +112,7 @@ func TestDispatcherHandlesRequests(t *testing.T) {
 				results, err := d.HandleIncomingRequest(req)
 				require.Error(t, err)
 				require.Nil(t, results)
+				require.IsType(t, &spinnaker.DecodeError{}, err)
 			},
 		},
 	}
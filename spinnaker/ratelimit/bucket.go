@@ -0,0 +1,77 @@
+package ratelimit
+
+import "time"
+
+// bucket is a token bucket. Tokens can go negative, a negative balance is
+// the work that has been queued but not yet sent.
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	// configured is the rate the bucket was created with, it is restored once
+	// a slow down expires
+	configured float64
+	restore    time.Time
+}
+
+func newBucket(rate float64, burst int, now time.Time) *bucket {
+	if burst <= 0 {
+		burst = 1
+	}
+
+	return &bucket{rate: rate, configured: rate, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+func (b *bucket) unlimited() bool {
+	return b.rate <= 0
+}
+
+func (b *bucket) refill(now time.Time) {
+	if !b.restore.IsZero() && !now.Before(b.restore) {
+		b.rate, b.restore = b.configured, time.Time{}
+	}
+
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+}
+
+// delay returns how long until a token is available
+func (b *bucket) delay(now time.Time) time.Duration {
+	if b.unlimited() {
+		return 0
+	}
+
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *bucket) take() {
+	if !b.unlimited() {
+		b.tokens--
+	}
+}
+
+// slowDown lowers the rate of the bucket to the given rate until the given
+// time, when the configured rate is restored. Buckets are only slowed down,
+// never sped up, and unlimited buckets stay unlimited.
+func (b *bucket) slowDown(rate float64, until, now time.Time) {
+	if b.configured <= 0 || rate >= b.configured {
+		return
+	}
+
+	b.refill(now)
+	b.rate, b.restore = rate, until
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
@@ -0,0 +1,101 @@
+package ratelimit
+
+import (
+	"net/url"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+)
+
+// Sender is implemented by handlers that send webhooks somewhere. Destination
+// returns where the webhook would be sent, or an empty string when the handler
+// doesn't send it (it buffers it, or it is for another application). Only
+// webhooks that are sent are rate limited, handlers that aren't senders (such
+// as the ones recording metrics) see every webhook.
+type Sender interface {
+	spinnaker.Handler
+	Destination(incoming *types.IncomingWebhook) string
+}
+
+// URLDestination is the destination of requests to the given URL: its host,
+// so every handler posting to the same service shares its limit
+func URLDestination(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	return u.Host
+}
+
+// Handler wraps a Sender so webhooks are only handed to it as fast as the
+// limiter allows for their destination
+type Handler struct {
+	limiter *Limiter
+	sender  Sender
+}
+
+var (
+	_ spinnaker.Handler   = (*Handler)(nil)
+	_ spinnaker.Previewer = (*Handler)(nil)
+)
+
+// Handler rate limits the given sender
+func (l *Limiter) Handler(s Sender) *Handler {
+	return &Handler{limiter: l, sender: s}
+}
+
+// Name implements spinnaker.Handler, it is the name of the wrapped handler
+func (h *Handler) Name() string {
+	return h.sender.Name()
+}
+
+// Handle implements spinnaker.Handler. Webhooks dropped by the rate limit
+// policy are logged but aren't treated as errors.
+func (h *Handler) Handle(incoming *types.IncomingWebhook) error {
+	destination := h.sender.Destination(incoming)
+	if destination == "" {
+		return h.sender.Handle(incoming)
+	}
+
+	if err := h.limiter.Wait(destination, incoming.Details.Application); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"handler":     h.sender.Name(),
+			"destination": destination,
+			"application": incoming.Details.Application,
+			"hook_type":   incoming.Details.Type,
+		}).Warn("rate limit exceeded, dropping webhook")
+
+		return nil
+	}
+
+	return h.sender.Handle(incoming)
+}
+
+// Preview implements spinnaker.Previewer by previewing the wrapped handler, if
+// it supports previews. Previews aren't rate limited.
+func (h *Handler) Preview(incoming *types.IncomingWebhook) (interface{}, error) {
+	if previewer, ok := h.sender.(spinnaker.Previewer); ok {
+		return previewer.Preview(incoming)
+	}
+
+	return nil, nil
+}
+
+// Handlers wraps every sender in the map, other handlers are returned as is
+func (l *Limiter) Handlers(hs map[string][]spinnaker.Handler) map[string][]spinnaker.Handler {
+	limited := make(map[string][]spinnaker.Handler, len(hs))
+	for hookType, handlers := range hs {
+		for _, handler := range handlers {
+			if sender, ok := handler.(Sender); ok {
+				handler = l.Handler(sender)
+			}
+
+			limited[hookType] = append(limited[hookType], handler)
+		}
+	}
+
+	return limited
+}
@@ -0,0 +1,258 @@
+// Package ratelimit limits how fast webhooks are sent to each destination
+// (and for each application within a destination) with token buckets. It also
+// adapts to the X-RateLimit-* headers Datadog responds with.
+package ratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Policy decides what happens to webhooks that exceed the rate limit
+type Policy string
+
+const (
+	// PolicyQueue delays webhooks until the rate limit allows them, up to the
+	// max wait
+	PolicyQueue Policy = "queue"
+	// PolicySample drops webhooks that exceed the rate limit so only a sample
+	// of them reach the destination
+	PolicySample Policy = "sample"
+)
+
+// Outcomes counted for every destination
+const (
+	OutcomeQueued    = "queued"
+	OutcomeDropped   = "dropped"
+	OutcomeThrottled = "throttled"
+)
+
+const defaultMaxWait = time.Second * 5
+
+// ErrRateLimited is returned when a webhook is dropped because of the rate limit
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// Options configures a Limiter. A zero rate means unlimited.
+type Options struct {
+	// Rate is how many webhooks per second are sent to each destination
+	Rate  float64
+	Burst int
+	// ApplicationRate is how many webhooks per second are sent to each
+	// destination for a single application
+	ApplicationRate  float64
+	ApplicationBurst int
+	// Policy defaults to PolicyQueue
+	Policy Policy
+	// MaxWait is the longest a webhook is queued for before it is dropped,
+	// defaults to 5 seconds. It has to be shorter than the time the server
+	// waits for handlers or queued webhooks are reported as timed out.
+	MaxWait time.Duration
+}
+
+// Validate checks the policy is one the limiter knows about
+func (o Options) Validate() error {
+	switch o.Policy {
+	case "", PolicyQueue, PolicySample:
+	default:
+		return fmt.Errorf("unknown rate limit policy %q, must be queue or sample", o.Policy)
+	}
+
+	if o.Rate < 0 || o.ApplicationRate < 0 {
+		return errors.New("rate limits can't be negative")
+	}
+
+	return nil
+}
+
+// Limiter holds the token buckets of every destination and application
+type Limiter struct {
+	opts Options
+	now  func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	// paused contains the time each destination can be sent to again after it
+	// told us to back off
+	paused map[string]time.Time
+	counts map[string]int64
+	// observer is told about every counted outcome
+	observer func(destination, outcome string)
+}
+
+// New initializes a limiter with the given options
+func New(opts Options) *Limiter {
+	if opts.Policy == "" {
+		opts.Policy = PolicyQueue
+	}
+	if opts.MaxWait <= 0 {
+		opts.MaxWait = defaultMaxWait
+	}
+
+	return &Limiter{
+		opts:    opts,
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+		paused:  make(map[string]time.Time),
+		counts:  make(map[string]int64),
+	}
+}
+
+// Wait blocks until a webhook for the given application can be sent to the
+// destination. ErrRateLimited is returned (without waiting) if the policy
+// drops the webhook instead.
+func (l *Limiter) Wait(destination, application string) error {
+	delay, err := l.reserve(destination, application)
+	if err != nil {
+		return err
+	}
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	return nil
+}
+
+// OnOutcome registers a function called every time a webhook is queued,
+// dropped or throttled, e.g. to submit them as metrics. It is called without
+// holding the limiter's lock so it can send requests through the limiter.
+func (l *Limiter) OnOutcome(f func(destination, outcome string)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.observer = f
+}
+
+// count records the outcome, the observer is notified once the caller
+// released the lock
+func (l *Limiter) count(destination, outcome string) func() {
+	l.counts[destination+"."+outcome]++
+
+	if observer := l.observer; observer != nil {
+		return func() { observer(destination, outcome) }
+	}
+
+	return func() {}
+}
+
+// reserve takes a token from the destination and application buckets and
+// returns how long the caller has to wait before using it
+func (l *Limiter) reserve(destination, application string) (time.Duration, error) {
+	notify := func() {}
+	// Deferred before the unlock so it runs after it
+	defer func() { notify() }()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	buckets := []*bucket{
+		l.bucket(destination, l.opts.Rate, l.opts.Burst),
+		l.bucket(destination+"/"+application, l.opts.ApplicationRate, l.opts.ApplicationBurst),
+	}
+
+	var delay time.Duration
+	if until, ok := l.paused[destination]; ok {
+		if until.After(now) {
+			delay = until.Sub(now)
+		} else {
+			delete(l.paused, destination)
+		}
+	}
+	for _, b := range buckets {
+		if d := b.delay(now); d > delay {
+			delay = d
+		}
+	}
+
+	if delay > 0 && (l.opts.Policy == PolicySample || delay > l.opts.MaxWait) {
+		notify = l.count(destination, OutcomeDropped)
+		return 0, ErrRateLimited
+	}
+
+	for _, b := range buckets {
+		b.take()
+	}
+	if delay > 0 {
+		notify = l.count(destination, OutcomeQueued)
+	}
+
+	return delay, nil
+}
+
+func (l *Limiter) bucket(key string, rate float64, burst int) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = newBucket(rate, burst, l.now())
+		l.buckets[key] = b
+	}
+
+	return b
+}
+
+// Observe adapts the destination's limit to the rate limit headers of a
+// response, until the rate limit resets. Destinations without a configured
+// rate aren't slowed down. When the destination responds with 429 or has no
+// requests remaining, it is paused until the rate limit resets. The pause is
+// returned.
+func (l *Limiter) Observe(destination string, resp *http.Response) time.Duration {
+	limit, limitErr := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Limit"), 64)
+	period, periodErr := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Period"), 64)
+	remaining := resp.Header.Get("X-RateLimit-Remaining")
+
+	notify := func() {}
+	defer func() { notify() }()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	pause := resetAfter(resp)
+	if limitErr == nil && periodErr == nil && limit > 0 && period > 0 {
+		l.bucket(destination, l.opts.Rate, l.opts.Burst).slowDown(limit/period, now.Add(pause), now)
+	}
+
+	if resp.StatusCode != http.StatusTooManyRequests && remaining != "0" {
+		return 0
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		notify = l.count(destination, OutcomeThrottled)
+	}
+	if until := now.Add(pause); until.After(l.paused[destination]) {
+		l.paused[destination] = until
+	}
+
+	return pause
+}
+
+// resetAfter reads how long until the rate limit resets, from Datadog's
+// X-RateLimit-Reset or the standard Retry-After header (both in seconds)
+func resetAfter(resp *http.Response) time.Duration {
+	for _, header := range []string{"X-RateLimit-Reset", "Retry-After"} {
+		if seconds, err := strconv.ParseFloat(resp.Header.Get(header), 64); err == nil && seconds >= 0 {
+			return time.Duration(seconds * float64(time.Second))
+		}
+	}
+
+	return time.Second
+}
+
+// Counts returns how many webhooks were queued, dropped and throttled (429s)
+// keyed by "<destination>.<outcome>"
+func (l *Limiter) Counts() map[string]int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	counts := make(map[string]int64, len(l.counts))
+	for k, v := range l.counts {
+		counts[k] = v
+	}
+
+	return counts
+}
@@ -0,0 +1,218 @@
+package ratelimit_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/ratelimit"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+)
+
+func TestLimiterWait(t *testing.T) {
+	tests := []struct {
+		scenario string
+		opts     ratelimit.Options
+		calls    [][2]string
+		wantErrs []bool
+		counts   map[string]int64
+	}{
+		{
+			scenario: "Unlimited by default",
+			calls:    [][2]string{{"datadog", "app"}, {"datadog", "app"}, {"datadog", "app"}},
+			wantErrs: []bool{false, false, false},
+			counts:   map[string]int64{},
+		},
+		{
+			scenario: "Webhooks over the destination rate are sampled",
+			opts:     ratelimit.Options{Rate: 1, Policy: ratelimit.PolicySample},
+			calls:    [][2]string{{"datadog", "app"}, {"datadog", "other"}, {"slack", "app"}},
+			wantErrs: []bool{false, true, false},
+			counts:   map[string]int64{"datadog.dropped": 1},
+		},
+		{
+			scenario: "Applications are limited separately",
+			opts:     ratelimit.Options{ApplicationRate: 1, Policy: ratelimit.PolicySample},
+			calls:    [][2]string{{"datadog", "app"}, {"datadog", "other"}, {"datadog", "app"}},
+			wantErrs: []bool{false, false, true},
+			counts:   map[string]int64{"datadog.dropped": 1},
+		},
+		{
+			scenario: "Webhooks that would wait longer than the max wait are dropped",
+			opts:     ratelimit.Options{Rate: 0.1, MaxWait: time.Millisecond},
+			calls:    [][2]string{{"datadog", "app"}, {"datadog", "app"}},
+			wantErrs: []bool{false, true},
+			counts:   map[string]int64{"datadog.dropped": 1},
+		},
+		{
+			scenario: "Webhooks are queued within the max wait",
+			opts:     ratelimit.Options{Rate: 50},
+			calls:    [][2]string{{"datadog", "app"}, {"datadog", "app"}},
+			wantErrs: []bool{false, false},
+			counts:   map[string]int64{"datadog.queued": 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			require.NoError(t, test.opts.Validate())
+			l := ratelimit.New(test.opts)
+
+			for i, call := range test.calls {
+				err := l.Wait(call[0], call[1])
+				if test.wantErrs[i] {
+					assert.Equal(t, ratelimit.ErrRateLimited, err, "call %d", i)
+				} else {
+					assert.NoError(t, err, "call %d", i)
+				}
+			}
+
+			assert.Equal(t, test.counts, l.Counts())
+		})
+	}
+}
+
+func TestLimiterReportsOutcomes(t *testing.T) {
+	l := ratelimit.New(ratelimit.Options{Rate: 1, Policy: ratelimit.PolicySample})
+
+	var outcomes []string
+	l.OnOutcome(func(destination, outcome string) {
+		// The observer can use the limiter, it isn't locked anymore
+		l.Counts()
+		outcomes = append(outcomes, destination+"."+outcome)
+	})
+
+	require.NoError(t, l.Wait("datadog", "app"))
+	assert.Equal(t, ratelimit.ErrRateLimited, l.Wait("datadog", "app"))
+	l.Observe("slack", &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}})
+
+	assert.Equal(t, []string{"datadog.dropped", "slack.throttled"}, outcomes)
+}
+
+func TestOptionsValidation(t *testing.T) {
+	assert.Error(t, ratelimit.Options{Policy: "drop-everything"}.Validate())
+	assert.Error(t, ratelimit.Options{Rate: -1}.Validate())
+}
+
+func TestLimiterObservesRateLimitHeaders(t *testing.T) {
+	l := ratelimit.New(ratelimit.Options{Policy: ratelimit.PolicySample})
+
+	resp := &http.Response{StatusCode: http.StatusAccepted, Header: http.Header{}}
+	resp.Header.Set("X-RateLimit-Remaining", "0")
+	resp.Header.Set("X-RateLimit-Reset", "60")
+
+	assert.Equal(t, time.Minute, l.Observe("datadog", resp))
+	assert.Equal(t, ratelimit.ErrRateLimited, l.Wait("datadog", "app"))
+	assert.NoError(t, l.Wait("slack", "app"))
+}
+
+func TestLimiterRestoresTheRateAfterTheReset(t *testing.T) {
+	resp := &http.Response{StatusCode: http.StatusAccepted, Header: http.Header{}}
+	resp.Header.Set("X-RateLimit-Limit", "1")
+	resp.Header.Set("X-RateLimit-Period", "10")
+	resp.Header.Set("X-RateLimit-Reset", "0.05")
+
+	t.Run("Limited destinations are slowed down until the reset", func(t *testing.T) {
+		l := ratelimit.New(ratelimit.Options{Rate: 100, Policy: ratelimit.PolicySample})
+		l.Observe("datadog", resp)
+
+		require.NoError(t, l.Wait("datadog", "app"))
+		assert.Equal(t, ratelimit.ErrRateLimited, l.Wait("datadog", "app"))
+
+		time.Sleep(time.Millisecond * 60)
+		assert.NoError(t, l.Wait("datadog", "app"))
+	})
+
+	t.Run("Unlimited destinations aren't slowed down", func(t *testing.T) {
+		l := ratelimit.New(ratelimit.Options{Policy: ratelimit.PolicySample})
+		l.Observe("datadog", resp)
+
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, l.Wait("datadog", "app"))
+		}
+	})
+}
+
+func TestTransportPausesTheDestination(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		assert.Equal(t, "event", string(body))
+
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("X-RateLimit-Reset", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	l := ratelimit.New(ratelimit.Options{Policy: ratelimit.PolicySample})
+	resp, err := l.Client("datadog", ts.Client()).Post(ts.URL, "text/plain", strings.NewReader("event"))
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&requests), "requests aren't retried")
+	assert.Equal(t, ratelimit.ErrRateLimited, l.Wait("datadog", "app"), "webhooks that follow wait for the reset")
+	assert.Equal(t, map[string]int64{"datadog.throttled": 1, "datadog.dropped": 1}, l.Counts())
+}
+
+type countingHandler struct{ handled int }
+
+func (h *countingHandler) Name() string { return "CountingHandler" }
+func (h *countingHandler) Handle(*types.IncomingWebhook) error {
+	h.handled++
+	return nil
+}
+
+// countingSender sends pipeline webhooks to the host of its URL
+type countingSender struct {
+	countingHandler
+	url string
+}
+
+func (h *countingSender) Destination(incoming *types.IncomingWebhook) string {
+	if !strings.HasPrefix(incoming.Details.Type, "orca:pipeline:") {
+		return ""
+	}
+
+	return ratelimit.URLDestination(h.url)
+}
+
+func TestHandlerDropsWebhooksOverTheLimit(t *testing.T) {
+	l := ratelimit.New(ratelimit.Options{Rate: 1, Policy: ratelimit.PolicySample})
+	wrapped := &countingSender{url: "https://hooks.slack.com/services/T000/B000/XXXX"}
+	h := l.Handler(wrapped)
+
+	incoming := &types.IncomingWebhook{Details: types.Details{Application: "app", Type: "orca:pipeline:complete"}}
+	require.NoError(t, h.Handle(incoming))
+	require.NoError(t, h.Handle(incoming))
+
+	assert.Equal(t, "CountingHandler", h.Name())
+	assert.Equal(t, 1, wrapped.handled)
+	assert.Equal(t, map[string]int64{"hooks.slack.com.dropped": 1}, l.Counts())
+
+	t.Run("Webhooks that aren't sent aren't limited", func(t *testing.T) {
+		stage := &types.IncomingWebhook{Details: types.Details{Application: "app", Type: "orca:stage:complete"}}
+		require.NoError(t, h.Handle(stage))
+		require.NoError(t, h.Handle(stage))
+		assert.Equal(t, 3, wrapped.handled)
+	})
+}
+
+func TestHandlersOnlyWrapSenders(t *testing.T) {
+	l := ratelimit.New(ratelimit.Options{Rate: 1, Policy: ratelimit.PolicySample})
+	recorder, sender := &countingHandler{}, &countingSender{url: "https://events.pagerduty.com/v2/enqueue"}
+
+	limited := l.Handlers(map[string][]spinnaker.Handler{"orca:pipeline:complete": {recorder, sender}})
+	require.Len(t, limited["orca:pipeline:complete"], 2)
+	assert.Equal(t, recorder, limited["orca:pipeline:complete"][0])
+	assert.IsType(t, &ratelimit.Handler{}, limited["orca:pipeline:complete"][1])
+}
@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Transport is an http.RoundTripper that feeds the rate limit headers of
+// every response back into the limiter
+type Transport struct {
+	limiter     *Limiter
+	destination string
+	next        http.RoundTripper
+}
+
+// Transport wraps the given round tripper (http.DefaultTransport when nil) for
+// requests to the given destination
+func (l *Limiter) Transport(destination string, next http.RoundTripper) *Transport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &Transport{limiter: l, destination: destination, next: next}
+}
+
+// Client returns a copy of the client with its transport wrapped
+func (l *Limiter) Client(destination string, c *http.Client) *http.Client {
+	if c == nil {
+		c = http.DefaultClient
+	}
+
+	limited := *c
+	limited.Transport = l.Transport(destination, c.Transport)
+
+	return &limited
+}
+
+// RoundTrip implements http.RoundTripper. Requests aren't delayed or retried,
+// webhooks only wait for the limiter in Handler. Responses that pause the
+// destination delay the webhooks that follow.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if pause := t.limiter.Observe(t.destination, resp); resp.StatusCode == http.StatusTooManyRequests {
+		logrus.WithFields(logrus.Fields{"destination": t.destination, "reset": pause}).Warn("destination is rate limiting requests")
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,153 @@
+package spinnaker
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// HandlerConfig registers a handler of the named type for the given hook
+// types. Options are decoded by the factory of the type.
+type HandlerConfig struct {
+	Type      string                 `json:"type" yaml:"type"`
+	HookTypes []string               `json:"hookTypes" yaml:"hookTypes"`
+	Options   map[string]interface{} `json:"options,omitempty" yaml:"options"`
+}
+
+// DecodeOptions unmarshals the options into v (through JSON, so v uses json
+// tags). Unknown options are rejected.
+func (hc HandlerConfig) DecodeOptions(v interface{}) error {
+	b, err := json.Marshal(hc.Options)
+	if err != nil {
+		return errors.Wrap(err, "could not encode options")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return errors.Wrap(err, "could not decode options")
+	}
+
+	return nil
+}
+
+// HandlerFactory builds a handler from its configuration
+type HandlerFactory func(config HandlerConfig) (Handler, error)
+
+// Registry builds handlers from configuration with the factories registered
+// under each handler type name
+type Registry struct {
+	mu        sync.RWMutex
+	factories map[string]HandlerFactory
+}
+
+// DefaultRegistry holds the handler types registered with RegisterHandlerType.
+// The bridge merges it over its own types ("datadog-event", "http-forward"...)
+// so types registered here replace the built-in ones of the same name.
+var DefaultRegistry = NewRegistry()
+
+// RegisterHandlerType registers a handler type with the DefaultRegistry, it is
+// meant to be called from the main package (or an init function) of programs
+// running the bridge with their own handlers
+func RegisterHandlerType(name string, f HandlerFactory) {
+	DefaultRegistry.Register(name, f)
+}
+
+// NewRegistry initializes an empty registry
+func NewRegistry() *Registry {
+	return &Registry{factories: make(map[string]HandlerFactory)}
+}
+
+// Register adds the factory for the named handler type. Like sql.Register it
+// panics when the name is already taken or the factory is nil.
+func (r *Registry) Register(name string, f HandlerFactory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if f == nil {
+		panic("spinnaker: handler factory for " + name + " is nil")
+	}
+	if _, ok := r.factories[name]; ok {
+		panic("spinnaker: handler type " + name + " is already registered")
+	}
+
+	r.factories[name] = f
+}
+
+// Merge adds the handler types of other, replacing the ones with the same
+// name. It returns the sorted names of the replaced types.
+func (r *Registry) Merge(other *Registry) []string {
+	other.mu.RLock()
+	defer other.mu.RUnlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var replaced []string
+	for name, f := range other.factories {
+		if _, ok := r.factories[name]; ok {
+			replaced = append(replaced, name)
+		}
+		r.factories[name] = f
+	}
+	sort.Strings(replaced)
+
+	return replaced
+}
+
+// Types returns the sorted names of the registered handler types
+func (r *Registry) Types() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	types := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+
+	return types
+}
+
+// New builds a handler from its configuration
+func (r *Registry) New(config HandlerConfig) (Handler, error) {
+	r.mu.RLock()
+	f, ok := r.factories[config.Type]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil, errors.Errorf("unknown handler type %q (registered types: %s)", config.Type, strings.Join(r.Types(), ", "))
+	}
+
+	handler, err := f(config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not build %s handler", config.Type)
+	}
+
+	return handler, nil
+}
+
+// Handlers builds the handler of every configuration, keyed by the hook types
+// they are registered for
+func (r *Registry) Handlers(configs []HandlerConfig) (HandlerMap, error) {
+	handlers := make(HandlerMap)
+	for i, config := range configs {
+		if len(config.HookTypes) == 0 {
+			return nil, errors.Errorf("handler %d (%s) has no hookTypes", i, config.Type)
+		}
+
+		handler, err := r.New(config)
+		if err != nil {
+			return nil, errors.Wrapf(err, "handler %d", i)
+		}
+
+		for _, hookType := range config.HookTypes {
+			handlers[hookType] = append(handlers[hookType], handler)
+		}
+	}
+
+	return handlers, nil
+}
@@ -0,0 +1,120 @@
+package spinnaker_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/mocks"
+)
+
+// echoOptions are the options of the "echo" handler type used in tests
+type echoOptions struct {
+	Message string `json:"message"`
+}
+
+func echoRegistry() (*spinnaker.Registry, *[]echoOptions) {
+	var built []echoOptions
+	r := spinnaker.NewRegistry()
+	r.Register("echo", func(config spinnaker.HandlerConfig) (spinnaker.Handler, error) {
+		var opts echoOptions
+		if err := config.DecodeOptions(&opts); err != nil {
+			return nil, err
+		}
+		built = append(built, opts)
+
+		return &mocks.MockHandler{}, nil
+	})
+
+	return r, &built
+}
+
+func TestRegistryBuildsHandlers(t *testing.T) {
+	r, built := echoRegistry()
+
+	handlers, err := r.Handlers([]spinnaker.HandlerConfig{
+		{
+			Type:      "echo",
+			HookTypes: []string{"orca:pipeline:complete", "orca:pipeline:failed"},
+			Options:   map[string]interface{}{"message": "done"},
+		},
+		{
+			Type:      "echo",
+			HookTypes: []string{spinnaker.AllHookTypes},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []echoOptions{{Message: "done"}, {}}, *built)
+	assert.Len(t, handlers["orca:pipeline:complete"], 1)
+	assert.Len(t, handlers["orca:pipeline:failed"], 1)
+	assert.Len(t, handlers[spinnaker.AllHookTypes], 1)
+	assert.Same(t, handlers["orca:pipeline:complete"][0], handlers["orca:pipeline:failed"][0])
+}
+
+func TestRegistryRejectsInvalidConfigs(t *testing.T) {
+	tests := []struct {
+		scenario string
+		config   spinnaker.HandlerConfig
+		err      string
+	}{
+		{
+			scenario: "Unknown handler types list the registered ones",
+			config:   spinnaker.HandlerConfig{Type: "nope", HookTypes: []string{"orca:pipeline:failed"}},
+			err:      `unknown handler type "nope" (registered types: echo)`,
+		},
+		{
+			scenario: "Handlers need hook types",
+			config:   spinnaker.HandlerConfig{Type: "echo"},
+			err:      "has no hookTypes",
+		},
+		{
+			scenario: "Unknown options are rejected",
+			config: spinnaker.HandlerConfig{
+				Type:      "echo",
+				HookTypes: []string{"orca:pipeline:failed"},
+				Options:   map[string]interface{}{"mesage": "typo"},
+			},
+			err: `unknown field "mesage"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			r, _ := echoRegistry()
+			_, err := r.Handlers([]spinnaker.HandlerConfig{test.config})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.err)
+		})
+	}
+}
+
+func TestRegistryTypes(t *testing.T) {
+	r, _ := echoRegistry()
+
+	assert.Panics(t, func() {
+		r.Register("echo", func(spinnaker.HandlerConfig) (spinnaker.Handler, error) { return nil, nil })
+	}, "types can only be registered once")
+
+	r.Register("alpha", func(spinnaker.HandlerConfig) (spinnaker.Handler, error) { return nil, nil })
+	assert.Equal(t, []string{"alpha", "echo"}, r.Types())
+
+	t.Run("Merged types replace the ones with the same name", func(t *testing.T) {
+		builtin, _ := echoRegistry()
+		builtin.Register("beta", func(spinnaker.HandlerConfig) (spinnaker.Handler, error) { return nil, nil })
+
+		custom := &mocks.MockHandler{}
+		registered := spinnaker.NewRegistry()
+		registered.Register("echo", func(spinnaker.HandlerConfig) (spinnaker.Handler, error) { return custom, nil })
+		registered.Register("gamma", func(spinnaker.HandlerConfig) (spinnaker.Handler, error) { return nil, nil })
+
+		assert.Equal(t, []string{"echo"}, builtin.Merge(registered))
+		assert.Equal(t, []string{"beta", "echo", "gamma"}, builtin.Types())
+
+		handler, err := builtin.New(spinnaker.HandlerConfig{Type: "echo"})
+		require.NoError(t, err)
+		assert.Same(t, custom, handler)
+	})
+}
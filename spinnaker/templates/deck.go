@@ -0,0 +1,24 @@
+package templates
+
+import (
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+)
+
+// deckFuncs returns the functions linking to Deck (Spinnaker's UI) hosted at
+// the given base URL, their links are empty without one
+func deckFuncs(deckURL string) map[string]interface{} {
+	return map[string]interface{}{
+		// applicationURL links to the application of the webhook
+		"applicationURL": func(incoming *types.IncomingWebhook) string {
+			return incoming.ApplicationURL(deckURL)
+		},
+		// executionURL links to the execution of the webhook
+		"executionURL": func(incoming *types.IncomingWebhook) string {
+			return incoming.ExecutionURL(deckURL)
+		},
+		// stageURL links to the stage of the webhook
+		"stageURL": func(incoming *types.IncomingWebhook) string {
+			return incoming.StageURL(deckURL)
+		},
+	}
+}
@@ -0,0 +1,197 @@
+package templates
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/pkg/errors"
+)
+
+// EscapeMode controls how the values a template interpolates are escaped. The
+// literal text of the template is never escaped.
+type EscapeMode string
+
+// Supported escape modes
+const (
+	// EscapeNone outputs values as they are
+	EscapeNone EscapeMode = "none"
+	// EscapeHTML escapes values for HTML
+	EscapeHTML EscapeMode = "html"
+	// EscapeMarkdown escapes characters that have a meaning in markdown
+	EscapeMarkdown EscapeMode = "markdown"
+	// EscapeJSON escapes values so they can be written inside JSON strings
+	EscapeJSON EscapeMode = "json"
+)
+
+// escapers are the template functions every escape mode pipes values through
+var escapers = map[EscapeMode]string{
+	EscapeHTML:     "html",
+	EscapeMarkdown: "escapeMarkdown",
+	EscapeJSON:     "escapeJSON",
+}
+
+// Validate returns an error for unknown escape modes
+func (m EscapeMode) Validate() error {
+	switch m {
+	case "", EscapeNone, EscapeHTML, EscapeMarkdown, EscapeJSON:
+		return nil
+	}
+
+	return errors.Errorf("unknown escape mode %q (must be none, html, markdown or json)", string(m))
+}
+
+// Escape rewrites every action of the parsed template (and the templates it
+// defines) so the values they output go through the escaper of the mode. The
+// template must have been parsed with Funcs.
+func Escape(t *template.Template, mode EscapeMode) error {
+	if err := mode.Validate(); err != nil {
+		return err
+	}
+
+	escaper, ok := escapers[mode]
+	if !ok {
+		return nil
+	}
+
+	for _, tmpl := range t.Templates() {
+		if tmpl.Tree != nil {
+			escapeNode(tmpl.Tree.Root, escaper)
+		}
+	}
+
+	return nil
+}
+
+func escapeNode(node parse.Node, escaper string) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			escapeNode(child, escaper)
+		}
+	case *parse.ActionNode:
+		escapeAction(n, escaper)
+	case *parse.IfNode:
+		escapeNode(n.List, escaper)
+		escapeNode(n.ElseList, escaper)
+	case *parse.RangeNode:
+		escapeNode(n.List, escaper)
+		escapeNode(n.ElseList, escaper)
+	case *parse.WithNode:
+		escapeNode(n.List, escaper)
+		escapeNode(n.ElseList, escaper)
+	}
+}
+
+func escapeAction(n *parse.ActionNode, escaper string) {
+	// Assignments don't output anything
+	if len(n.Pipe.Decl) > 0 {
+		return
+	}
+
+	// Don't escape twice if the template already does it explicitly
+	if cmds := n.Pipe.Cmds; len(cmds) > 0 {
+		last := cmds[len(cmds)-1]
+		if len(last.Args) > 0 {
+			if ident, ok := last.Args[0].(*parse.IdentifierNode); ok && ident.Ident == escaper {
+				return
+			}
+		}
+	}
+
+	n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{
+		NodeType: parse.NodeCommand,
+		Pos:      n.Pos,
+		Args:     []parse.Node{parse.NewIdentifier(escaper).SetPos(n.Pos)},
+	})
+}
+
+// markdownEscaper escapes the characters that have a meaning in markdown
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"`", "\\`",
+	"*", `\*`,
+	"_", `\_`,
+	"{", `\{`,
+	"}", `\}`,
+	"[", `\[`,
+	"]", `\]`,
+	"(", `\(`,
+	")", `\)`,
+	"#", `\#`,
+	"+", `\+`,
+	"-", `\-`,
+	"!", `\!`,
+	"|", `\|`,
+	"<", `\<`,
+	">", `\>`,
+)
+
+// Markdown is markdown that is known to be safe, it isn't escaped again by
+// the markdown escape mode. It is returned by the markdown helpers.
+type Markdown string
+
+// MarkdownEscape escapes a value so it renders literally in markdown
+func MarkdownEscape(v interface{}) Markdown {
+	if md, ok := v.(Markdown); ok {
+		return md
+	}
+
+	return Markdown(markdownEscaper.Replace(toString(v)))
+}
+
+// MarkdownLink builds a markdown link, escaping the text
+func MarkdownLink(text interface{}, url string) Markdown {
+	if url == "" {
+		return MarkdownEscape(text)
+	}
+
+	return Markdown("[" + string(MarkdownEscape(text)) + "](" + strings.Replace(url, ")", "%29", -1) + ")")
+}
+
+// MarkdownRow builds a markdown table row from the given cells. Cells are
+// escaped (unless they are markdown themselves) and new lines are removed so
+// they don't break the table.
+func MarkdownRow(cells ...interface{}) Markdown {
+	parts := make([]string, len(cells))
+	for i, cell := range cells {
+		parts[i] = strings.Replace(string(MarkdownEscape(cell)), "\n", " ", -1)
+	}
+
+	return Markdown("| " + strings.Join(parts, " | ") + " |")
+}
+
+// MarkdownHeader builds the header row of a markdown table and the separator
+// row underneath it
+func MarkdownHeader(cells ...interface{}) Markdown {
+	separators := make([]string, len(cells))
+	for i := range separators {
+		separators[i] = "---"
+	}
+
+	return MarkdownRow(cells...) + Markdown("\n| "+strings.Join(separators, " | ")+" |")
+}
+
+// JSON is an encoded JSON value, it isn't escaped again by the json escape
+// mode. It is returned by the json function.
+type JSON string
+
+// JSONEscape escapes a value so it can be written inside a JSON string
+func JSONEscape(v interface{}) JSON {
+	if j, ok := v.(JSON); ok {
+		return j
+	}
+
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(toString(v))
+
+	quoted := strings.TrimSpace(buf.String())
+	return JSON(quoted[1 : len(quoted)-1])
+}
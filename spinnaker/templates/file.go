@@ -0,0 +1,55 @@
+package templates
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// File is a parsed event templates file. Each hook type entry holds the keys
+// of several handlers (title and text for Datadog events, forward, slack,
+// teams, pagerduty), every package decodes the keys it cares about.
+type File struct {
+	json []byte
+}
+
+// LoadFile reads and parses the templates file at the given path, an empty
+// path is an empty file
+func LoadFile(path string) (*File, error) {
+	if path == "" {
+		return ParseFile(nil)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read template file")
+	}
+
+	return ParseFile(b)
+}
+
+// ParseFile parses the contents of a templates file
+func ParseFile(b []byte) (*File, error) {
+	var entries map[string]interface{}
+	if err := yaml.Unmarshal(b, &entries); err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal template file")
+	}
+	if entries == nil {
+		entries = make(map[string]interface{})
+	}
+
+	encoded, err := json.Marshal(entries)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode template file")
+	}
+
+	return &File{json: encoded}, nil
+}
+
+// Decode unmarshals the entries of the file into a map of hook types, such as
+// map[string]*EventTemplate. Keys of other handlers are ignored.
+func (f *File) Decode(entries interface{}) error {
+	return errors.Wrap(json.Unmarshal(f.json, entries), "could not unmarshal template file")
+}
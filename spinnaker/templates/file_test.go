@@ -0,0 +1,53 @@
+package templates_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/templates"
+)
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "templates.yml")
+	require.NoError(t, ioutil.WriteFile(path, []byte("orca:pipeline:failed:\n  title: failed\n  priority: 1\n  slack:\n    - channel: deploys\n"), 0644))
+
+	file, err := templates.LoadFile(path)
+	require.NoError(t, err)
+
+	var titles map[string]struct {
+		Title string `json:"title"`
+	}
+	require.NoError(t, file.Decode(&titles))
+	assert.Equal(t, "failed", titles["orca:pipeline:failed"].Title)
+
+	var channels map[string]struct {
+		Slack []struct {
+			Channel string `json:"channel"`
+		} `json:"slack"`
+	}
+	require.NoError(t, file.Decode(&channels))
+	assert.Equal(t, "deploys", channels["orca:pipeline:failed"].Slack[0].Channel)
+
+	t.Run("An empty path is an empty file", func(t *testing.T) {
+		file, err := templates.LoadFile("")
+		require.NoError(t, err)
+
+		entries := make(map[string]interface{})
+		require.NoError(t, file.Decode(&entries))
+		assert.Empty(t, entries)
+	})
+
+	t.Run("Missing files are an error", func(t *testing.T) {
+		_, err := templates.LoadFile(filepath.Join(t.TempDir(), "nope.yml"))
+		assert.Error(t, err)
+	})
+
+	t.Run("Invalid YAML is an error", func(t *testing.T) {
+		_, err := templates.ParseFile([]byte("orca:pipeline:failed: [\n"))
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,358 @@
+// Package templates contains the function library available to every template
+// rendered against an incoming webhook (event titles and text, forward bodies,
+// notifications).
+package templates
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+)
+
+// Funcs returns the function map for templates. It can be passed to the Funcs
+// method of both text/template and html/template templates. The URL functions
+// link to Deck hosted at deckURL, their links are empty if it is.
+//
+// Functions that take a value to operate on accept it as the last argument so
+// they can be used in pipelines, for example:
+//
+//	{{ .Content.StartTime | inZone "America/New_York" | formatTime "15:04 MST" }}
+//	{{ duration .Content.StartTime .Content.EndTime | humanizeDuration }}
+//	{{ get .Content.Context "stageDetails" "endTime" | default "unknown" }}
+func Funcs(deckURL string) map[string]interface{} {
+	funcs := map[string]interface{}{
+		"duration":         duration,
+		"humanizeDuration": humanizeDuration,
+		"formatTime":       formatTime,
+		"inZone":           inZone,
+		"truncate":         truncate,
+		"upper":            strings.ToUpper,
+		"lower":            strings.ToLower,
+		"default":          defaultValue,
+		"join":             join,
+		"regexReplace":     regexReplace,
+		"json":             toJSON,
+		"get":              get,
+		"payload":          payload,
+		"escapeMarkdown":   MarkdownEscape,
+		"escapeJSON":       JSONEscape,
+		"markdownLink":     MarkdownLink,
+		"markdownRow":      MarkdownRow,
+		"markdownHeader":   MarkdownHeader,
+	}
+	for name, fn := range deckFuncs(deckURL) {
+		funcs[name] = fn
+	}
+
+	return funcs
+}
+
+// Layouts that can be given to formatTime by name instead of a Go layout
+var namedLayouts = map[string]string{
+	"RFC3339":  time.RFC3339,
+	"RFC1123":  time.RFC1123,
+	"Kitchen":  time.Kitchen,
+	"DateTime": "2006-01-02 15:04:05",
+	"DateOnly": "2006-01-02",
+	"TimeOnly": "15:04:05",
+}
+
+// toTime converts timestamps, times and epoch milliseconds (numbers or
+// numeric strings, as found in stage contexts) to a time
+func toTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case *time.Time:
+		if t == nil {
+			return time.Time{}, nil
+		}
+		return *t, nil
+	case types.Timestamp:
+		return t.Time, nil
+	case *types.Timestamp:
+		if t == nil {
+			return time.Time{}, nil
+		}
+		return t.Time, nil
+	case nil:
+		return time.Time{}, nil
+	}
+
+	ms, err := toInt64(v)
+	if err != nil {
+		return time.Time{}, errors.Errorf("can't convert %T to a time", v)
+	}
+
+	return time.Unix(0, ms*int64(time.Millisecond)), nil
+}
+
+func toInt64(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case float64:
+		return int64(n), nil
+	case json.Number:
+		return n.Int64()
+	case string:
+		return strconv.ParseInt(n, 10, 64)
+	}
+
+	return 0, errors.Errorf("can't convert %T to a number", v)
+}
+
+// duration returns the time between two timestamps, zero if either is unset
+func duration(start, end interface{}) (time.Duration, error) {
+	s, err := toTime(start)
+	if err != nil {
+		return 0, err
+	}
+
+	e, err := toTime(end)
+	if err != nil {
+		return 0, err
+	}
+
+	if s.IsZero() || e.IsZero() {
+		return 0, nil
+	}
+
+	return e.Sub(s), nil
+}
+
+// humanizeDuration formats a duration (or milliseconds) for people, for
+// example "1h 5m" or "2m 30s"
+func humanizeDuration(v interface{}) (string, error) {
+	d, ok := v.(time.Duration)
+	if !ok {
+		ms, err := toInt64(v)
+		if err != nil {
+			return "", errors.Errorf("can't convert %T to a duration", v)
+		}
+		d = time.Duration(ms) * time.Millisecond
+	}
+
+	return HumanizeDuration(d), nil
+}
+
+// HumanizeDuration formats a duration for people, for example "1h 5m" or
+// "2m 30s"
+func HumanizeDuration(d time.Duration) string {
+	if d < 0 {
+		d = -d
+	}
+
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+
+	d = d.Round(time.Second)
+	days, d := d/(24*time.Hour), d%(24*time.Hour)
+	hours, d := d/time.Hour, d%time.Hour
+	minutes, d := d/time.Minute, d%time.Minute
+	seconds := d / time.Second
+
+	parts := make([]string, 0, 2)
+	for _, unit := range []struct {
+		value  time.Duration
+		suffix string
+	}{{days, "d"}, {hours, "h"}, {minutes, "m"}, {seconds, "s"}} {
+		if unit.value == 0 {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%d%s", unit.value, unit.suffix))
+	}
+
+	// Two units are precise enough for anybody reading an event
+	if len(parts) > 2 {
+		parts = parts[:2]
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// formatTime formats a timestamp with a Go layout or one of the named layouts
+func formatTime(layout string, v interface{}) (string, error) {
+	t, err := toTime(v)
+	if err != nil {
+		return "", err
+	}
+
+	if t.IsZero() {
+		return "", nil
+	}
+
+	if named, ok := namedLayouts[layout]; ok {
+		layout = named
+	}
+
+	return t.Format(layout), nil
+}
+
+// inZone converts a timestamp to the given IANA time zone
+func inZone(zone string, v interface{}) (time.Time, error) {
+	t, err := toTime(v)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "unknown time zone %q", zone)
+	}
+
+	return t.In(loc), nil
+}
+
+// truncate shortens a string to at most length characters, ending it with an
+// ellipsis when it was cut
+func truncate(length int, s string) string {
+	if length <= 0 {
+		return ""
+	}
+
+	if utf8.RuneCountInString(s) <= length {
+		return s
+	}
+
+	runes := []rune(s)
+	if length == 1 {
+		return string(runes[:1])
+	}
+
+	return string(runes[:length-1]) + "…"
+}
+
+// defaultValue returns def when the value is empty (nil, zero or an empty
+// string, slice or map)
+func defaultValue(def interface{}, v interface{}) interface{} {
+	if isEmpty(v) {
+		return def
+	}
+
+	return v
+}
+
+func isEmpty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return rv.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	}
+
+	return rv.IsZero()
+}
+
+// join concatenates the elements of a list with a separator
+func join(sep string, v interface{}) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+
+	if ss, ok := v.([]string); ok {
+		return strings.Join(ss, sep), nil
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return "", errors.Errorf("can't join %T", v)
+	}
+
+	parts := make([]string, rv.Len())
+	for i := range parts {
+		parts[i] = fmt.Sprint(rv.Index(i).Interface())
+	}
+
+	return strings.Join(parts, sep), nil
+}
+
+// regexReplace replaces every match of the pattern in s. The replacement can
+// reference groups with $1.
+func regexReplace(pattern, replacement, s string) (string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid pattern %q", pattern)
+	}
+
+	return re.ReplaceAllString(s, replacement), nil
+}
+
+// toString formats a value the same way templates print it
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return s
+	case Markdown:
+		return string(s)
+	case JSON:
+		return string(s)
+	}
+
+	return fmt.Sprint(v)
+}
+
+// toJSON encodes a value as JSON
+func toJSON(v interface{}) (JSON, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	return JSON(b), nil
+}
+
+// get safely looks up nested keys in maps (and indexes in lists), for example
+// in a stage context. It returns nil instead of failing when a key is missing.
+// Keys are matched exactly, so keys containing dots such as
+// "deploy.server.groups" work.
+func get(v interface{}, keys ...string) interface{} {
+	current := v
+	for _, key := range keys {
+		switch c := current.(type) {
+		case map[string]interface{}:
+			current = c[key]
+		case map[string]string:
+			current = c[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(c) {
+				return nil
+			}
+			current = c[i]
+		default:
+			return nil
+		}
+
+		if current == nil {
+			return nil
+		}
+	}
+
+	return current
+}
+
+// payload returns the content of the webhook decoded into the payload struct
+// of its hook type (see types.NewPayload)
+func payload(incoming *types.IncomingWebhook) (interface{}, error) {
+	return incoming.Payload()
+}
@@ -0,0 +1,166 @@
+package templates_test
+
+import (
+	"bytes"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/templates"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+)
+
+func TestFuncs(t *testing.T) {
+	start := time.Date(2018, 2, 9, 22, 6, 43, 91*int(time.Millisecond), time.UTC)
+	incoming := &types.IncomingWebhook{
+		Details: types.Details{Application: "payments-api", Type: "orca:stage:complete"},
+		Content: types.Content{
+			StartTime: types.Timestamp{Time: start},
+			EndTime:   types.Timestamp{Time: start.Add(time.Minute*3 + time.Second*25)},
+			Context: map[string]interface{}{
+				"deploy.server.groups": map[string]interface{}{"us-east-1": []interface{}{"payments-v001"}},
+				"stageDetails":         map[string]interface{}{"endTime": float64(1518214003420)},
+				"regions":              []interface{}{"us-east-1", "us-west-2"},
+			},
+		},
+	}
+
+	tests := []struct {
+		scenario string
+		template string
+		expected string
+		wantErr  bool
+	}{
+		{"duration between timestamps", `{{ duration .Content.StartTime .Content.EndTime }}`, "3m25s", false},
+		{"duration of something that isn't a time", `{{ duration .Content.StartTime .Content.Execution }}`, "", true},
+		{"humanized duration", `{{ duration .Content.StartTime .Content.EndTime | humanizeDuration }}`, "3m 25s", false},
+		{"humanized milliseconds", `{{ humanizeDuration 90061000 }}`, "1d 1h", false},
+		{"humanized sub second duration", `{{ humanizeDuration 329 }}`, "329ms", false},
+		{"format time with a layout", `{{ formatTime "2006-01-02 15:04" .Content.StartTime }}`, "2018-02-09 22:06", false},
+		{"format time with a named layout", `{{ formatTime "RFC3339" .Content.StartTime }}`, "2018-02-09T22:06:43Z", false},
+		{"format time in a time zone", `{{ .Content.StartTime | inZone "America/New_York" | formatTime "15:04 MST" }}`, "17:06 EST", false},
+		{"format epoch milliseconds", `{{ get .Content.Context "stageDetails" "endTime" | formatTime "15:04:05" }}`, "22:06:43", false},
+		{"format something that isn't a time", `{{ formatTime "15:04" .Content.Execution }}`, "", true},
+		{"unknown time zone", `{{ inZone "Mars/Olympus" .Content.StartTime }}`, "", true},
+		{"truncate long strings", `{{ truncate 8 .Details.Application }}`, "payment…", false},
+		{"truncate short strings", `{{ truncate 20 .Details.Application }}`, "payments-api", false},
+		{"upper", `{{ upper .Details.Application }}`, "PAYMENTS-API", false},
+		{"lower", `{{ lower "ORCA" }}`, "orca", false},
+		{"default for empty values", `{{ .Content.Name | default "unknown stage" }}`, "unknown stage", false},
+		{"default for set values", `{{ .Details.Application | default "unknown" }}`, "payments-api", false},
+		{"join lists", `{{ get .Content.Context "regions" | join ", " }}`, "us-east-1, us-west-2", false},
+		{"join a missing list", `{{ get .Content.Context "nope" | join ", " }}`, "", false},
+		{"regex replace", `{{ regexReplace "^orca:(\\w+):.*$" "$1" .Details.Type }}`, "stage", false},
+		{"invalid regex", `{{ regexReplace "(" "" .Details.Type }}`, "", true},
+		{"json", `{{ json .Details.Application }}`, `"payments-api"`, false},
+		{"get keys containing dots", `{{ get .Content.Context "deploy.server.groups" "us-east-1" "0" }}`, "payments-v001", false},
+		{"get missing keys", `{{ get .Content.Context "stageDetails" "nope" "deeper" | default "-" }}`, "-", false},
+		{"escape markdown", `{{ escapeMarkdown "*bold* [link]" }}`, `\*bold\* \[link\]`, false},
+		{"markdown link", `{{ markdownLink .Details.Application "https://example.com/a_(b)" }}`, `[payments\-api](https://example.com/a_(b%29)`, false},
+		{"markdown row", `{{ markdownRow "a|b" 2 (markdownLink "x" "https://x") }}`, `| a\|b | 2 | [x](https://x) |`, false},
+		{"markdown header", `{{ markdownHeader "Stage" "Status" }}`, "| Stage | Status |\n| --- | --- |", false},
+		{"typed payload", `{{ (payload .).Context.StageDetails.EndTime.Millis }}`, "1518214003420", false},
+		{"get out of range indexes", `{{ get .Content.Context "regions" "5" | default "-" }}`, "-", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			tmpl, err := template.New("test").Funcs(templates.Funcs("")).Parse(test.template)
+			require.NoError(t, err)
+
+			buf := new(bytes.Buffer)
+			err = tmpl.Execute(buf, incoming)
+			if test.wantErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, buf.String())
+		})
+	}
+}
+
+func TestEscape(t *testing.T) {
+	incoming := &types.IncomingWebhook{
+		Details: types.Details{Application: "<b>_app_</b>", Type: "orca:stage:complete"},
+	}
+
+	tests := []struct {
+		scenario string
+		mode     templates.EscapeMode
+		template string
+		expected string
+	}{
+		{"none", templates.EscapeNone, `{{ .Details.Application }} & co`, "<b>_app_</b> & co"},
+		{"html", templates.EscapeHTML, `{{ .Details.Application }} & co`, "&lt;b&gt;_app_&lt;/b&gt; & co"},
+		{"html inside blocks", templates.EscapeHTML, `{{ if true }}{{ with .Details }}{{ .Application }}{{ end }}{{ end }}`, "&lt;b&gt;_app_&lt;/b&gt;"},
+		{"html isn't applied twice", templates.EscapeHTML, `{{ .Details.Application | html }}`, "&lt;b&gt;_app_&lt;/b&gt;"},
+		{"markdown", templates.EscapeMarkdown, `**{{ .Details.Application }}**`, `**\<b\>\_app\_\</b\>**`},
+		{"markdown in defined templates", templates.EscapeMarkdown, `{{ define "app" }}{{ .Application }}{{ end }}{{ template "app" .Details }}`, `\<b\>\_app\_\</b\>`},
+		{"markdown helpers aren't escaped", templates.EscapeMarkdown, `{{ markdownLink .Details.Type "https://x" }}`, `[orca:stage:complete](https://x)`},
+		{"json", templates.EscapeJSON, `{"app": "{{ .Details.Application }}\"{{ "\n" }}"}`, `{"app": "<b>_app_</b>\"\n"}`},
+		{"json values aren't escaped", templates.EscapeJSON, `{"app": {{ json .Details.Application }}}`, `{"app": "\u003cb\u003e_app_\u003c/b\u003e"}`},
+		{"assignments", templates.EscapeMarkdown, `{{ $app := .Details.Application }}{{ $app }}`, `\<b\>\_app\_\</b\>`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			tmpl, err := template.New("test").Funcs(templates.Funcs("")).Parse(test.template)
+			require.NoError(t, err)
+			require.NoError(t, templates.Escape(tmpl, test.mode))
+
+			buf := new(bytes.Buffer)
+			require.NoError(t, tmpl.Execute(buf, incoming))
+			assert.Equal(t, test.expected, buf.String())
+		})
+	}
+
+	t.Run("Unknown modes are an error", func(t *testing.T) {
+		tmpl := template.Must(template.New("test").Parse(`{{ . }}`))
+		require.Error(t, templates.Escape(tmpl, "shell"))
+	})
+}
+
+func TestDeckLinks(t *testing.T) {
+	incoming := &types.IncomingWebhook{
+		Details: types.Details{Application: "payments-api", Type: "orca:stage:complete"},
+		Content: types.Content{
+			ExecutionID: "01EXEC",
+			Name:        "Deploy",
+			Execution: &types.Execution{
+				Stages: []types.Stage{{Name: "Bake"}, {Name: "Deploy"}},
+			},
+		},
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{`{{ applicationURL . }}`, "https://spinnaker.example.com/#/applications/payments-api"},
+		{`{{ executionURL . }}`, "https://spinnaker.example.com/#/applications/payments-api/executions/details/01EXEC"},
+		{`{{ stageURL . }}`, "https://spinnaker.example.com/#/applications/payments-api/executions/details/01EXEC?stage=1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.template, func(t *testing.T) {
+			tmpl, err := template.New("test").Funcs(templates.Funcs("https://spinnaker.example.com/")).Parse(test.template)
+			require.NoError(t, err)
+
+			buf := new(bytes.Buffer)
+			require.NoError(t, tmpl.Execute(buf, incoming))
+			assert.Equal(t, test.expected, buf.String())
+		})
+	}
+
+	t.Run("Without a deck url links are empty", func(t *testing.T) {
+		tmpl := template.Must(template.New("test").Funcs(templates.Funcs("")).Parse(`{{ executionURL . }}`))
+		buf := new(bytes.Buffer)
+		require.NoError(t, tmpl.Execute(buf, incoming))
+		assert.Equal(t, "", buf.String())
+	})
+}
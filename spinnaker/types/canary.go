@@ -0,0 +1,148 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// The types of Kayenta canary analysis stages
+const (
+	CanaryStageType = "kayentaCanary"
+	// RunCanaryStageType is the type of the synthetic stages a kayentaCanary
+	// stage runs each analysis interval in
+	RunCanaryStageType = "runCanary"
+)
+
+// CanaryContext is the context of kayentaCanary stages. Orca appends the score
+// of every analysis interval to CanaryScores, the last one is the overall
+// score. The classification of each metric isn't in the context, Kayenta
+// keeps it with the canary execution of the interval (see CanaryExecutionID).
+type CanaryContext struct {
+	CanaryConfig       CanaryConfig `json:"canaryConfig"`
+	CanaryScores       []Score      `json:"canaryScores,omitempty"`
+	CanaryScoreMessage string       `json:"canaryScoreMessage,omitempty"`
+}
+
+// CanaryConfig is how the stage runs the analysis, CanaryConfigName is only
+// set when the pipeline names the canary config
+type CanaryConfig struct {
+	CanaryConfigID   string          `json:"canaryConfigId"`
+	CanaryConfigName string          `json:"canaryConfigName,omitempty"`
+	ScoreThresholds  ScoreThresholds `json:"scoreThresholds"`
+}
+
+// Name returns the name of the canary config, or its id when it isn't named
+func (cc CanaryConfig) Name() string {
+	if cc.CanaryConfigName != "" {
+		return cc.CanaryConfigName
+	}
+
+	return cc.CanaryConfigID
+}
+
+// ScoreThresholds classify scores: an interval scoring at or below Marginal
+// fails the analysis early and the overall score must reach Pass
+type ScoreThresholds struct {
+	Marginal Score `json:"marginal"`
+	Pass     Score `json:"pass"`
+}
+
+// OverallScore returns the score of the last interval, it is false when no
+// interval score was recorded
+func (cc *CanaryContext) OverallScore() (float64, bool) {
+	if len(cc.CanaryScores) == 0 {
+		return 0, false
+	}
+
+	return float64(cc.CanaryScores[len(cc.CanaryScores)-1]), true
+}
+
+// CanaryExecutionID returns the id of the Kayenta canary execution of the
+// last interval of the named kayentaCanary stage. Orca stores it as
+// canaryPipelineExecutionId in the context of the runCanary stage of each
+// interval. It is empty when the execution doesn't have it.
+func (e *Execution) CanaryExecutionID(stageName string) string {
+	parents := make(map[string]bool)
+	for _, stage := range e.Stages {
+		if stage.Type == CanaryStageType && stage.Name == stageName {
+			parents[stage.ID] = true
+		}
+	}
+
+	var id string
+	for _, stage := range e.Stages {
+		if stage.Type != RunCanaryStageType || !parents[stage.ParentStageID] {
+			continue
+		}
+		if executionID, ok := stage.Context["canaryPipelineExecutionId"].(string); ok && executionID != "" {
+			id = executionID
+		}
+	}
+
+	return id
+}
+
+// CanaryJudgeResult is Kayenta's judgment of a canary execution, it is the
+// result.judgeResult of GET /canary/{canaryExecutionId}
+type CanaryJudgeResult struct {
+	JudgeName   string               `json:"judgeName,omitempty"`
+	Score       CanaryScore          `json:"score"`
+	GroupScores []CanaryGroupScore   `json:"groupScores,omitempty"`
+	Results     []CanaryMetricResult `json:"results,omitempty"`
+}
+
+// CanaryScore is the score of the whole analysis
+type CanaryScore struct {
+	Score                Score  `json:"score"`
+	Classification       string `json:"classification"`
+	ClassificationReason string `json:"classificationReason,omitempty"`
+}
+
+// CanaryGroupScore is the score of a group of metrics
+type CanaryGroupScore struct {
+	Name                 string `json:"name"`
+	Score                Score  `json:"score"`
+	Classification       string `json:"classification"`
+	ClassificationReason string `json:"classificationReason,omitempty"`
+}
+
+// CanaryMetricResult is the classification of a single metric of the
+// experiment compared to the control: Pass, High, Low or Nodata
+type CanaryMetricResult struct {
+	Name                 string   `json:"name"`
+	Classification       string   `json:"classification"`
+	ClassificationReason string   `json:"classificationReason,omitempty"`
+	Groups               []string `json:"groups,omitempty"`
+}
+
+// Score is a canary score or threshold. Deck saves thresholds as strings so
+// numeric strings are accepted as well as numbers.
+type Score float64
+
+// UnmarshalJSON implements json.Unmarshaler
+func (s *Score) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	if len(b) > 0 && b[0] == '"' {
+		var str string
+		if err := json.Unmarshal(b, &str); err != nil {
+			return errors.Wrap(err, "could not decode score string")
+		}
+		b = []byte(str)
+	}
+
+	if len(b) == 0 || string(b) == "null" {
+		*s = 0
+		return nil
+	}
+
+	f, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		return errors.Errorf("score %q is not a number", b)
+	}
+	*s = Score(f)
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package types_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+)
+
+func TestCanaryContext(t *testing.T) {
+	wd, _ := os.Getwd()
+	b, err := ioutil.ReadFile(filepath.Join(wd, "..", "testdata", "kayenta-canary.json"))
+	require.NoError(t, err)
+
+	var incoming types.IncomingWebhook
+	require.NoError(t, json.Unmarshal(b, &incoming))
+
+	payload, err := incoming.Payload()
+	require.NoError(t, err)
+	stage := payload.(*types.StageContent)
+	require.Equal(t, types.CanaryStageType, stage.Context.StageDetails().Type)
+
+	var canary types.CanaryContext
+	require.NoError(t, stage.Context.Decode(&canary))
+
+	assert.Equal(t, "hcm-web", canary.CanaryConfig.Name())
+	assert.Equal(t, types.ScoreThresholds{Marginal: 75, Pass: 95}, canary.CanaryConfig.ScoreThresholds)
+	assert.Equal(t, []types.Score{96.5, 66.67}, canary.CanaryScores)
+
+	score, ok := canary.OverallScore()
+	assert.True(t, ok)
+	assert.Equal(t, 66.67, score)
+
+	t.Run("The canary execution is the one of the last interval", func(t *testing.T) {
+		assert.Equal(t, "01C5ZB3H4J5K6M7N8P9Q0R1S2T", stage.Execution.CanaryExecutionID("Canary Analysis"))
+		assert.Empty(t, stage.Execution.CanaryExecutionID("Deploy canary"))
+	})
+
+	t.Run("Unnamed configs use their id", func(t *testing.T) {
+		assert.Equal(t, "someid", types.CanaryConfig{CanaryConfigID: "someid"}.Name())
+	})
+
+	t.Run("Contexts without scores have no overall score", func(t *testing.T) {
+		_, ok := (&types.CanaryContext{}).OverallScore()
+		assert.False(t, ok)
+	})
+}
+
+func TestScoreUnmarshalling(t *testing.T) {
+	tests := map[string]types.Score{
+		`95`:     95,
+		`"75.5"`: 75.5,
+		`""`:     0,
+		`null`:   0,
+	}
+
+	for input, expected := range tests {
+		var score types.Score
+		require.NoError(t, json.Unmarshal([]byte(input), &score), input)
+		assert.Equal(t, expected, score, input)
+	}
+
+	var score types.Score
+	assert.Error(t, json.Unmarshal([]byte(`"high"`), &score))
+}
@@ -0,0 +1,257 @@
+package types
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Hook types sent by echo. Orca sends pipeline, stage and task events, igor
+// sends build events and echo itself sends git events and manual judgment
+// notifications.
+const (
+	HookTypePipelineStarting = "orca:pipeline:starting"
+	HookTypePipelineComplete = "orca:pipeline:complete"
+	HookTypePipelineFailed   = "orca:pipeline:failed"
+
+	HookTypeStageStarting = "orca:stage:starting"
+	HookTypeStageComplete = "orca:stage:complete"
+	HookTypeStageFailed   = "orca:stage:failed"
+
+	HookTypeTaskStarting = "orca:task:starting"
+	HookTypeTaskComplete = "orca:task:complete"
+	HookTypeTaskFailed   = "orca:task:failed"
+
+	HookTypeBuild = "build"
+	HookTypeGit   = "git"
+
+	HookTypeManualJudgment         = "manualJudgment"
+	HookTypeManualJudgmentContinue = "manualJudgmentContinue"
+	HookTypeManualJudgmentStop     = "manualJudgmentStop"
+)
+
+// HookTypes are all of the hook types with a typed payload
+var HookTypes = []string{
+	HookTypePipelineStarting, HookTypePipelineComplete, HookTypePipelineFailed,
+	HookTypeStageStarting, HookTypeStageComplete, HookTypeStageFailed,
+	HookTypeTaskStarting, HookTypeTaskComplete, HookTypeTaskFailed,
+	HookTypeBuild, HookTypeGit,
+	HookTypeManualJudgment, HookTypeManualJudgmentContinue, HookTypeManualJudgmentStop,
+}
+
+// The families of hook types, each family shares a payload struct
+const (
+	FamilyPipeline       = "pipeline"
+	FamilyStage          = "stage"
+	FamilyTask           = "task"
+	FamilyBuild          = "build"
+	FamilyGit            = "git"
+	FamilyManualJudgment = "manualJudgment"
+)
+
+// Family returns the family of the hook type, or an empty string for hook
+// types without a typed payload
+func Family(hookType string) string {
+	switch {
+	case strings.HasPrefix(hookType, "orca:pipeline:"):
+		return FamilyPipeline
+	case strings.HasPrefix(hookType, "orca:stage:"):
+		return FamilyStage
+	case strings.HasPrefix(hookType, "orca:task:"):
+		return FamilyTask
+	case hookType == HookTypeBuild:
+		return FamilyBuild
+	case hookType == HookTypeGit:
+		return FamilyGit
+	case strings.HasPrefix(hookType, HookTypeManualJudgment):
+		return FamilyManualJudgment
+	}
+
+	return ""
+}
+
+// PipelineContent is the content of orca:pipeline:* webhooks
+type PipelineContent struct {
+	ExecutionID string     `json:"executionId"`
+	StartTime   Timestamp  `json:"startTime"`
+	EndTime     Timestamp  `json:"endTime"`
+	Execution   *Execution `json:"execution,omitempty"`
+}
+
+// StageContent is the content of orca:stage:* webhooks. Orca names the stage
+// in the stage details of the context (see StageName), Name is only set by
+// webhooks naming it at the top of the content.
+type StageContent struct {
+	ExecutionID string       `json:"executionId"`
+	Name        string       `json:"name,omitempty"`
+	StartTime   Timestamp    `json:"startTime"`
+	EndTime     Timestamp    `json:"endTime"`
+	Standalone  bool         `json:"standalone,omitempty"`
+	Canceled    bool         `json:"canceled,omitempty"`
+	Context     StageContext `json:"context,omitempty"`
+	Execution   *Execution   `json:"execution,omitempty"`
+}
+
+// TaskContent is the content of orca:task:* webhooks, StageName is the name of
+// the stage the task belongs to
+type TaskContent struct {
+	StageContent
+	TaskName string `json:"taskName,omitempty"`
+}
+
+// StageName returns the name of the stage from its stage details, Name is used
+// when the context doesn't have it
+func (sc *StageContent) StageName() string {
+	if name := sc.Context.StageDetails().Name; name != "" {
+		return name
+	}
+
+	return sc.Name
+}
+
+// StageContext is the context of a stage, its keys depend on the stage type
+type StageContext map[string]interface{}
+
+// StageDetails describes the stage of stage and task webhooks
+type StageDetails struct {
+	Name        string    `json:"name"`
+	Type        string    `json:"type"`
+	StartTime   Timestamp `json:"startTime"`
+	EndTime     Timestamp `json:"endTime"`
+	IsSynthetic bool      `json:"isSynthetic,omitempty"`
+}
+
+// StageDetails returns the details orca adds to the context of the stage
+func (sc StageContext) StageDetails() StageDetails {
+	var details StageDetails
+	sc.Decode(&struct {
+		StageDetails *StageDetails `json:"stageDetails"`
+	}{&details})
+
+	return details
+}
+
+// Decode unmarshals the context into v, for stage types with a known context
+func (sc StageContext) Decode(v interface{}) error {
+	b, err := json.Marshal(sc)
+	if err != nil {
+		return errors.Wrap(err, "could not encode stage context")
+	}
+
+	return errors.Wrap(json.Unmarshal(b, v), "could not decode stage context")
+}
+
+// BuildContent is the content of build webhooks sent by igor for CI builds
+// (Jenkins, Travis...). Master is the name of the CI server.
+type BuildContent struct {
+	Master  string       `json:"master"`
+	Project BuildProject `json:"project"`
+}
+
+// BuildProject is the CI job a build belongs to
+type BuildProject struct {
+	Name      string `json:"name"`
+	LastBuild Build  `json:"lastBuild"`
+}
+
+// Build is a single CI build
+type Build struct {
+	Number         int           `json:"number"`
+	DisplayName    string        `json:"fullDisplayName,omitempty"`
+	Result         string        `json:"result"`
+	Building       bool          `json:"building"`
+	Timestamp      Timestamp     `json:"timestamp"`
+	DurationMillis int64         `json:"duration"`
+	URL            string        `json:"url"`
+	GitRevisions   []GitRevision `json:"genericGitRevisions,omitempty"`
+	Artifacts      []Artifact    `json:"artifacts,omitempty"`
+}
+
+// GitRevision is a commit a build was made from
+type GitRevision struct {
+	Name      string `json:"name,omitempty"`
+	Branch    string `json:"branch"`
+	SHA1      string `json:"sha1"`
+	Committer string `json:"committer,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// Artifact is a file produced by a build
+type Artifact struct {
+	FileName     string `json:"fileName"`
+	RelativePath string `json:"relativePath,omitempty"`
+}
+
+// GitContent is the content of git webhooks sent for pushes to repositories
+// (GitHub, Bitbucket, GitLab...). The provider is in Details.Source.
+type GitContent struct {
+	RepoProject string `json:"repoProject"`
+	Slug        string `json:"slug"`
+	Hash        string `json:"hash"`
+	Branch      string `json:"branch"`
+	Action      string `json:"action,omitempty"`
+}
+
+// ManualJudgmentContent is the content of manual judgment notifications. They
+// are sent when a manual judgment stage starts waiting (manualJudgment) and
+// when it is judged (manualJudgmentContinue or manualJudgmentStop).
+type ManualJudgmentContent struct {
+	ExecutionID    string          `json:"executionId"`
+	StageID        string          `json:"stageId"`
+	StageName      string          `json:"stageName"`
+	Instructions   string          `json:"instructions,omitempty"`
+	JudgmentInputs []JudgmentInput `json:"judgmentInputs,omitempty"`
+	// SelectedStageRoles are the roles allowed to judge the stage
+	SelectedStageRoles []string `json:"selectedStageRoles,omitempty"`
+	// JudgmentInput and LastModifiedBy are the input chosen and the user who
+	// judged the stage
+	JudgmentInput  string     `json:"judgmentInput,omitempty"`
+	LastModifiedBy string     `json:"lastModifiedBy,omitempty"`
+	Execution      *Execution `json:"execution,omitempty"`
+}
+
+// JudgmentInput is an option offered to the judge of a manual judgment
+type JudgmentInput struct {
+	Value string `json:"value"`
+}
+
+// NewPayload returns an empty payload struct for the hook type, or nil for
+// hook types without a typed payload
+func NewPayload(hookType string) interface{} {
+	switch Family(hookType) {
+	case FamilyPipeline:
+		return &PipelineContent{}
+	case FamilyStage:
+		return &StageContent{}
+	case FamilyTask:
+		return &TaskContent{}
+	case FamilyBuild:
+		return &BuildContent{}
+	case FamilyGit:
+		return &GitContent{}
+	case FamilyManualJudgment:
+		return &ManualJudgmentContent{}
+	}
+
+	return nil
+}
+
+// DecodePayload decodes the content of a webhook into the payload struct of
+// the hook type. Hook types without a typed payload decode to a map.
+func DecodePayload(hookType string, content []byte) (interface{}, error) {
+	payload := NewPayload(hookType)
+	if payload == nil {
+		payload = &map[string]interface{}{}
+	}
+
+	if len(content) == 0 {
+		return payload, nil
+	}
+
+	if err := json.Unmarshal(content, payload); err != nil {
+		return nil, errors.Wrapf(err, "could not decode %s content", hookType)
+	}
+
+	return payload, nil
+}
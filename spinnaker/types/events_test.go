@@ -0,0 +1,135 @@
+package types_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+)
+
+// loadEvent decodes the fixture of the hook type
+func loadEvent(t *testing.T, hookType string) *types.IncomingWebhook {
+	wd, _ := os.Getwd()
+	name := strings.Replace(hookType, ":", "-", -1) + ".json"
+	b, err := ioutil.ReadFile(filepath.Join(wd, "..", "testdata", "events", name))
+	require.NoError(t, err)
+
+	var incoming types.IncomingWebhook
+	require.NoError(t, json.Unmarshal(b, &incoming))
+	require.Equal(t, hookType, incoming.Details.Type)
+
+	return &incoming
+}
+
+func TestEventPayloads(t *testing.T) {
+	tests := map[string]func(t *testing.T, payload interface{}){
+		types.FamilyPipeline: func(t *testing.T, payload interface{}) {
+			pipeline := payload.(*types.PipelineContent)
+			assert.Equal(t, "01C5Z6VQ1E6QG1F2Y3X4W5V6T7", pipeline.ExecutionID)
+			assert.Equal(t, "Deploy to production", pipeline.Execution.Name)
+		},
+		types.FamilyStage: func(t *testing.T, payload interface{}) {
+			stage := payload.(*types.StageContent)
+			assert.Empty(t, stage.Name, "orca doesn't send content.name")
+			assert.Equal(t, "Deploy", stage.StageName())
+			assert.Equal(t, "deployManifest", stage.Context.StageDetails().Type)
+			assert.Equal(t, "prod-k8s", stage.Context["account"])
+		},
+		types.FamilyTask: func(t *testing.T, payload interface{}) {
+			task := payload.(*types.TaskContent)
+			assert.Equal(t, "waitForManifestStable", task.TaskName)
+			assert.Equal(t, "Deploy", task.StageName())
+		},
+		types.FamilyBuild: func(t *testing.T, payload interface{}) {
+			build := payload.(*types.BuildContent)
+			assert.Equal(t, "ci-jenkins", build.Master)
+			assert.Equal(t, "hcm-web-build", build.Project.Name)
+			assert.Equal(t, 412, build.Project.LastBuild.Number)
+			assert.Equal(t, "SUCCESS", build.Project.LastBuild.Result)
+			assert.Equal(t, int64(1518213900000), build.Project.LastBuild.Timestamp.Millis())
+			assert.Equal(t, "master", build.Project.LastBuild.GitRevisions[0].Branch)
+		},
+		types.FamilyGit: func(t *testing.T, payload interface{}) {
+			git := payload.(*types.GitContent)
+			assert.Equal(t, "hcm-web", git.Slug)
+			assert.Equal(t, "master", git.Branch)
+			assert.Len(t, git.Hash, 40)
+		},
+		types.FamilyManualJudgment: func(t *testing.T, payload interface{}) {
+			judgment := payload.(*types.ManualJudgmentContent)
+			assert.Equal(t, "Promote to production?", judgment.StageName)
+			assert.Equal(t, []types.JudgmentInput{{Value: "promote"}, {Value: "rollback"}}, judgment.JudgmentInputs)
+			assert.Equal(t, []string{"release-managers"}, judgment.SelectedStageRoles)
+		},
+	}
+
+	for _, hookType := range types.HookTypes {
+		t.Run(hookType, func(t *testing.T) {
+			incoming := loadEvent(t, hookType)
+
+			family := types.Family(hookType)
+			require.Contains(t, tests, family)
+
+			payload, err := incoming.Payload()
+			require.NoError(t, err)
+			require.IsType(t, types.NewPayload(hookType), payload)
+			tests[family](t, payload)
+		})
+	}
+}
+
+func TestStageContentStageName(t *testing.T) {
+	stage := &types.StageContent{Name: "Bake"}
+	assert.Equal(t, "Bake", stage.StageName(), "stages without details use the content name")
+
+	stage.Context = types.StageContext{"stageDetails": map[string]interface{}{"name": "Bake (Manifest)"}}
+	assert.Equal(t, "Bake (Manifest)", stage.StageName())
+}
+
+func TestManualJudgmentOutcomes(t *testing.T) {
+	for hookType, input := range map[string]string{
+		types.HookTypeManualJudgmentContinue: "promote",
+		types.HookTypeManualJudgmentStop:     "rollback",
+	} {
+		payload, err := loadEvent(t, hookType).Payload()
+		require.NoError(t, err)
+
+		judgment := payload.(*types.ManualJudgmentContent)
+		assert.Equal(t, input, judgment.JudgmentInput)
+		assert.Equal(t, "jane@example.com", judgment.LastModifiedBy)
+	}
+}
+
+func TestIncomingWebhookStaysCompatible(t *testing.T) {
+	incoming := loadEvent(t, types.HookTypeStageFailed)
+	assert.Equal(t, "01C5Z6VQ1E6QG1F2Y3X4W5V6T7", incoming.Content.ExecutionID)
+	assert.Equal(t, "Deploy", incoming.StageName())
+	assert.Equal(t, "Deploy to production", incoming.PipelineName())
+
+	t.Run("Webhooks built in code decode their common content", func(t *testing.T) {
+		incoming := &types.IncomingWebhook{
+			Details: types.Details{Type: types.HookTypePipelineComplete},
+			Content: types.Content{ExecutionID: "someid"},
+		}
+
+		payload, err := incoming.Payload()
+		require.NoError(t, err)
+		assert.Equal(t, "someid", payload.(*types.PipelineContent).ExecutionID)
+	})
+
+	t.Run("Unknown hook types decode to a map", func(t *testing.T) {
+		var incoming types.IncomingWebhook
+		require.NoError(t, json.Unmarshal([]byte(`{"details": {"type": "custom"}, "content": {"key": "value"}}`), &incoming))
+
+		payload, err := incoming.Payload()
+		require.NoError(t, err)
+		assert.Equal(t, &map[string]interface{}{"key": "value"}, payload)
+	})
+}
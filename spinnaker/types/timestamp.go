@@ -1,37 +1,90 @@
 package types
 
 import (
-	"fmt"
+	"bytes"
+	"encoding/json"
+	"math"
 	"strconv"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
-// Timestamp implements JSON marshalling / unmarshalling to allow
-// using it for unix timestamp values in struct values
+// Timestamp implements JSON marshalling / unmarshalling to allow using it
+// for Spinnaker's epoch millisecond values in struct values. It accepts
+// numbers, numeric strings and RFC3339 strings and always marshals back to
+// epoch milliseconds (or null when it is unset) so values round-trip.
 type Timestamp struct {
 	time.Time
 }
 
+// NewTimestamp returns the timestamp for the given epoch milliseconds
+func NewTimestamp(ms int64) Timestamp {
+	return Timestamp{Time: time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond))}
+}
+
+// Millis returns the timestamp as epoch milliseconds
+func (t Timestamp) Millis() int64 {
+	return t.Time.Unix()*1000 + int64(t.Time.Nanosecond())/int64(time.Millisecond)
+}
+
 // MarshalJSON implements json.Marshaler
-func (t *Timestamp) MarshalJSON() ([]byte, error) {
-	ts := t.Time.Unix()
-	stamp := fmt.Sprint(ts)
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	if t.Time.IsZero() {
+		return []byte("null"), nil
+	}
 
-	return []byte(stamp), nil
+	return []byte(strconv.FormatInt(t.Millis(), 10)), nil
 }
 
 // UnmarshalJSON implements json.Unmarshaler
 func (t *Timestamp) UnmarshalJSON(b []byte) error {
-	if string(b) == "null" {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 || string(b) == "null" {
+		t.Time = time.Time{}
 		return nil
 	}
 
-	ts, err := strconv.Atoi(string(b))
+	if b[0] != '"' {
+		return t.parseNumber(string(b))
+	}
+
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.Wrap(err, "could not decode timestamp string")
+	}
+
+	if s == "" {
+		t.Time = time.Time{}
+		return nil
+	}
+
+	if err := t.parseNumber(s); err == nil {
+		return nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339Nano, s)
 	if err != nil {
-		return err
+		return errors.Errorf("timestamp %q is neither epoch milliseconds nor RFC3339", s)
 	}
+	t.Time = parsed
 
-	t.Time = time.Unix(int64(ts)/1000, 0)
+	return nil
+}
+
+// parseNumber parses epoch milliseconds, allowing exponent notation for
+// producers that encode them as floating point numbers
+func (t *Timestamp) parseNumber(s string) error {
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		*t = NewTimestamp(ms)
+		return nil
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) || f >= math.MaxInt64 || f < math.MinInt64 {
+		return errors.Errorf("timestamp %q is not epoch milliseconds", s)
+	}
 
+	*t = NewTimestamp(int64(f))
 	return nil
 }
@@ -0,0 +1,149 @@
+package types_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+)
+
+// zeroTimeMillis is the zero time.Time in epoch milliseconds, it marshals to
+// null because it means the timestamp is unset
+const zeroTimeMillis = -62135596800000
+
+func TestTimestampUnmarshal(t *testing.T) {
+	expected := time.Date(2018, 2, 9, 22, 6, 43, 433*int(time.Millisecond), time.UTC)
+
+	tests := []struct {
+		scenario string
+		json     string
+		expected time.Time
+		wantErr  bool
+	}{
+		{"epoch milliseconds", `1518214003433`, expected, false},
+		{"epoch milliseconds in exponent notation", `1.518214003433e12`, expected, false},
+		{"quoted epoch milliseconds", `"1518214003433"`, expected, false},
+		{"RFC3339", `"2018-02-09T22:06:43.433Z"`, expected, false},
+		{"RFC3339 with an offset", `"2018-02-09T17:06:43.433-05:00"`, expected, false},
+		{"null", `null`, time.Time{}, false},
+		{"an empty string", `""`, time.Time{}, false},
+		{"garbage", `"yesterday"`, time.Time{}, true},
+		{"a boolean", `true`, time.Time{}, true},
+		{"an infinite number", `1e400`, time.Time{}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			var ts types.Timestamp
+			err := json.Unmarshal([]byte(test.json), &ts)
+			if test.wantErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.True(t, test.expected.Equal(ts.Time), "expected %s, got %s", test.expected, ts.Time)
+		})
+	}
+}
+
+func TestTimestampMarshal(t *testing.T) {
+	b, err := json.Marshal(types.NewTimestamp(1518214003433))
+	require.NoError(t, err)
+	assert.Equal(t, "1518214003433", string(b))
+
+	b, err = json.Marshal(types.Timestamp{})
+	require.NoError(t, err)
+	assert.Equal(t, "null", string(b))
+
+	b, err = json.Marshal(struct {
+		At *types.Timestamp `json:"at"`
+	}{At: &types.Timestamp{Time: time.Unix(1, 5*int64(time.Millisecond))}})
+	require.NoError(t, err)
+	assert.Equal(t, `{"at":1005}`, string(b))
+}
+
+func TestTimestampRoundTrips(t *testing.T) {
+	roundTrip := func(ms int64) bool {
+		if ms == zeroTimeMillis {
+			return true
+		}
+
+		b, err := json.Marshal(types.NewTimestamp(ms))
+		if err != nil || string(b) != strconv.FormatInt(ms, 10) {
+			return false
+		}
+
+		var ts types.Timestamp
+		if err := json.Unmarshal(b, &ts); err != nil {
+			return false
+		}
+
+		return ts.Millis() == ms
+	}
+
+	require.NoError(t, quick.Check(roundTrip, &quick.Config{MaxCount: 10000}))
+
+	for _, ms := range []int64{0, 1, -1, 999, -999, 1518214003433} {
+		assert.True(t, roundTrip(ms), "%d should round trip", ms)
+	}
+}
+
+func TestWebhookTimestamps(t *testing.T) {
+	wd, _ := os.Getwd()
+	b, err := ioutil.ReadFile(filepath.Join(wd, "..", "testdata", "valid-webhook.json"))
+	require.NoError(t, err)
+
+	var incoming types.IncomingWebhook
+	require.NoError(t, json.Unmarshal(b, &incoming))
+	assert.Equal(t, int64(1518214003433), incoming.Details.Created.Millis())
+	assert.Equal(t, int64(1518214003091), incoming.Content.StartTime.Millis())
+	assert.Equal(t, int64(1518214003420), incoming.Content.EndTime.Millis())
+	assert.Equal(t, 329*time.Millisecond, incoming.Duration())
+
+	out, err := json.Marshal(&incoming)
+	require.NoError(t, err)
+
+	var again types.IncomingWebhook
+	require.NoError(t, json.Unmarshal(out, &again))
+	assert.Equal(t, incoming.Details.Created.Millis(), again.Details.Created.Millis())
+	assert.Equal(t, incoming.Content.StartTime.Millis(), again.Content.StartTime.Millis())
+}
+
+func FuzzTimestampUnmarshal(f *testing.F) {
+	for _, seed := range []string{`1518214003433`, `"1518214003433"`, `"2018-02-09T22:06:43.433Z"`, `null`, `""`, `1.5e12`, `-1`} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		var ts types.Timestamp
+		if err := json.Unmarshal([]byte(input), &ts); err != nil {
+			return
+		}
+
+		// Anything that decodes must re-encode to epoch milliseconds (or null)
+		// that decodes back to the same instant at millisecond precision
+		b, err := json.Marshal(ts)
+		if err != nil {
+			t.Fatalf("could not marshal %q: %s", input, err)
+		}
+
+		var again types.Timestamp
+		if err := json.Unmarshal(b, &again); err != nil {
+			t.Fatalf("could not unmarshal %s (from %q): %s", b, input, err)
+		}
+
+		if ts.IsZero() != again.IsZero() || (!ts.IsZero() && again.Millis() != ts.Millis()) {
+			t.Fatalf("%q decoded to %d but round tripped to %d", input, ts.Millis(), again.Millis())
+		}
+	})
+}
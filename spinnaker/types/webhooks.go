@@ -1,19 +1,66 @@
 package types
 
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
 // IncomingWebhook is a structure representing a Spinnaker echo rest Webhook
 // You can view an example of the schema here:
 // https://www.spinnaker.io/setup/features/notifications/#event-types
 type IncomingWebhook struct {
 	Details Details `json:"details"`
 	Content Content `json:"content"`
+
+	// rawContent is the content as it was received, it is decoded into the
+	// payload struct of the hook type by Payload
+	rawContent json.RawMessage
+}
+
+// UnmarshalJSON implements json.Unmarshaler, it keeps the raw content around
+// for Payload
+func (iw *IncomingWebhook) UnmarshalJSON(b []byte) error {
+	type webhook IncomingWebhook
+	if err := json.Unmarshal(b, (*webhook)(iw)); err != nil {
+		return err
+	}
+
+	var raw struct {
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	iw.rawContent = raw.Content
+
+	return nil
+}
+
+// Payload decodes the content into the payload struct of the hook type, for
+// example a *PipelineContent for orca:pipeline:complete or a *BuildContent for
+// build. Hook types without a typed payload decode to a *map[string]interface{}.
+func (iw *IncomingWebhook) Payload() (interface{}, error) {
+	content := []byte(iw.rawContent)
+	if content == nil {
+		// Webhooks built in code only have the common content
+		var err error
+		if content, err = json.Marshal(iw.Content); err != nil {
+			return nil, err
+		}
+	}
+
+	return DecodePayload(iw.Details.Type, content)
 }
 
 // Details contains all of the details contained in the webhook
 type Details struct {
-	Source      string `json:"source"`
-	Type        string `json:"type"`
-	Application string `json:"application"`
-	Created     string `json:"created"`
+	Source      string    `json:"source"`
+	Type        string    `json:"type"`
+	Application string    `json:"application"`
+	Created     Timestamp `json:"created"`
 }
 
 // Content is the main context of the given Webhook. It contains of the execution
@@ -22,4 +69,198 @@ type Content struct {
 	ExecutionID string    `json:"executionId"`
 	StartTime   Timestamp `json:"startTime"`
 	EndTime     Timestamp `json:"endTime"`
+	// Name is only sent by some producers, orca sends the name of the stage
+	// in context.stageDetails.name. Use StageName to read either.
+	Name       string                 `json:"name,omitempty"`
+	Standalone bool                   `json:"standalone,omitempty"`
+	Canceled   bool                   `json:"canceled,omitempty"`
+	Context    map[string]interface{} `json:"context,omitempty"`
+	Execution  *Execution             `json:"execution,omitempty"`
+}
+
+// Execution is the pipeline (or orchestration) execution a webhook belongs to
+type Execution struct {
+	ID               string    `json:"id"`
+	Type             string    `json:"type"`
+	Name             string    `json:"name"`
+	Application      string    `json:"application"`
+	Status           string    `json:"status"`
+	PipelineConfigID string    `json:"pipelineConfigId,omitempty"`
+	StartTime        Timestamp `json:"startTime"`
+	EndTime          Timestamp `json:"endTime"`
+	Stages           []Stage   `json:"stages,omitempty"`
+}
+
+// ExecutionTypeOrchestration is the type of the executions of ad-hoc tasks
+// (such as a resize started from Deck), as opposed to "PIPELINE"
+const ExecutionTypeOrchestration = "ORCHESTRATION"
+
+// IsOrchestration returns true for executions of ad-hoc tasks, Deck lists
+// them under the tasks of the application rather than its pipelines
+func (e *Execution) IsOrchestration() bool {
+	return strings.EqualFold(e.Type, ExecutionTypeOrchestration)
+}
+
+// Stage is a single stage of a pipeline execution
+type Stage struct {
+	ID        string                 `json:"id"`
+	RefID     string                 `json:"refId,omitempty"`
+	Type      string                 `json:"type"`
+	Name      string                 `json:"name"`
+	Status    string                 `json:"status"`
+	StartTime Timestamp              `json:"startTime"`
+	EndTime   Timestamp              `json:"endTime"`
+	Context   map[string]interface{} `json:"context,omitempty"`
+	// ParentStageID is set on synthetic stages (such as the ones a deploy
+	// runs before and after itself), it is empty for top level stages
+	ParentStageID string `json:"parentStageId,omitempty"`
+}
+
+// The phases a hook type can end in, for example "orca:stage:complete"
+const (
+	PhaseStarting = "starting"
+	PhaseComplete = "complete"
+	PhaseFailed   = "failed"
+)
+
+// Phase returns the last segment of the hook type ("starting", "complete" or
+// "failed" for orca events)
+func (iw *IncomingWebhook) Phase() string {
+	parts := strings.Split(iw.Details.Type, ":")
+	return parts[len(parts)-1]
+}
+
+// PipelineName returns the name of the pipeline the webhook belongs to, if
+// the payload contains the execution
+func (iw *IncomingWebhook) PipelineName() string {
+	if iw.Content.Execution == nil {
+		return ""
+	}
+
+	return iw.Content.Execution.Name
+}
+
+// StageName returns the name of the stage for stage and task events. Orca
+// sends it in the stage details of the context, content.name is used when
+// the context doesn't have it.
+func (iw *IncomingWebhook) StageName() string {
+	if Family(iw.Details.Type) == FamilyPipeline {
+		return ""
+	}
+
+	if details, ok := iw.Content.Context["stageDetails"].(map[string]interface{}); ok {
+		if name, ok := details["name"].(string); ok && name != "" {
+			return name
+		}
+	}
+
+	return iw.Content.Name
+}
+
+// Duration returns how long the stage (or pipeline) took, zero if it has not
+// finished
+func (iw *IncomingWebhook) Duration() time.Duration {
+	start, end := iw.Content.StartTime.Time, iw.Content.EndTime.Time
+	if start.IsZero() && iw.Content.Execution != nil {
+		start, end = iw.Content.Execution.StartTime.Time, iw.Content.Execution.EndTime.Time
+	}
+
+	if start.IsZero() || end.IsZero() || end.Before(start) {
+		return 0
+	}
+
+	return end.Sub(start)
+}
+
+// ApplicationURL returns the link to the application in Deck (Spinnaker's UI)
+// hosted at the given base URL
+func (iw *IncomingWebhook) ApplicationURL(deckURL string) string {
+	if deckURL == "" || iw.Details.Application == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("%s/#/applications/%s", strings.TrimSuffix(deckURL, "/"), url.PathEscape(iw.Details.Application))
+}
+
+// ExecutionURL returns the link to the execution in Deck (Spinnaker's UI)
+// hosted at the given base URL. It is empty if the webhook has no execution.
+// Orchestrations link to the tasks of the application.
+func (iw *IncomingWebhook) ExecutionURL(deckURL string) string {
+	applicationURL := iw.ApplicationURL(deckURL)
+	if applicationURL == "" || iw.Content.ExecutionID == "" {
+		return ""
+	}
+
+	if ex := iw.Content.Execution; ex != nil && ex.IsOrchestration() {
+		return fmt.Sprintf("%s/tasks/%s", applicationURL, url.PathEscape(iw.Content.ExecutionID))
+	}
+
+	return fmt.Sprintf("%s/executions/details/%s", applicationURL, url.PathEscape(iw.Content.ExecutionID))
+}
+
+// StageURL returns the link to the details of the webhook's stage in Deck. It
+// falls back to the execution when the stage can't be found in the execution
+// and for orchestrations, which Deck doesn't link stages of.
+func (iw *IncomingWebhook) StageURL(deckURL string) string {
+	executionURL := iw.ExecutionURL(deckURL)
+	ex := iw.Content.Execution
+	if executionURL == "" || ex == nil || ex.IsOrchestration() || iw.StageName() == "" {
+		return executionURL
+	}
+
+	if i := ex.stageIndex(iw.StageName()); i >= 0 {
+		return fmt.Sprintf("%s?stage=%d", executionURL, i)
+	}
+
+	return executionURL
+}
+
+// stageIndex returns the index Deck gives the named stage, -1 if it isn't
+// part of the execution. Deck only counts top level stages, synthetic stages
+// are shown within their top level stage so they get its index.
+func (e *Execution) stageIndex(name string) int {
+	found := -1
+	for i, stage := range e.Stages {
+		if stage.Name != name {
+			continue
+		}
+		if found < 0 || (e.Stages[found].ParentStageID != "" && stage.ParentStageID == "") {
+			found = i
+		}
+	}
+
+	// Walk up to the top level stage, the depth is bounded in case of cycles
+	for depth := 0; found >= 0 && depth <= len(e.Stages); depth++ {
+		if e.Stages[found].ParentStageID == "" {
+			return e.topLevelIndex(found)
+		}
+		found = e.stagePosition(e.Stages[found].ParentStageID)
+	}
+
+	return -1
+}
+
+// topLevelIndex returns how many top level stages come before the stage at
+// the given position
+func (e *Execution) topLevelIndex(position int) int {
+	index := 0
+	for _, stage := range e.Stages[:position] {
+		if stage.ParentStageID == "" {
+			index++
+		}
+	}
+
+	return index
+}
+
+// stagePosition returns the position of the stage with the given ID in the
+// stages of the execution, -1 if there is none
+func (e *Execution) stagePosition(id string) int {
+	for i, stage := range e.Stages {
+		if stage.ID == id {
+			return i
+		}
+	}
+
+	return -1
 }
@@ -0,0 +1,76 @@
+package types_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+)
+
+func TestStageName(t *testing.T) {
+	wd, _ := os.Getwd()
+	b, err := ioutil.ReadFile(filepath.Join(wd, "..", "testdata", "orca-stage-starting.json"))
+	require.NoError(t, err)
+
+	var incoming types.IncomingWebhook
+	require.NoError(t, json.Unmarshal(b, &incoming))
+	require.Empty(t, incoming.Content.Name, "orca doesn't send content.name")
+
+	assert.Equal(t, "Deploy (Manifest)", incoming.StageName())
+	assert.Equal(t, "https://deck.example.com/#/applications/hcm/executions/details/88ebc19f-43bc-460c-9de5-2347b48f8efb?stage=0", incoming.StageURL("https://deck.example.com"))
+
+	t.Run("Stages without details use the content name", func(t *testing.T) {
+		incoming := &types.IncomingWebhook{
+			Details: types.Details{Type: types.HookTypeStageComplete},
+			Content: types.Content{Name: "Bake"},
+		}
+		assert.Equal(t, "Bake", incoming.StageName())
+	})
+
+	t.Run("Pipelines have no stage", func(t *testing.T) {
+		incoming.Details.Type = types.HookTypePipelineStarting
+		assert.Empty(t, incoming.StageName())
+	})
+}
+
+func TestStageURL(t *testing.T) {
+	stages := []types.Stage{
+		{ID: "bake", Name: "Bake"},
+		{ID: "deploy", Name: "Deploy"},
+		{ID: "pre-deploy", Name: "Disable previous", ParentStageID: "deploy"},
+		{ID: "nested", Name: "Wait", ParentStageID: "pre-deploy"},
+		{ID: "verify", Name: "Verify"},
+	}
+
+	tests := []struct {
+		scenario string
+		stage    string
+		execType string
+		expected string
+	}{
+		{"Top level stages are counted", "Verify", "PIPELINE", "https://deck.example.com/#/applications/payments-api/executions/details/01EXEC?stage=2"},
+		{"Synthetic stages link to their top level stage", "Wait", "PIPELINE", "https://deck.example.com/#/applications/payments-api/executions/details/01EXEC?stage=1"},
+		{"Unknown stages link to the execution", "Rollback", "PIPELINE", "https://deck.example.com/#/applications/payments-api/executions/details/01EXEC"},
+		{"Orchestrations link to their task", "Deploy", "ORCHESTRATION", "https://deck.example.com/#/applications/payments-api/tasks/01EXEC"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			incoming := &types.IncomingWebhook{
+				Details: types.Details{Application: "payments-api", Type: types.HookTypeStageComplete},
+				Content: types.Content{
+					ExecutionID: "01EXEC",
+					Name:        test.stage,
+					Execution:   &types.Execution{Type: test.execType, Stages: stages},
+				},
+			}
+			assert.Equal(t, test.expected, incoming.StageURL("https://deck.example.com"))
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package spinnakerchat
+
+import (
+	"net/http"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+)
+
+// Handler types registered by RegisterHandlerTypes, their options are a
+// destination configuration (webhookUrl, title, text...)
+const (
+	SlackHandlerType = "slack"
+	TeamsHandlerType = "teams"
+)
+
+// RegisterHandlerTypes registers the Slack and Microsoft Teams handler types
+// with the registry. The HTTP client is used for every message posted and
+// messages link to Deck hosted at deckURL unless they have their own deckUrl.
+func RegisterHandlerTypes(r *spinnaker.Registry, c *http.Client, deckURL string) {
+	r.Register(SlackHandlerType, func(config spinnaker.HandlerConfig) (spinnaker.Handler, error) {
+		destination, err := decodeDestination(config)
+		if err != nil {
+			return nil, err
+		}
+		template, err := destination.template(&chatEntry{}, deckURL)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewSlackHandler(c, destination, template), nil
+	})
+
+	r.Register(TeamsHandlerType, func(config spinnaker.HandlerConfig) (spinnaker.Handler, error) {
+		destination, err := decodeDestination(config)
+		if err != nil {
+			return nil, err
+		}
+		template, err := destination.template(&chatEntry{}, deckURL)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewTeamsHandler(c, destination, template), nil
+	})
+}
+
+func decodeDestination(config spinnaker.HandlerConfig) (*DestinationConfig, error) {
+	destination := &DestinationConfig{}
+	return destination, config.DecodeOptions(destination)
+}
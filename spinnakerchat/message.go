@@ -0,0 +1,145 @@
+package spinnakerchat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnakerdatadog"
+)
+
+// defaultTitle is used when neither the destination nor the hook type entry
+// in the templates file have a title
+const defaultTitle = "{{ .Details.Application }} {{ .Details.Type }}"
+
+// Colors used for the status of a message
+const (
+	colorRunning = "#439FE0"
+	colorSuccess = "#2EB886"
+	colorFailure = "#A30200"
+)
+
+// DestinationConfig is the representation of a single Slack or Teams incoming
+// webhook in the templates file
+type DestinationConfig struct {
+	// WebhookURL is the incoming webhook URL messages are posted to
+	WebhookURL string `json:"webhookUrl"`
+	// Applications limits the destination to the given applications, glob
+	// patterns such as "payments-*" are supported. Empty means all applications.
+	Applications []string `json:"applications,omitempty"`
+	// Title and Text override the ones of the hook type entry
+	Title string `json:"title,omitempty"`
+	Text  string `json:"text,omitempty"`
+	// DeckURL is the base URL of Spinnaker's UI, used to link to the execution
+	DeckURL string `json:"deckUrl,omitempty"`
+
+	// Channel, Username and IconEmoji override the Slack incoming webhook defaults
+	Channel   string `json:"channel,omitempty"`
+	Username  string `json:"username,omitempty"`
+	IconEmoji string `json:"iconEmoji,omitempty"`
+}
+
+// matches returns true if the destination wants messages for the application
+func (dc *DestinationConfig) matches(application string) bool {
+	if len(dc.Applications) == 0 {
+		return true
+	}
+
+	for _, pattern := range dc.Applications {
+		if ok, _ := path.Match(pattern, application); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// message is the platform agnostic summary of a webhook that the Slack and
+// Teams handlers format
+type message struct {
+	Title       string
+	Text        string
+	Application string
+	Pipeline    string
+	Stage       string
+	Status      string
+	Color       string
+	Duration    time.Duration
+	Link        string
+}
+
+func newMessage(incoming *types.IncomingWebhook, config *DestinationConfig, template *spinnakerdatadog.EventTemplate) (*message, error) {
+	title, text, err := template.Render(incoming)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &message{
+		Title:       strings.TrimSpace(title),
+		Text:        strings.TrimSpace(text),
+		Application: incoming.Details.Application,
+		Pipeline:    incoming.PipelineName(),
+		Stage:       incoming.StageName(),
+		Duration:    incoming.Duration(),
+	}
+
+	switch incoming.Phase() {
+	case types.PhaseFailed:
+		msg.Status, msg.Color = "Failed", colorFailure
+	case types.PhaseComplete:
+		msg.Status, msg.Color = "Succeeded", colorSuccess
+	default:
+		msg.Status, msg.Color = "Running", colorRunning
+	}
+
+	msg.Link = incoming.StageURL(config.DeckURL)
+
+	return msg, nil
+}
+
+// fields returns the name and value of every populated attribute of the message
+func (m *message) fields() [][2]string {
+	fields := [][2]string{{"Application", m.Application}}
+	if m.Pipeline != "" {
+		fields = append(fields, [2]string{"Pipeline", m.Pipeline})
+	}
+	if m.Stage != "" {
+		fields = append(fields, [2]string{"Stage", m.Stage})
+	}
+	fields = append(fields, [2]string{"Status", m.Status})
+	if m.Duration > 0 {
+		fields = append(fields, [2]string{"Duration", m.Duration.Round(time.Second).String()})
+	}
+
+	return fields
+}
+
+// postJSON sends the payload to an incoming webhook URL
+func postJSON(c *http.Client, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "could not encode message")
+	}
+
+	resp, err := c.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "could not post message")
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("incoming webhook responded with %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package spinnakerchat
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/templates"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnakerdatadog"
+)
+
+// Notifier reads the Slack and Microsoft Teams configuration out of the event
+// templates file and attaches a handler for every destination to a dispatcher
+type Notifier struct {
+	client   *http.Client
+	handlers map[string][]spinnaker.Handler
+}
+
+// chatEntry is the part of a hook type entry in the templates file that the
+// notifier cares about
+type chatEntry struct {
+	Title string               `json:"title,omitempty"`
+	Text  string               `json:"text,omitempty"`
+	Slack []*DestinationConfig `json:"slack,omitempty"`
+	Teams []*DestinationConfig `json:"teams,omitempty"`
+}
+
+// NewNotifier initializes a notifier from the "slack" and "teams" sections of
+// the given templates file. The HTTP client is used for every message posted.
+func NewNotifier(c *http.Client, templateFile string) (*Notifier, error) {
+	file, err := templates.LoadFile(templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewNotifierFromFile(c, file, "")
+}
+
+// NewNotifierFromYAML initializes a notifier from the contents of a templates file
+func NewNotifierFromYAML(c *http.Client, b []byte) (*Notifier, error) {
+	file, err := templates.ParseFile(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewNotifierFromFile(c, file, "")
+}
+
+// NewNotifierFromFile initializes a notifier from a parsed templates file.
+// Messages link to Deck hosted at deckURL unless their destination has its
+// own deckUrl.
+func NewNotifierFromFile(c *http.Client, file *templates.File, deckURL string) (*Notifier, error) {
+	if c == nil {
+		c = http.DefaultClient
+	}
+
+	notifier := &Notifier{client: c, handlers: make(map[string][]spinnaker.Handler)}
+
+	entries := make(map[string]*chatEntry)
+	if err := file.Decode(&entries); err != nil {
+		return nil, err
+	}
+
+	for hookType, entry := range entries {
+		if entry == nil {
+			continue
+		}
+
+		for _, config := range entry.Slack {
+			template, err := config.template(entry, deckURL)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid slack destination for %s", hookType)
+			}
+			notifier.handlers[hookType] = append(notifier.handlers[hookType], NewSlackHandler(c, config, template))
+		}
+
+		for _, config := range entry.Teams {
+			template, err := config.template(entry, deckURL)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid teams destination for %s", hookType)
+			}
+			notifier.handlers[hookType] = append(notifier.handlers[hookType], NewTeamsHandler(c, config, template))
+		}
+	}
+
+	return notifier, nil
+}
+
+// Handlers returns the handlers that get attached to a dispatcher when AttachToDispatcher is called
+func (n *Notifier) Handlers() map[string][]spinnaker.Handler {
+	return n.handlers
+}
+
+// AttachToDispatcher registers all of the handlers for this notifier to a
+// spinnaker dispatcher.
+func (n *Notifier) AttachToDispatcher(d *spinnaker.Dispatcher) {
+	d.AddHandlers(n.Handlers())
+}
+
+// template builds the event template for a destination. The destination's own
+// title, text and Deck URL win over the ones of the hook type entry and the
+// given Deck URL.
+func (dc *DestinationConfig) template(entry *chatEntry, deckURL string) (*spinnakerdatadog.EventTemplate, error) {
+	if dc.WebhookURL == "" {
+		return nil, errors.New("webhookUrl is required")
+	}
+	if dc.DeckURL == "" {
+		dc.DeckURL = deckURL
+	}
+
+	template := &spinnakerdatadog.EventTemplate{Title: entry.Title, Text: entry.Text, DeckURL: dc.DeckURL}
+	if dc.Title != "" {
+		template.Title = dc.Title
+	}
+	if dc.Text != "" {
+		template.Text = dc.Text
+	}
+	if template.Title == "" {
+		template.Title = defaultTitle
+	}
+
+	return template, template.Compile()
+}
@@ -0,0 +1,145 @@
+package spinnakerchat_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnakerchat"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnakerdatadog"
+)
+
+func TestNotifierInitialization(t *testing.T) {
+	wd, _ := os.Getwd()
+
+	t.Run("Given a valid template file", func(t *testing.T) {
+		notifier, err := spinnakerchat.NewNotifier(nil, filepath.Join(wd, "testdata", "template.yml"))
+		require.NoError(t, err)
+
+		d := spinnaker.NewDispatcher()
+		notifier.AttachToDispatcher(d)
+		assert.Len(t, d.Handlers()["orca:pipeline:failed"], 2)
+		assert.Len(t, d.Handlers()["orca:stage:failed"], 1)
+	})
+
+	t.Run("Given a destination without a webhook url", func(t *testing.T) {
+		_, err := spinnakerchat.NewNotifier(nil, filepath.Join(wd, "testdata", "missing-url.yml"))
+		require.Error(t, err)
+	})
+}
+
+func failedStageWebhook() *types.IncomingWebhook {
+	start := time.Unix(1518214000, 0)
+	return &types.IncomingWebhook{
+		Details: types.Details{Application: "payments-api", Type: "orca:stage:failed"},
+		Content: types.Content{
+			ExecutionID: "someid",
+			Name:        "Deploy to production",
+			StartTime:   types.Timestamp{Time: start},
+			EndTime:     types.Timestamp{Time: start.Add(time.Minute * 2)},
+			Execution:   &types.Execution{Name: "Release"},
+		},
+	}
+}
+
+func captureJSON(t *testing.T) (*httptest.Server, <-chan map[string]interface{}) {
+	received := make(chan map[string]interface{}, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var payload map[string]interface{}
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&payload))
+		received <- payload
+	}))
+
+	return ts, received
+}
+
+func TestSlackHandler(t *testing.T) {
+	ts, received := captureJSON(t)
+	defer ts.Close()
+
+	handler := spinnakerchat.NewSlackHandler(ts.Client(), &spinnakerchat.DestinationConfig{
+		WebhookURL:   ts.URL,
+		Applications: []string{"payments-*"},
+		Channel:      "#deploys",
+		DeckURL:      "https://spinnaker.example.com/",
+	}, &spinnakerdatadog.EventTemplate{Title: "{{ .Details.Application }} stage failed"})
+
+	require.NoError(t, handler.Handle(failedStageWebhook()))
+
+	payload := <-received
+	assert.Equal(t, "payments-api stage failed", payload["text"])
+	assert.Equal(t, "#deploys", payload["channel"])
+
+	attachment := payload["attachments"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "#A30200", attachment["color"])
+
+	b, _ := json.Marshal(attachment["blocks"])
+	assert.Contains(t, string(b), `*Pipeline*\nRelease`)
+	assert.Contains(t, string(b), `*Stage*\nDeploy to production`)
+	assert.Contains(t, string(b), `*Duration*\n2m0s`)
+	assert.Contains(t, string(b), "https://spinnaker.example.com/#/applications/payments-api/executions/details/someid")
+
+	t.Run("Applications that don't match are skipped", func(t *testing.T) {
+		incoming := failedStageWebhook()
+		incoming.Details.Application = "billing"
+		require.NoError(t, handler.Handle(incoming))
+
+		select {
+		case <-received:
+			t.Error("slack should not have been called")
+		case <-time.After(time.Millisecond * 50):
+		}
+	})
+}
+
+func TestSlackHandlerEscaping(t *testing.T) {
+	ts, received := captureJSON(t)
+	defer ts.Close()
+
+	handler := spinnakerchat.NewSlackHandler(ts.Client(), &spinnakerchat.DestinationConfig{
+		WebhookURL: ts.URL,
+	}, &spinnakerdatadog.EventTemplate{Title: "{{ .Details.Application }} failed", Text: "<https://example.com|logs>"})
+
+	incoming := failedStageWebhook()
+	incoming.Details.Application = "payments<api>"
+	incoming.Content.Execution.Name = "Build & <!channel>"
+	require.NoError(t, handler.Handle(incoming))
+
+	payload := <-received
+	assert.Equal(t, "payments&lt;api&gt; failed", payload["text"])
+
+	b, _ := json.Marshal(payload["attachments"])
+	assert.Contains(t, string(b), `*payments\u0026lt;api\u0026gt; failed*\n\u003chttps://example.com|logs\u003e`, "the text is mrkdwn written by the template")
+	assert.Contains(t, string(b), `*Application*\npayments\u0026lt;api\u0026gt;`)
+	assert.Contains(t, string(b), `*Pipeline*\nBuild \u0026amp; \u0026lt;!channel\u0026gt;`)
+}
+
+func TestTeamsHandler(t *testing.T) {
+	ts, received := captureJSON(t)
+	defer ts.Close()
+
+	handler := spinnakerchat.NewTeamsHandler(ts.Client(), &spinnakerchat.DestinationConfig{
+		WebhookURL: ts.URL,
+	}, &spinnakerdatadog.EventTemplate{Title: "{{ .Details.Application }} stage failed", Text: "Check it"})
+
+	require.NoError(t, handler.Handle(failedStageWebhook()))
+
+	payload := <-received
+	assert.Equal(t, "MessageCard", payload["@type"])
+	assert.Equal(t, "A30200", payload["themeColor"])
+	assert.Equal(t, "payments-api stage failed", payload["title"])
+	assert.Equal(t, "Check it", payload["text"])
+	assert.Nil(t, payload["potentialAction"])
+
+	facts := payload["sections"].([]interface{})[0].(map[string]interface{})["facts"].([]interface{})
+	assert.Len(t, facts, 5)
+}
@@ -0,0 +1,144 @@
+package spinnakerchat
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/ratelimit"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnakerdatadog"
+)
+
+// SlackHandler posts a message built from blocks to a Slack incoming webhook
+type SlackHandler struct {
+	client   *http.Client
+	config   *DestinationConfig
+	template *spinnakerdatadog.EventTemplate
+}
+
+var (
+	_ spinnaker.Handler   = (*SlackHandler)(nil)
+	_ spinnaker.Previewer = (*SlackHandler)(nil)
+	_ ratelimit.Sender    = (*SlackHandler)(nil)
+)
+
+// NewSlackHandler initializes a slack handler for the given destination
+func NewSlackHandler(c *http.Client, config *DestinationConfig, template *spinnakerdatadog.EventTemplate) *SlackHandler {
+	if c == nil {
+		c = http.DefaultClient
+	}
+
+	return &SlackHandler{client: c, config: config, template: template}
+}
+
+// Name implements spinnaker.Handler
+func (sh *SlackHandler) Name() string {
+	return "SlackHandler"
+}
+
+// Destination implements ratelimit.Sender, it is the host of the webhook URL
+// for the applications the destination matches
+func (sh *SlackHandler) Destination(incoming *types.IncomingWebhook) string {
+	if !sh.config.matches(incoming.Details.Application) {
+		return ""
+	}
+
+	return ratelimit.URLDestination(sh.config.WebhookURL)
+}
+
+// Handle implements spinnaker.Handler. It renders the template for the given
+// webhook and posts it to Slack
+func (sh *SlackHandler) Handle(incoming *types.IncomingWebhook) error {
+	if !sh.config.matches(incoming.Details.Application) {
+		return nil
+	}
+
+	msg, err := newMessage(incoming, sh.config, sh.template)
+	if err != nil {
+		return err
+	}
+
+	return postJSON(sh.client, sh.config.WebhookURL, sh.payload(msg))
+}
+
+// Preview implements spinnaker.Previewer, it returns the payload that would be
+// posted to Slack (nil for applications the destination doesn't match)
+func (sh *SlackHandler) Preview(incoming *types.IncomingWebhook) (interface{}, error) {
+	if !sh.config.matches(incoming.Details.Application) {
+		return nil, nil
+	}
+
+	msg, err := newMessage(incoming, sh.config, sh.template)
+	if err != nil {
+		return nil, err
+	}
+
+	return sh.payload(msg), nil
+}
+
+type slackPayload struct {
+	Text        string            `json:"text"`
+	Channel     string            `json:"channel,omitempty"`
+	Username    string            `json:"username,omitempty"`
+	IconEmoji   string            `json:"icon_emoji,omitempty"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+type slackAttachment struct {
+	Color  string       `json:"color"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type     string       `json:"type"`
+	Text     *slackText   `json:"text,omitempty"`
+	Fields   []slackText  `json:"fields,omitempty"`
+	Elements []slackBlock `json:"elements,omitempty"`
+	URL      string       `json:"url,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// mrkdwnEscaper escapes the characters Slack's mrkdwn requires to be escaped,
+// so titles and fields such as "a<b>" aren't read as links or mentions
+var mrkdwnEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func (sh *SlackHandler) payload(msg *message) *slackPayload {
+	title := mrkdwnEscaper.Replace(msg.Title)
+	summary := fmt.Sprintf("*%s*", title)
+	if msg.Text != "" {
+		summary += "\n" + msg.Text
+	}
+
+	blocks := []slackBlock{
+		{Type: "section", Text: &slackText{Type: "mrkdwn", Text: summary}},
+	}
+
+	fields := make([]slackText, 0)
+	for _, f := range msg.fields() {
+		fields = append(fields, slackText{Type: "mrkdwn", Text: fmt.Sprintf("*%s*\n%s", f[0], mrkdwnEscaper.Replace(f[1]))})
+	}
+	blocks = append(blocks, slackBlock{Type: "section", Fields: fields})
+
+	if msg.Link != "" {
+		blocks = append(blocks, slackBlock{
+			Type: "actions",
+			Elements: []slackBlock{
+				{Type: "button", Text: &slackText{Type: "plain_text", Text: "View in Spinnaker"}, URL: msg.Link},
+			},
+		})
+	}
+
+	return &slackPayload{
+		Text:        title,
+		Channel:     sh.config.Channel,
+		Username:    sh.config.Username,
+		IconEmoji:   sh.config.IconEmoji,
+		Attachments: []slackAttachment{{Color: msg.Color, Blocks: blocks}},
+	}
+}
@@ -0,0 +1,136 @@
+package spinnakerchat
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/ratelimit"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnakerdatadog"
+)
+
+// TeamsHandler posts a message card to a Microsoft Teams incoming webhook
+type TeamsHandler struct {
+	client   *http.Client
+	config   *DestinationConfig
+	template *spinnakerdatadog.EventTemplate
+}
+
+var (
+	_ spinnaker.Handler   = (*TeamsHandler)(nil)
+	_ spinnaker.Previewer = (*TeamsHandler)(nil)
+	_ ratelimit.Sender    = (*TeamsHandler)(nil)
+)
+
+// NewTeamsHandler initializes a teams handler for the given destination
+func NewTeamsHandler(c *http.Client, config *DestinationConfig, template *spinnakerdatadog.EventTemplate) *TeamsHandler {
+	if c == nil {
+		c = http.DefaultClient
+	}
+
+	return &TeamsHandler{client: c, config: config, template: template}
+}
+
+// Name implements spinnaker.Handler
+func (th *TeamsHandler) Name() string {
+	return "TeamsHandler"
+}
+
+// Destination implements ratelimit.Sender, it is the host of the webhook URL
+// for the applications the destination matches
+func (th *TeamsHandler) Destination(incoming *types.IncomingWebhook) string {
+	if !th.config.matches(incoming.Details.Application) {
+		return ""
+	}
+
+	return ratelimit.URLDestination(th.config.WebhookURL)
+}
+
+// Handle implements spinnaker.Handler. It renders the template for the given
+// webhook and posts it to Teams
+func (th *TeamsHandler) Handle(incoming *types.IncomingWebhook) error {
+	if !th.config.matches(incoming.Details.Application) {
+		return nil
+	}
+
+	msg, err := newMessage(incoming, th.config, th.template)
+	if err != nil {
+		return err
+	}
+
+	return postJSON(th.client, th.config.WebhookURL, th.payload(msg))
+}
+
+// Preview implements spinnaker.Previewer, it returns the payload that would be
+// posted to Teams (nil for applications the destination doesn't match)
+func (th *TeamsHandler) Preview(incoming *types.IncomingWebhook) (interface{}, error) {
+	if !th.config.matches(incoming.Details.Application) {
+		return nil, nil
+	}
+
+	msg, err := newMessage(incoming, th.config, th.template)
+	if err != nil {
+		return nil, err
+	}
+
+	return th.payload(msg), nil
+}
+
+type teamsCard struct {
+	Type       string         `json:"@type"`
+	Context    string         `json:"@context"`
+	ThemeColor string         `json:"themeColor"`
+	Summary    string         `json:"summary"`
+	Title      string         `json:"title"`
+	Text       string         `json:"text,omitempty"`
+	Sections   []teamsSection `json:"sections"`
+	Actions    []teamsAction  `json:"potentialAction,omitempty"`
+}
+
+type teamsSection struct {
+	Facts []teamsFact `json:"facts"`
+}
+
+type teamsFact struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type teamsAction struct {
+	Type    string        `json:"@type"`
+	Name    string        `json:"name"`
+	Targets []teamsTarget `json:"targets"`
+}
+
+type teamsTarget struct {
+	OS  string `json:"os"`
+	URI string `json:"uri"`
+}
+
+func (th *TeamsHandler) payload(msg *message) *teamsCard {
+	facts := make([]teamsFact, 0)
+	for _, f := range msg.fields() {
+		facts = append(facts, teamsFact{Name: f[0], Value: f[1]})
+	}
+
+	card := &teamsCard{
+		Type:       "MessageCard",
+		Context:    "http://schema.org/extensions",
+		ThemeColor: strings.TrimPrefix(msg.Color, "#"),
+		Summary:    msg.Title,
+		Title:      msg.Title,
+		Text:       msg.Text,
+		Sections:   []teamsSection{{Facts: facts}},
+	}
+
+	if msg.Link != "" {
+		card.Actions = []teamsAction{{
+			Type:    "OpenUri",
+			Name:    "View in Spinnaker",
+			Targets: []teamsTarget{{OS: "default", URI: msg.Link}},
+		}}
+	}
+
+	return card
+}
@@ -0,0 +1,316 @@
+package spinnakerdatadog
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	datadog "gopkg.in/zorkian/go-datadog-api.v2"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/templates"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+)
+
+// The verdicts of a canary analysis
+const (
+	CanaryPass     = "pass"
+	CanaryMarginal = "marginal"
+	CanaryFail     = "fail"
+	CanaryCanceled = "canceled"
+)
+
+// canaryHookTypes are the hook types canary analysis stages finish with
+var canaryHookTypes = []string{types.HookTypeStageComplete, types.HookTypeStageFailed}
+
+// CanaryHandler reports the results of Kayenta canary analysis stages when
+// they complete or fail. It sends an event summarizing the verdict (pass,
+// marginal, fail or canceled) and records:
+//
+//	spinnaker.canary.analyses           count of analyses by verdict
+//	spinnaker.canary.score              the overall score
+//	spinnaker.canary.threshold.pass     the pass threshold of the canary config
+//	spinnaker.canary.threshold.marginal the marginal threshold of the canary config
+//	spinnaker.canary.group_score        the score of each metric group
+//	spinnaker.canary.metric_results     count of metrics by classification
+//
+// Group scores and metric results are read from Kayenta when the handler has
+// a Kayenta client. Every metric is tagged with the application, pipeline and
+// canary config.
+type CanaryHandler struct {
+	spout      *Spout
+	aggregator *MetricAggregator
+	opts       CanaryOptions
+}
+
+var _ spinnaker.Handler = (*CanaryHandler)(nil)
+
+// CanaryOptions configures canary analysis reporting
+type CanaryOptions struct {
+	// Kayenta reads the group scores and metric results of the analysis,
+	// they aren't reported when it is nil
+	Kayenta *KayentaClient
+}
+
+// NewCanaryHandler initializes a canary handler. Metrics are recorded in the
+// aggregator unless it is nil.
+func NewCanaryHandler(s *Spout, aggregator *MetricAggregator, opts CanaryOptions) *CanaryHandler {
+	return &CanaryHandler{spout: s, aggregator: aggregator, opts: opts}
+}
+
+// Name implements spinnaker.Handler
+func (ch *CanaryHandler) Name() string {
+	return "CanaryHandler"
+}
+
+// HookTypes returns the hook types the handler needs to be attached to
+func (ch *CanaryHandler) HookTypes() []string {
+	return append([]string{}, canaryHookTypes...)
+}
+
+// Handle implements spinnaker.Handler, webhooks of other stages are ignored
+func (ch *CanaryHandler) Handle(incoming *types.IncomingWebhook) error {
+	payload, err := incoming.Payload()
+	if err != nil {
+		return err
+	}
+
+	stage, ok := payload.(*types.StageContent)
+	if !ok || stage.Context.StageDetails().Type != types.CanaryStageType {
+		return nil
+	}
+
+	var canary types.CanaryContext
+	if err := stage.Context.Decode(&canary); err != nil {
+		return err
+	}
+
+	verdict := canaryVerdict(incoming, stage, &canary)
+	judge := ch.judgeResult(stage)
+	ch.record(incoming, &canary, judge, verdict)
+	return ch.post(incoming, &canary, judge, verdict)
+}
+
+// judgeResult reads the judgment of the last interval of the stage from
+// Kayenta. The analysis is still reported without it when it can't be read.
+func (ch *CanaryHandler) judgeResult(stage *types.StageContent) *types.CanaryJudgeResult {
+	if ch.opts.Kayenta == nil || stage.Execution == nil {
+		return nil
+	}
+
+	id := stage.Execution.CanaryExecutionID(stage.StageName())
+	if id == "" {
+		return nil
+	}
+
+	judge, err := ch.opts.Kayenta.JudgeResult(id)
+	if err != nil {
+		logrus.WithError(err).WithField("canary_execution", id).Warn("could not read canary judgment from kayenta")
+		return nil
+	}
+
+	return judge
+}
+
+// canaryVerdict classifies the overall score with the thresholds of the
+// canary config: scores reaching the pass threshold pass, scores reaching the
+// marginal threshold are marginal and lower scores fail. Analyses without a
+// score or thresholds take the verdict of the stage.
+func canaryVerdict(incoming *types.IncomingWebhook, stage *types.StageContent, canary *types.CanaryContext) string {
+	if stage.Canceled {
+		return CanaryCanceled
+	}
+
+	score, scored := canary.OverallScore()
+	thresholds := canary.CanaryConfig.ScoreThresholds
+	switch {
+	case !scored || thresholds.Pass <= 0:
+		if incoming.Phase() == types.PhaseFailed {
+			return CanaryFail
+		}
+		return CanaryPass
+	case score >= float64(thresholds.Pass):
+		return CanaryPass
+	case score >= float64(thresholds.Marginal):
+		return CanaryMarginal
+	default:
+		return CanaryFail
+	}
+}
+
+func (ch *CanaryHandler) record(incoming *types.IncomingWebhook, canary *types.CanaryContext, judge *types.CanaryJudgeResult, verdict string) {
+	if ch.aggregator == nil {
+		return
+	}
+
+	org := ch.spout.Organization(incoming.Details.Application)
+	tags := canaryTags(incoming, canary)
+
+	ch.aggregator.Count(org, "spinnaker.canary.analyses", 1, append(tags, fmt.Sprintf("verdict:%s", verdict))...)
+
+	if score, ok := canary.OverallScore(); ok {
+		ch.aggregator.Gauge(org, "spinnaker.canary.score", score, tags...)
+	}
+
+	thresholds := canary.CanaryConfig.ScoreThresholds
+	if thresholds.Pass > 0 {
+		ch.aggregator.Gauge(org, "spinnaker.canary.threshold.pass", float64(thresholds.Pass), tags...)
+	}
+	if thresholds.Marginal > 0 {
+		ch.aggregator.Gauge(org, "spinnaker.canary.threshold.marginal", float64(thresholds.Marginal), tags...)
+	}
+
+	if judge == nil {
+		return
+	}
+
+	for _, group := range judge.GroupScores {
+		ch.aggregator.Gauge(org, "spinnaker.canary.group_score", float64(group.Score), append(tags, fmt.Sprintf("group:%s", group.Name))...)
+	}
+
+	for _, result := range judge.Results {
+		ch.aggregator.Count(org, "spinnaker.canary.metric_results", 1, append(tags,
+			fmt.Sprintf("metric:%s", result.Name),
+			fmt.Sprintf("classification:%s", strings.ToLower(result.Classification)),
+		)...)
+	}
+}
+
+func (ch *CanaryHandler) post(incoming *types.IncomingWebhook, canary *types.CanaryContext, judge *types.CanaryJudgeResult, verdict string) error {
+	title := fmt.Sprintf("%s canary %s", incoming.Details.Application, canary.CanaryConfig.Name())
+	score, scored := canary.OverallScore()
+
+	event := &datadog.Event{}
+	switch verdict {
+	case CanaryPass:
+		title += " passed"
+		event.SetAlertType("success")
+	case CanaryMarginal:
+		title += " was marginal"
+		event.SetAlertType("warning")
+	case CanaryFail:
+		title += " failed"
+		event.SetAlertType("error")
+	default:
+		title += " was canceled"
+		event.SetAlertType("warning")
+	}
+	if scored {
+		title += " with score " + formatScore(score)
+	}
+
+	event.SetTitle(title)
+	event.SetText(canaryText(incoming, canary, judge, ch.spout.deckURL))
+	event.SetAggregation(incoming.Content.ExecutionID)
+	ch.spout.dateEvent(event, incoming)
+	if link := incoming.StageURL(ch.spout.deckURL); link != "" {
+		event.SetUrl(link)
+	}
+	event.Tags = append(canaryTags(incoming, canary), fmt.Sprintf("verdict:%s", verdict))
+
+	if _, err := ch.spout.Organization(incoming.Details.Application).Client().PostEvent(event); err != nil {
+		return errors.Wrap(err, "could not post canary result to datadog API")
+	}
+
+	return nil
+}
+
+func canaryTags(incoming *types.IncomingWebhook, canary *types.CanaryContext) []string {
+	tags := []string{
+		fmt.Sprintf("app:%s", incoming.Details.Application),
+		fmt.Sprintf("canary_config:%s", canary.CanaryConfig.Name()),
+	}
+	if pipeline := incoming.PipelineName(); pipeline != "" {
+		tags = append(tags, fmt.Sprintf("pipeline:%s", pipeline))
+	}
+
+	return tags
+}
+
+func formatScore(score float64) string {
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+// canaryText lists the overall and interval scores, the thresholds and the
+// message orca explains the score with, then the group scores and metric
+// results of the judge, highlighting the ones that didn't pass
+func canaryText(incoming *types.IncomingWebhook, canary *types.CanaryContext, judge *types.CanaryJudgeResult, deckURL string) string {
+	buf := new(bytes.Buffer)
+	buf.WriteString(markdownBlock + " \n")
+
+	if score, ok := canary.OverallScore(); ok {
+		fmt.Fprintf(buf, "**Score:** %s", formatScore(score))
+	} else {
+		buf.WriteString("**Score:** -")
+	}
+
+	if thresholds := canary.CanaryConfig.ScoreThresholds; thresholds.Pass > 0 {
+		fmt.Fprintf(buf, " (pass %s, marginal %s)", formatScore(float64(thresholds.Pass)), formatScore(float64(thresholds.Marginal)))
+	}
+
+	if len(canary.CanaryScores) > 1 {
+		scores := make([]string, 0, len(canary.CanaryScores))
+		for _, score := range canary.CanaryScores {
+			scores = append(scores, formatScore(float64(score)))
+		}
+		fmt.Fprintf(buf, "\n\n**Interval scores:** %s", strings.Join(scores, ", "))
+	}
+
+	if canary.CanaryScoreMessage != "" {
+		buf.WriteString("\n\n" + string(templates.MarkdownEscape(canary.CanaryScoreMessage)))
+	}
+
+	if judge != nil {
+		if len(judge.GroupScores) > 0 {
+			buf.WriteString("\n\n" + string(templates.MarkdownHeader("Group", "Score", "Classification")))
+			for _, group := range judge.GroupScores {
+				canaryRow(buf, group.Classification, group.Name, formatScore(float64(group.Score)), group.Classification)
+			}
+		}
+
+		if len(judge.Results) > 0 {
+			buf.WriteString("\n\n" + string(templates.MarkdownHeader("Metric", "Groups", "Classification", "Reason")))
+			for _, result := range judge.Results {
+				canaryRow(buf, result.Classification, result.Name, strings.Join(result.Groups, ", "), result.Classification, result.ClassificationReason)
+			}
+		}
+	}
+
+	if link := incoming.StageURL(deckURL); link != "" {
+		buf.WriteString("\n\n" + string(templates.MarkdownLink("View in Spinnaker", link)))
+	}
+
+	buf.WriteString("\n " + markdownBlock)
+
+	return buf.String()
+}
+
+// canaryRow writes a table row, bolded unless the classification is Pass
+func canaryRow(buf *bytes.Buffer, classification string, cells ...string) {
+	values := make([]interface{}, 0, len(cells))
+	for _, cell := range cells {
+		if cell == "" {
+			cell = "-"
+		}
+		values = append(values, cell)
+	}
+
+	if strings.EqualFold(classification, "pass") {
+		buf.WriteString("\n" + string(templates.MarkdownRow(values...)))
+		return
+	}
+
+	buf.WriteString("\n|")
+	for _, value := range values {
+		fmt.Fprintf(buf, " **%s** |", strings.Replace(string(templates.MarkdownEscape(value)), "\n", " ", -1))
+	}
+}
+
+// EnableCanaryAnalysis makes the spout report the results of canary analysis
+// stages. It must be called before the spout is attached to a dispatcher.
+func (s *Spout) EnableCanaryAnalysis(aggregator *MetricAggregator, opts CanaryOptions) {
+	s.canary = NewCanaryHandler(s, aggregator, opts)
+}
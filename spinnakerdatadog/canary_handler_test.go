@@ -0,0 +1,205 @@
+package spinnakerdatadog_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	datadog "gopkg.in/zorkian/go-datadog-api.v2"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+	spinnakerdatadog "github.com/bobbytables/spinnaker-datadog-bridge/spinnakerdatadog"
+)
+
+func loadCanary(t *testing.T) *types.IncomingWebhook {
+	return canaryWebhook(t, types.HookTypeStageFailed, func(map[string]interface{}) {})
+}
+
+// canaryWebhook is the canary fixture with the given hook type, its content
+// is changed by change before it is decoded
+func canaryWebhook(t *testing.T, hookType string, change func(content map[string]interface{})) *types.IncomingWebhook {
+	wd, _ := os.Getwd()
+	b, err := ioutil.ReadFile(filepath.Join(wd, "..", "spinnaker", "testdata", "kayenta-canary.json"))
+	require.NoError(t, err)
+
+	var fixture map[string]map[string]interface{}
+	require.NoError(t, json.Unmarshal(b, &fixture))
+	fixture["details"]["type"] = hookType
+	change(fixture["content"])
+
+	b, err = json.Marshal(fixture)
+	require.NoError(t, err)
+
+	var incoming types.IncomingWebhook
+	require.NoError(t, json.Unmarshal(b, &incoming))
+
+	return &incoming
+}
+
+// captureCanary records the events sent to Datadog, series are recorded by
+// captureSeries
+func captureCanary(t *testing.T) (*spinnakerdatadog.Spout, chan datadog.Event, func(path string) []submittedSeries, func()) {
+	series, received := captureSeries(t)
+
+	events := make(chan datadog.Event, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/v1/events" {
+			series.Config.Handler.ServeHTTP(w, req)
+			return
+		}
+
+		var event datadog.Event
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&event))
+		events <- event
+	}))
+
+	org := spinnakerdatadog.NewOrganization(spinnakerdatadog.DefaultOrganization, "", "")
+	org.Connect(ts.URL, ts.Client())
+	spout, _ := spinnakerdatadog.NewSpout(org.Client(), "")
+
+	return spout, events, received, func() {
+		ts.Close()
+		series.Close()
+	}
+}
+
+func TestCanaryHandler(t *testing.T) {
+	spout, events, received, closeServer := captureCanary(t)
+	defer closeServer()
+
+	wd, _ := os.Getwd()
+	execution, err := ioutil.ReadFile(filepath.Join(wd, "testdata", "kayenta-canary-execution.json"))
+	require.NoError(t, err)
+	kayenta := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/canary/01C5ZB3H4J5K6M7N8P9Q0R1S2T" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write(execution)
+	}))
+	defer kayenta.Close()
+
+	aggregator := spinnakerdatadog.NewMetricAggregator(spinnakerdatadog.MetricsOptions{FlushInterval: time.Hour})
+	spout.EnableCanaryAnalysis(aggregator, spinnakerdatadog.CanaryOptions{
+		Kayenta: spinnakerdatadog.NewKayentaClient(kayenta.URL+"/", kayenta.Client()),
+	})
+	handlers := spout.Handlers()[types.HookTypeStageFailed]
+	require.Len(t, handlers, 1)
+
+	require.NoError(t, handlers[0].Handle(loadCanary(t)))
+
+	event := <-events
+	assert.Equal(t, "hcm canary hcm-web failed with score 66.67", event.GetTitle())
+	assert.Equal(t, "error", event.GetAlertType())
+	assert.Equal(t, []string{"app:hcm", "canary_config:hcm-web", "pipeline:Deploy to production", "verdict:fail"}, event.Tags)
+	assert.Equal(t, "%%% \n"+
+		"**Score:** 66.67 (pass 95, marginal 75)\n\n"+
+		"**Interval scores:** 96.5, 66.67\n\n"+
+		"Final canary score 66.67 is not above the pass score threshold.\n\n"+
+		"| Group | Score | Classification |\n| --- | --- | --- |\n"+
+		"| **errors** | **0** | **Fail** |\n"+
+		"| latency | 100 | Pass |\n\n"+
+		"| Metric | Groups | Classification | Reason |\n| --- | --- | --- | --- |\n"+
+		"| **5xx rate** | **errors** | **High** | **The metric was classified as High \\(Critical\\)** |\n"+
+		"| p99 latency | latency | Pass | \\- |\n"+
+		"| **p50 latency** | **latency** | **Nodata** | **\\-** |\n %%%", event.GetText())
+
+	require.NoError(t, aggregator.Close())
+
+	values := make(map[string]float64)
+	for _, s := range received("/api/v1/series") {
+		assert.Subset(t, s.Tags, []string{"app:hcm", "canary_config:hcm-web"})
+
+		key := s.Metric
+		for _, tag := range s.Tags {
+			switch strings.SplitN(tag, ":", 2)[0] {
+			case "verdict", "group", "metric", "classification":
+				key += " " + tag
+			}
+		}
+		values[key] = s.Points[0][1].(float64)
+	}
+
+	assert.Equal(t, map[string]float64{
+		"spinnaker.canary.analyses verdict:fail":                                   1,
+		"spinnaker.canary.score":                                                   66.67,
+		"spinnaker.canary.threshold.pass":                                          95,
+		"spinnaker.canary.threshold.marginal":                                      75,
+		"spinnaker.canary.group_score group:errors":                                0,
+		"spinnaker.canary.group_score group:latency":                               100,
+		"spinnaker.canary.metric_results classification:high metric:5xx rate":      1,
+		"spinnaker.canary.metric_results classification:nodata metric:p50 latency": 1,
+		"spinnaker.canary.metric_results classification:pass metric:p99 latency":   1,
+	}, values)
+
+	t.Run("Analyses Kayenta can't be read for are reported without its results", func(t *testing.T) {
+		require.NoError(t, handlers[0].Handle(canaryWebhook(t, types.HookTypeStageFailed, func(content map[string]interface{}) {
+			stages := content["execution"].(map[string]interface{})["stages"].([]interface{})
+			stages[len(stages)-1].(map[string]interface{})["context"] = map[string]interface{}{"canaryPipelineExecutionId": "missing"}
+		})))
+
+		event := <-events
+		assert.Equal(t, "hcm canary hcm-web failed with score 66.67", event.GetTitle())
+		assert.NotContains(t, event.GetText(), "| Group |")
+	})
+
+	t.Run("Verdicts come from the score and thresholds", func(t *testing.T) {
+		tests := []struct {
+			score     float64
+			title     string
+			alertType string
+		}{
+			{score: 96.5, title: "hcm canary hcm-web passed with score 96.5", alertType: "success"},
+			{score: 95, title: "hcm canary hcm-web passed with score 95", alertType: "success"},
+			{score: 80, title: "hcm canary hcm-web was marginal with score 80", alertType: "warning"},
+			{score: 66.67, title: "hcm canary hcm-web failed with score 66.67", alertType: "error"},
+		}
+
+		for _, test := range tests {
+			require.NoError(t, handlers[0].Handle(canaryWebhook(t, types.HookTypeStageComplete, func(content map[string]interface{}) {
+				content["context"].(map[string]interface{})["canaryScores"] = []float64{test.score}
+			})))
+
+			event := <-events
+			assert.Equal(t, test.title, event.GetTitle())
+			assert.Equal(t, test.alertType, event.GetAlertType())
+		}
+	})
+
+	t.Run("Canceled stages were canceled", func(t *testing.T) {
+		require.NoError(t, handlers[0].Handle(canaryWebhook(t, types.HookTypeStageFailed, func(content map[string]interface{}) {
+			content["canceled"] = true
+		})))
+
+		event := <-events
+		assert.Equal(t, "hcm canary hcm-web was canceled with score 66.67", event.GetTitle())
+		assert.Equal(t, "warning", event.GetAlertType())
+		assert.Contains(t, event.Tags, "verdict:canceled")
+	})
+
+	t.Run("Unscored stages take the verdict of the stage", func(t *testing.T) {
+		require.NoError(t, handlers[0].Handle(canaryWebhook(t, types.HookTypeStageComplete, func(content map[string]interface{}) {
+			delete(content["context"].(map[string]interface{}), "canaryScores")
+		})))
+
+		event := <-events
+		assert.Equal(t, "hcm canary hcm-web passed", event.GetTitle())
+	})
+
+	t.Run("Other stages are ignored", func(t *testing.T) {
+		require.NoError(t, handlers[0].Handle(stageWebhook(types.HookTypeStageFailed, "Deploy", time.Now(), time.Minute)))
+		select {
+		case event := <-events:
+			t.Fatalf("unexpected event %q", event.GetTitle())
+		default:
+		}
+	})
+}
@@ -1,10 +1,10 @@
 package spinnakerdatadog
 
 import (
-	"bytes"
 	"fmt"
 
 	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/ratelimit"
 	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
 	"github.com/pkg/errors"
 	datadog "gopkg.in/zorkian/go-datadog-api.v2"
@@ -18,7 +18,15 @@ type DatadogEventHandler struct {
 	template *EventTemplate
 }
 
-var _ spinnaker.Handler = (*DatadogEventHandler)(nil)
+// Destination prefixes the rate limit destination of the requests to every
+// Datadog organization, see Organization.Destination
+const Destination = "datadog"
+
+var (
+	_ spinnaker.Handler   = (*DatadogEventHandler)(nil)
+	_ spinnaker.Previewer = (*DatadogEventHandler)(nil)
+	_ ratelimit.Sender    = (*DatadogEventHandler)(nil)
+)
 
 // NewDatadogEventHandler initializes a datadog event handler
 func NewDatadogEventHandler(s *Spout, template *EventTemplate) *DatadogEventHandler {
@@ -33,34 +41,51 @@ func (deh *DatadogEventHandler) Name() string {
 	return "DatadogEventHandler"
 }
 
+// Destination implements ratelimit.Sender, every webhook is sent to the
+// Datadog organization of its application
+func (deh *DatadogEventHandler) Destination(incoming *types.IncomingWebhook) string {
+	return deh.spout.Organization(incoming.Details.Application).Destination()
+}
+
 // Handle implements spinnaker.Handler. It sends datadog events for the given
 // webhook event type. It compiles the given template from the webhook and sends it
 func (deh *DatadogEventHandler) Handle(incoming *types.IncomingWebhook) error {
-	if err := deh.template.Compile(); err != nil {
-		return errors.Wrap(err, "could not compile template")
+	event, err := deh.event(incoming)
+	if err != nil {
+		return err
 	}
 
-	titleBuf, textBuf := new(bytes.Buffer), new(bytes.Buffer)
-	if err := deh.template.compiledTitle.Execute(titleBuf, incoming); err != nil {
-		return errors.Wrap(err, "could not compile title from webhook")
+	if _, err := deh.spout.Organization(incoming.Details.Application).Client().PostEvent(event); err != nil {
+		return errors.Wrap(err, "could not post to datadog API")
 	}
 
-	if err := deh.template.compiledText.Execute(textBuf, incoming); err != nil {
-		return errors.Wrap(err, "could not compile text from webhook")
+	return nil
+}
+
+// Preview implements spinnaker.Previewer, it returns the event that would be
+// posted for the webhook
+func (deh *DatadogEventHandler) Preview(incoming *types.IncomingWebhook) (interface{}, error) {
+	return deh.event(incoming)
+}
+
+func (deh *DatadogEventHandler) event(incoming *types.IncomingWebhook) (*datadog.Event, error) {
+	title, text, err := deh.template.Render(incoming)
+	if err != nil {
+		return nil, err
 	}
 
 	event := &datadog.Event{}
-	event.SetTitle(titleBuf.String())
-	event.SetText(textBuf.String())
+	event.SetTitle(title)
+	event.SetText(deh.template.DatadogText(text))
 	event.SetAggregation(incoming.Content.ExecutionID)
+	deh.spout.dateEvent(event, incoming)
+	if link := incoming.ExecutionURL(deh.spout.deckURL); link != "" {
+		event.SetUrl(link)
+	}
 	event.Tags = []string{
 		fmt.Sprintf("app:%s", incoming.Details.Application),
 		incoming.Details.Type,
 	}
 
-	if _, err := deh.spout.client.PostEvent(event); err != nil {
-		return errors.Wrap(err, "could not post to datadog API")
-	}
-
-	return nil
+	return event, nil
 }
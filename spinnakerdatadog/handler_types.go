@@ -0,0 +1,45 @@
+package spinnakerdatadog
+
+import (
+	"sync"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+)
+
+// Handler types registered by RegisterHandlerTypes
+const (
+	// EventHandlerType sends events, its options are an event template
+	EventHandlerType = "datadog-event"
+	// MetricHandlerType submits webhook counts and durations, it takes no options
+	MetricHandlerType = "datadog-metric"
+)
+
+// RegisterHandlerTypes registers the Datadog handler types with the registry.
+// The aggregator is only requested when the first datadog-metric handler is
+// built so nothing is flushed unless metrics are configured.
+func (s *Spout) RegisterHandlerTypes(r *spinnaker.Registry, aggregator func() *MetricAggregator) {
+	r.Register(EventHandlerType, func(config spinnaker.HandlerConfig) (spinnaker.Handler, error) {
+		template := &EventTemplate{DeckURL: s.deckURL}
+		if err := config.DecodeOptions(template); err != nil {
+			return nil, err
+		}
+		if err := template.Compile(); err != nil {
+			return nil, err
+		}
+
+		return NewDatadogEventHandler(s, template), nil
+	})
+
+	var (
+		once sync.Once
+		agg  *MetricAggregator
+	)
+	r.Register(MetricHandlerType, func(config spinnaker.HandlerConfig) (spinnaker.Handler, error) {
+		if err := config.DecodeOptions(&struct{}{}); err != nil {
+			return nil, err
+		}
+
+		once.Do(func() { agg = aggregator() })
+		return NewDatadogMetricsHandler(s, agg), nil
+	})
+}
@@ -0,0 +1,63 @@
+package spinnakerdatadog_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/ratelimit"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+	spinnakerdatadog "github.com/bobbytables/spinnaker-datadog-bridge/spinnakerdatadog"
+	datadog "gopkg.in/zorkian/go-datadog-api.v2"
+)
+
+func TestDatadogHandlerTypes(t *testing.T) {
+	spout, err := spinnakerdatadog.NewSpout(nil, "")
+	require.NoError(t, err)
+
+	aggregators := 0
+	registry := spinnaker.NewRegistry()
+	spout.RegisterHandlerTypes(registry, func() *spinnakerdatadog.MetricAggregator {
+		aggregators++
+		return spinnakerdatadog.NewMetricAggregator(spinnakerdatadog.MetricsOptions{FlushInterval: time.Hour})
+	})
+	assert.Equal(t, []string{spinnakerdatadog.EventHandlerType, spinnakerdatadog.MetricHandlerType}, registry.Types())
+
+	t.Run("Event handlers take an event template", func(t *testing.T) {
+		handler, err := registry.New(spinnaker.HandlerConfig{
+			Type:    spinnakerdatadog.EventHandlerType,
+			Options: map[string]interface{}{"title": "{{ .Details.Application }} failed", "markdown": true},
+		})
+		require.NoError(t, err)
+
+		preview, err := handler.(spinnaker.Previewer).Preview(&types.IncomingWebhook{
+			Details: types.Details{Application: "someapp"},
+		})
+		require.NoError(t, err)
+		require.IsType(t, &datadog.Event{}, preview)
+		assert.Equal(t, "someapp failed", preview.(*datadog.Event).GetTitle())
+
+		require.Implements(t, (*ratelimit.Sender)(nil), handler)
+		assert.Equal(t, "datadog:default", handler.(ratelimit.Sender).Destination(&types.IncomingWebhook{}))
+	})
+
+	t.Run("Event templates are compiled", func(t *testing.T) {
+		_, err := registry.New(spinnaker.HandlerConfig{
+			Type:    spinnakerdatadog.EventHandlerType,
+			Options: map[string]interface{}{"title": "{{ .Details.Application "},
+		})
+		require.Error(t, err)
+	})
+
+	t.Run("Metric handlers share one aggregator", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			handler, err := registry.New(spinnaker.HandlerConfig{Type: spinnakerdatadog.MetricHandlerType})
+			require.NoError(t, err)
+			assert.Equal(t, "DatadogMetricsHandler", handler.Name())
+		}
+		assert.Equal(t, 1, aggregators)
+	})
+}
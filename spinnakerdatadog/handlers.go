@@ -1,21 +1,34 @@
 package spinnakerdatadog
 
 import (
-	"html/template"
-	"io/ioutil"
-	"os"
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
 
-	"github.com/ghodss/yaml"
 	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/templates"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	datadog "gopkg.in/zorkian/go-datadog-api.v2"
 )
 
 // Spout is the main handler for all of the Spinnaker events. It attaches
 // handlers to a Spinnaker dispatcher to fan out events correctly
 type Spout struct {
-	client         *datadog.Client
+	router         *Router
 	eventTemplates map[string]*EventTemplate
+	summary        *PipelineSummaryHandler
+	judgments      *ManualJudgmentHandler
+	canary         *CanaryHandler
+	// deckURL is the base URL of Deck events link to
+	deckURL string
+	// preserveTimestamps dates events with the time of the webhook rather
+	// than the time they are sent
+	preserveTimestamps bool
 }
 
 // EventTemplate is the representation in the template file
@@ -23,58 +36,217 @@ type Spout struct {
 type EventTemplate struct {
 	Title string `json:"title,omitempty"`
 	Text  string `json:"text,omitempty"`
+	// Markdown sends the text to Datadog as markdown (wrapped in a %%% block)
+	Markdown bool `json:"markdown,omitempty"`
+	// Escape controls how values interpolated in the title and text are escaped
+	Escape EscapeConfig `json:"escape,omitempty"`
+	// DeckLink appends a link to the execution in Deck to the text
+	DeckLink bool `json:"deckLink,omitempty"`
+	// DeckURL is the base URL of Deck the links of the template are built
+	// from. It is set by the handler the template belongs to before compiling.
+	DeckURL string `json:"-"`
 
 	compiledTitle *template.Template
 	compiledText  *template.Template
-	isCompiled    bool
+	compileOnce   sync.Once
+	compileErr    error
 }
 
+// EscapeConfig is the escape mode of every field of an event template. Values
+// aren't escaped by default, except in the text of markdown templates.
+type EscapeConfig struct {
+	Title templates.EscapeMode `json:"title,omitempty"`
+	Text  templates.EscapeMode `json:"text,omitempty"`
+}
+
+// markdownBlock starts and ends markdown text in Datadog events
+const markdownBlock = "%%%"
+
+// Compile parses the title and text templates. It is safe to call multiple
+// times (and concurrently), the templates are only parsed once.
 func (et *EventTemplate) Compile() error {
-	if et.isCompiled {
-		return nil
-	}
+	et.compileOnce.Do(func() {
+		textEscape := et.Escape.Text
+		if textEscape == "" && et.Markdown {
+			textEscape = templates.EscapeMarkdown
+		}
+
+		et.compiledTitle, et.compileErr = et.compileField("eventTitle", et.Title, et.Escape.Title)
+		if et.compileErr != nil {
+			return
+		}
+
+		et.compiledText, et.compileErr = et.compileField("eventText", et.Text, textEscape)
+	})
+
+	return et.compileErr
+}
 
-	var err error
-	et.compiledTitle, err = template.New("eventTitle").Parse(et.Title)
+func (et *EventTemplate) compileField(name, text string, escape templates.EscapeMode) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(templates.Funcs(et.DeckURL)).Parse(text)
 	if err != nil {
-		return errors.Wrap(err, "could not compile eventTitle")
+		return nil, errors.Wrapf(err, "could not compile %s", name)
 	}
 
-	et.compiledText, err = template.New("eventText").Parse(et.Text)
-	if err != nil {
-		return errors.Wrap(err, "could not compile eventText")
+	if err := templates.Escape(tmpl, escape); err != nil {
+		return nil, errors.Wrapf(err, "could not escape %s", name)
+	}
+
+	return tmpl, nil
+}
+
+// DatadogText returns rendered text in the format the Datadog events API
+// expects, wrapping it in a markdown block for markdown templates
+func (et *EventTemplate) DatadogText(text string) string {
+	if !et.Markdown || strings.HasPrefix(strings.TrimSpace(text), markdownBlock) {
+		return text
+	}
+
+	return markdownBlock + " \n" + text + "\n " + markdownBlock
+}
+
+// hasEvent is false for entries in the templates file that exist only to
+// configure other handlers (forwards for example)
+func (et *EventTemplate) hasEvent() bool {
+	return et != nil && (et.Title != "" || et.Text != "")
+}
+
+// Render compiles the template (if it hasn't been already) and executes the
+// title and text against the given webhook
+func (et *EventTemplate) Render(incoming *types.IncomingWebhook) (title string, text string, err error) {
+	if err := et.Compile(); err != nil {
+		return "", "", errors.Wrap(err, "could not compile template")
+	}
+
+	titleBuf, textBuf := new(bytes.Buffer), new(bytes.Buffer)
+	if err := et.compiledTitle.Execute(titleBuf, incoming); err != nil {
+		return "", "", errors.Wrap(err, "could not compile title from webhook")
+	}
+
+	if err := et.compiledText.Execute(textBuf, incoming); err != nil {
+		return "", "", errors.Wrap(err, "could not compile text from webhook")
 	}
 
-	return err
+	if link := incoming.ExecutionURL(et.DeckURL); et.DeckLink && link != "" {
+		if textBuf.Len() > 0 {
+			textBuf.WriteString("\n\n")
+		}
+
+		if et.Markdown {
+			fmt.Fprintf(textBuf, "[View in Spinnaker](%s)", link)
+		} else {
+			fmt.Fprintf(textBuf, "View in Spinnaker: %s", link)
+		}
+	}
+
+	return titleBuf.String(), textBuf.String(), nil
 }
 
 // NewSpout initializes a new spout for spitting out datadog events from
 // Spinnaker event webhooks
 func NewSpout(c *datadog.Client, templateFile string) (*Spout, error) {
-	spout := &Spout{client: c}
-
-	if templateFile == "" {
-		return spout, nil
+	file, err := templates.LoadFile(templateFile)
+	if err != nil {
+		return nil, err
 	}
 
-	f, err := os.Open(templateFile)
+	return NewSpoutFromFile(c, file, "")
+}
+
+// NewSpoutFromYAML initializes a spout from the contents of a templates file
+func NewSpoutFromYAML(c *datadog.Client, b []byte) (*Spout, error) {
+	file, err := templates.ParseFile(b)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not open template file")
+		return nil, err
 	}
-	b, err := ioutil.ReadAll(f)
-	if err != nil {
-		return nil, errors.Wrap(err, "could not read template file")
+
+	return NewSpoutFromFile(c, file, "")
+}
+
+// NewSpoutFromFile initializes a spout from a parsed templates file. Events
+// link to Deck hosted at deckURL, they have no links if it is empty.
+func NewSpoutFromFile(c *datadog.Client, file *templates.File, deckURL string) (*Spout, error) {
+	spout := &Spout{
+		router:  NewRouter(&Organization{Name: DefaultOrganization, client: c}),
+		deckURL: deckURL,
 	}
 
 	et := make(map[string]*EventTemplate)
-	if err := yaml.Unmarshal(b, &et); err != nil {
-		return nil, errors.Wrap(err, "could not unmarshal template file")
+	if err := file.Decode(&et); err != nil {
+		return nil, err
+	}
+	for _, template := range et {
+		if template != nil {
+			template.DeckURL = deckURL
+		}
 	}
 
 	spout.eventTemplates = et
 	return spout, nil
 }
 
+// UseRouter makes the spout send each webhook to the Datadog organization the
+// router selects for its application
+func (s *Spout) UseRouter(r *Router) {
+	s.router = r
+}
+
+// PreserveTimestamps makes events happen at the time their webhook was
+// created in Spinnaker rather than when they are sent, for replaying webhooks
+func (s *Spout) PreserveTimestamps() {
+	s.preserveTimestamps = true
+}
+
+// maxEventAge is how old events can be dated. Datadog rejects events older
+// than 18 hours, a minute is left for the event to reach it.
+const maxEventAge = 18*time.Hour - time.Minute
+
+// dateEvent dates the event with the creation time of its webhook when
+// timestamps are preserved, otherwise Datadog dates it when it is received.
+// Webhooks older than Datadog accepts are dated as far back as it allows.
+func (s *Spout) dateEvent(event *datadog.Event, incoming *types.IncomingWebhook) {
+	if !s.preserveTimestamps || incoming.Details.Created.IsZero() {
+		return
+	}
+
+	created := incoming.Details.Created.Time
+	if oldest := time.Now().Add(-maxEventAge); created.Before(oldest) {
+		logrus.WithFields(logrus.Fields{
+			"execution": incoming.Content.ExecutionID,
+			"created":   created,
+		}).Warn("webhook is older than datadog accepts events, dating its event 18 hours ago")
+		created = oldest
+	}
+
+	event.SetTime(int(created.Unix()))
+}
+
+// Organization returns the Datadog organization webhooks for the given
+// application are sent to
+func (s *Spout) Organization(application string) *Organization {
+	return s.router.Route(application)
+}
+
+// Template returns the event template registered for the given hook type, or
+// nil if there isn't one
+func (s *Spout) Template(hookType string) *EventTemplate {
+	if et := s.eventTemplates[hookType]; et.hasEvent() {
+		return et
+	}
+
+	return nil
+}
+
+// Templates returns every event template keyed by hook type
+func (s *Spout) Templates() map[string]*EventTemplate {
+	templates := make(map[string]*EventTemplate, len(s.eventTemplates))
+	for hookType, et := range s.eventTemplates {
+		templates[hookType] = et
+	}
+
+	return templates
+}
+
 // TotalTemplates returns how many templates are currently registered
 // for events
 func (s *Spout) TotalTemplates() int {
@@ -86,20 +258,42 @@ func (s *Spout) Handlers() map[string][]spinnaker.Handler {
 	hs := make(map[string][]spinnaker.Handler)
 
 	for hookType, eventTemplate := range s.eventTemplates {
+		if !eventTemplate.hasEvent() {
+			continue
+		}
+
+		if s.summary != nil && s.summary.opts.SuppressStageEvents && strings.HasPrefix(hookType, "orca:stage:") {
+			continue
+		}
+
 		hs[hookType] = []spinnaker.Handler{
 			&DatadogEventHandler{spout: s, template: eventTemplate},
 		}
 	}
 
+	if s.summary != nil {
+		for _, hookType := range s.summary.HookTypes() {
+			hs[hookType] = append(hs[hookType], s.summary)
+		}
+	}
+
+	if s.judgments != nil {
+		for _, hookType := range s.judgments.HookTypes() {
+			hs[hookType] = append(hs[hookType], s.judgments)
+		}
+	}
+
+	if s.canary != nil {
+		for _, hookType := range s.canary.HookTypes() {
+			hs[hookType] = append(hs[hookType], s.canary)
+		}
+	}
+
 	return hs
 }
 
 // AttachToDispatcher registers all of the handlers for this spout to a spinnaker
 // dispatcher.
 func (s *Spout) AttachToDispatcher(d *spinnaker.Dispatcher) {
-	for hookType, handlers := range s.Handlers() {
-		for _, handler := range handlers {
-			d.AddHandler(hookType, handler)
-		}
-	}
+	d.AddHandlers(s.Handlers())
 }
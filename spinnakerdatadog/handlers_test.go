@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/templates"
 	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
 	spinnakerdatadog "github.com/bobbytables/spinnaker-datadog-bridge/spinnakerdatadog"
 	"github.com/stretchr/testify/assert"
@@ -56,10 +57,12 @@ func TestEventDispatcherSendsDataDogFormattedEvents(t *testing.T) {
 		done <- json.NewDecoder(req.Body).Decode(&event)
 	})
 	ts := httptest.NewServer(mux)
-	os.Setenv("DATADOG_HOST", ts.URL)
-	defer os.Unsetenv("DATADOG_HOST")
+	defer ts.Close()
 
-	spout, _ := spinnakerdatadog.NewSpout(datadog.NewClient("", ""), "")
+	org := spinnakerdatadog.NewOrganization(spinnakerdatadog.DefaultOrganization, "", "")
+	org.Connect(ts.URL, ts.Client())
+
+	spout, _ := spinnakerdatadog.NewSpout(org.Client(), "")
 	template := &spinnakerdatadog.EventTemplate{
 		Title: "{{ .Details.Application }} doing something",
 		Text:  "{{ .Content.ExecutionID }} is the execution id",
@@ -79,7 +82,118 @@ func TestEventDispatcherSendsDataDogFormattedEvents(t *testing.T) {
 
 		assert.Equal(t, "someapp doing something", event.GetTitle())
 		assert.Equal(t, "someid is the execution id", event.GetText())
+		assert.Empty(t, event.GetUrl())
 	case <-time.After(time.Millisecond * 100):
 		t.Error("timed out waiting for webhook call")
 	}
 }
+
+func TestEventTemplateRendering(t *testing.T) {
+	incoming := &types.IncomingWebhook{
+		Details: types.Details{Application: "bob's <app> & co", Type: "orca:stage:complete"},
+		Content: types.Content{ExecutionID: "someid"},
+	}
+
+	tests := []struct {
+		scenario     string
+		template     *spinnakerdatadog.EventTemplate
+		title        string
+		text         string
+		datadogText  string
+		wantCompiled bool
+	}{
+		{
+			scenario:    "Values aren't HTML escaped by default",
+			template:    &spinnakerdatadog.EventTemplate{Title: "{{ .Details.Application }} deployed", Text: "<b>{{ .Details.Application }}</b>"},
+			title:       "bob's <app> & co deployed",
+			text:        "<b>bob's <app> & co</b>",
+			datadogText: "<b>bob's <app> & co</b>",
+		},
+		{
+			scenario: "HTML escaping can be turned on per field",
+			template: &spinnakerdatadog.EventTemplate{
+				Title:  "{{ .Details.Application }}",
+				Text:   "<b>{{ .Details.Application }}</b>",
+				Escape: spinnakerdatadog.EscapeConfig{Text: "html"},
+			},
+			title:       "bob's <app> & co",
+			text:        "<b>bob&#39;s &lt;app&gt; &amp; co</b>",
+			datadogText: "<b>bob&#39;s &lt;app&gt; &amp; co</b>",
+		},
+		{
+			scenario: "Markdown text is escaped and wrapped for Datadog",
+			template: &spinnakerdatadog.EventTemplate{
+				Title:    "{{ .Details.Application }}",
+				Text:     "{{ markdownHeader \"App\" \"Execution\" }}\n{{ markdownRow .Details.Application .Content.ExecutionID }}\n{{ .Details.Application }}",
+				Markdown: true,
+			},
+			title:       "bob's <app> & co",
+			text:        "| App | Execution |\n| --- | --- |\n| bob's \\<app\\> & co | someid |\nbob's \\<app\\> & co",
+			datadogText: "%%% \n| App | Execution |\n| --- | --- |\n| bob's \\<app\\> & co | someid |\nbob's \\<app\\> & co\n %%%",
+		},
+		{
+			scenario: "Markdown escaping can be turned off",
+			template: &spinnakerdatadog.EventTemplate{
+				Text:     "{{ markdownLink .Details.Type \"https://spinnaker.example.com\" }}",
+				Markdown: true,
+				Escape:   spinnakerdatadog.EscapeConfig{Text: "none"},
+			},
+			text:        "[orca:stage:complete](https://spinnaker.example.com)",
+			datadogText: "%%% \n[orca:stage:complete](https://spinnaker.example.com)\n %%%",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			title, text, err := test.template.Render(incoming)
+			require.NoError(t, err)
+			assert.Equal(t, test.title, title)
+			assert.Equal(t, test.text, text)
+			assert.Equal(t, test.datadogText, test.template.DatadogText(text))
+		})
+	}
+
+	t.Run("Unknown escape modes fail to compile", func(t *testing.T) {
+		template := &spinnakerdatadog.EventTemplate{Title: "{{ .Details.Application }}", Escape: spinnakerdatadog.EscapeConfig{Title: "shell"}}
+		require.Error(t, template.Compile())
+	})
+}
+
+func TestEventsLinkToDeck(t *testing.T) {
+	var event datadog.Event
+	done := make(chan error, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		done <- json.NewDecoder(req.Body).Decode(&event)
+	}))
+	defer ts.Close()
+
+	org := spinnakerdatadog.NewOrganization(spinnakerdatadog.DefaultOrganization, "", "")
+	org.Connect(ts.URL, ts.Client())
+	file, err := templates.ParseFile(nil)
+	require.NoError(t, err)
+	spout, err := spinnakerdatadog.NewSpoutFromFile(org.Client(), file, "https://spinnaker.example.com")
+	require.NoError(t, err)
+
+	handler := spinnakerdatadog.NewDatadogEventHandler(spout, &spinnakerdatadog.EventTemplate{
+		Title:    "{{ .Details.Application }} deployed",
+		Text:     "Execution {{ .Content.ExecutionID }}",
+		Markdown: true,
+		DeckLink: true,
+		DeckURL:  "https://spinnaker.example.com",
+	})
+	require.NoError(t, handler.Handle(&types.IncomingWebhook{
+		Details: types.Details{Application: "someapp"},
+		Content: types.Content{ExecutionID: "someid"},
+	}))
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+
+		link := "https://spinnaker.example.com/#/applications/someapp/executions/details/someid"
+		assert.Equal(t, link, event.GetUrl())
+		assert.Equal(t, "%%% \nExecution someid\n\n[View in Spinnaker]("+link+")\n %%%", event.GetText())
+	case <-time.After(time.Millisecond * 100):
+		t.Error("timed out waiting for event call")
+	}
+}
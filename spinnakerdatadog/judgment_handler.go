@@ -0,0 +1,476 @@
+package spinnakerdatadog
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	datadog "gopkg.in/zorkian/go-datadog-api.v2"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/templates"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+)
+
+const (
+	defaultJudgmentMaxAge = time.Hour * 24 * 7
+	// judgmentStageType is the type of manual judgment stages in the stage
+	// details of stage webhooks
+	judgmentStageType = "manualJudgment"
+)
+
+// The outcomes of a manual judgment
+const (
+	JudgmentContinue = "continue"
+	JudgmentStop     = "stop"
+	JudgmentCanceled = "canceled"
+)
+
+// judgmentHookTypes are the manual judgment notifications and the stage
+// webhooks, echo only sends the notifications when the stage is configured
+// to notify so the stage webhooks of manual judgment stages are used as well
+var judgmentHookTypes = []string{
+	types.HookTypeManualJudgment, types.HookTypeManualJudgmentContinue, types.HookTypeManualJudgmentStop,
+	types.HookTypeStageStarting, types.HookTypeStageComplete, types.HookTypeStageFailed,
+}
+
+// JudgmentOptions configures manual judgment tracking
+type JudgmentOptions struct {
+	// ReminderInterval is how long a judgment waits before a reminder event
+	// is sent, and then how long between reminders. Zero disables reminders.
+	ReminderInterval time.Duration
+	// MaxAge is how long judgments are tracked when they are never judged,
+	// defaults to 7 days
+	MaxAge time.Duration
+}
+
+// ManualJudgmentHandler tracks manual judgment stages. It sends an event with
+// who can judge the stage when it starts waiting, reminder events while it is
+// still waiting, and records how long it waited once judged:
+//
+//	spinnaker.manual_judgment.wait_time distribution of waits in seconds
+//	spinnaker.manual_judgment.judgments count of judgments
+//
+// Both metrics are tagged with the application, pipeline, stage, outcome
+// (continue, stop or canceled) and the user who judged the stage.
+type ManualJudgmentHandler struct {
+	spout      *Spout
+	aggregator *MetricAggregator
+	opts       JudgmentOptions
+
+	mu        sync.Mutex
+	judgments map[string]*pendingJudgment
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+var _ spinnaker.Handler = (*ManualJudgmentHandler)(nil)
+
+// pendingJudgment is a manual judgment stage the handler has seen
+type pendingJudgment struct {
+	incoming *types.IncomingWebhook
+	judgment *judgmentWebhook
+	// requested is when the stage started waiting, notified when the last
+	// event was sent and updated when the judgment was last changed
+	requested time.Time
+	notified  time.Time
+	updated   time.Time
+	// resolved judgments are kept until they're evicted or the stage is
+	// restarted, so the notification and the stage webhook of a judgment
+	// are only counted once
+	resolved bool
+}
+
+// judgmentWebhook is a manual judgment notification or a stage webhook of a
+// manual judgment stage
+type judgmentWebhook struct {
+	stage        string
+	instructions string
+	roles        []string
+	inputs       []string
+	// resolved is true once the stage was judged (or canceled)
+	resolved bool
+	outcome  string
+	input    string
+	user     string
+	// at is when the judgment was requested or resolved, start when the
+	// stage started if the webhook has it
+	at    time.Time
+	start time.Time
+}
+
+// NewManualJudgmentHandler initializes a manual judgment handler. Metrics are
+// recorded in the aggregator unless it is nil. When reminders are enabled
+// Close must be called to stop sending them.
+func NewManualJudgmentHandler(s *Spout, aggregator *MetricAggregator, opts JudgmentOptions) *ManualJudgmentHandler {
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = defaultJudgmentMaxAge
+	}
+
+	mjh := &ManualJudgmentHandler{
+		spout:      s,
+		aggregator: aggregator,
+		opts:       opts,
+		judgments:  make(map[string]*pendingJudgment),
+	}
+
+	if opts.ReminderInterval > 0 {
+		mjh.stop = make(chan struct{})
+		mjh.done = make(chan struct{})
+		go mjh.remindPeriodically()
+	}
+
+	return mjh
+}
+
+// Name implements spinnaker.Handler
+func (mjh *ManualJudgmentHandler) Name() string {
+	return "ManualJudgmentHandler"
+}
+
+// HookTypes returns the hook types the handler needs to be attached to
+func (mjh *ManualJudgmentHandler) HookTypes() []string {
+	return append([]string{}, judgmentHookTypes...)
+}
+
+// Handle implements spinnaker.Handler, webhooks of other stages are ignored
+func (mjh *ManualJudgmentHandler) Handle(incoming *types.IncomingWebhook) error {
+	judgment, err := parseJudgment(incoming)
+	if err != nil || judgment == nil {
+		return err
+	}
+
+	key := incoming.Content.ExecutionID + "/" + judgment.stage
+	if judgment.resolved {
+		mjh.resolve(key, incoming, judgment)
+		return nil
+	}
+
+	return mjh.request(key, incoming, judgment)
+}
+
+// parseJudgment returns the judgment of the webhook, or nil when it isn't
+// about a manual judgment stage
+func parseJudgment(incoming *types.IncomingWebhook) (*judgmentWebhook, error) {
+	payload, err := incoming.Payload()
+	if err != nil {
+		return nil, err
+	}
+
+	var judgment *judgmentWebhook
+	switch content := payload.(type) {
+	case *types.ManualJudgmentContent:
+		judgment = &judgmentWebhook{
+			stage:        content.StageName,
+			instructions: content.Instructions,
+			roles:        content.SelectedStageRoles,
+			inputs:       judgmentInputValues(content.JudgmentInputs),
+			input:        content.JudgmentInput,
+			user:         content.LastModifiedBy,
+			at:           incoming.Details.Created.Time,
+		}
+
+		switch incoming.Details.Type {
+		case types.HookTypeManualJudgmentContinue:
+			judgment.resolved, judgment.outcome = true, JudgmentContinue
+		case types.HookTypeManualJudgmentStop:
+			judgment.resolved, judgment.outcome = true, JudgmentStop
+		}
+
+		if content.Execution != nil {
+			for _, stage := range content.Execution.Stages {
+				if stage.ID == content.StageID || (content.StageID == "" && stage.Name == content.StageName) {
+					judgment.start = stage.StartTime.Time
+					break
+				}
+			}
+		}
+	case *types.StageContent:
+		if content.Context.StageDetails().Type != judgmentStageType {
+			return nil, nil
+		}
+
+		var context struct {
+			Instructions       string                `json:"instructions"`
+			JudgmentInputs     []types.JudgmentInput `json:"judgmentInputs"`
+			SelectedStageRoles []string              `json:"selectedStageRoles"`
+			JudgmentStatus     string                `json:"judgmentStatus"`
+			JudgmentInput      string                `json:"judgmentInput"`
+			LastModifiedBy     string                `json:"lastModifiedBy"`
+		}
+		if err := content.Context.Decode(&context); err != nil {
+			return nil, err
+		}
+
+		judgment = &judgmentWebhook{
+			stage:        content.StageName(),
+			instructions: context.Instructions,
+			roles:        context.SelectedStageRoles,
+			inputs:       judgmentInputValues(context.JudgmentInputs),
+			input:        context.JudgmentInput,
+			user:         context.LastModifiedBy,
+			at:           content.StartTime.Time,
+			start:        content.StartTime.Time,
+		}
+
+		if phase := incoming.Phase(); phase != types.PhaseStarting {
+			judgment.resolved = true
+			judgment.at = content.EndTime.Time
+			judgment.outcome = strings.ToLower(context.JudgmentStatus)
+
+			if judgment.outcome == "" {
+				switch {
+				case content.Canceled:
+					judgment.outcome = JudgmentCanceled
+				case phase == types.PhaseFailed:
+					judgment.outcome = JudgmentStop
+				default:
+					judgment.outcome = JudgmentContinue
+				}
+			}
+		}
+	default:
+		return nil, nil
+	}
+
+	if judgment.at.IsZero() {
+		judgment.at = time.Now()
+	}
+
+	return judgment, nil
+}
+
+func judgmentInputValues(inputs []types.JudgmentInput) []string {
+	values := make([]string, 0, len(inputs))
+	for _, input := range inputs {
+		values = append(values, input.Value)
+	}
+
+	return values
+}
+
+// request starts tracking the judgment and sends its event, unless it is
+// already waiting from the other webhook of the judgment. A request for a
+// resolved judgment is a restarted stage and is tracked again.
+func (mjh *ManualJudgmentHandler) request(key string, incoming *types.IncomingWebhook, judgment *judgmentWebhook) error {
+	now := time.Now()
+
+	mjh.mu.Lock()
+	mjh.evict(now)
+	if pending, ok := mjh.judgments[key]; ok && !pending.resolved {
+		mjh.mu.Unlock()
+		return nil
+	}
+
+	pending := &pendingJudgment{
+		incoming:  incoming,
+		judgment:  judgment,
+		requested: judgment.at,
+		notified:  now,
+		updated:   now,
+	}
+	mjh.judgments[key] = pending
+	mjh.mu.Unlock()
+
+	return mjh.post(pending, false, now)
+}
+
+// resolve records the metrics of a judgment. Judgments resolved before they
+// were requested (after a restart for example) use the start of the stage.
+func (mjh *ManualJudgmentHandler) resolve(key string, incoming *types.IncomingWebhook, judgment *judgmentWebhook) {
+	now := time.Now()
+
+	mjh.mu.Lock()
+	mjh.evict(now)
+	pending, ok := mjh.judgments[key]
+	if ok && pending.resolved {
+		mjh.mu.Unlock()
+		return
+	}
+
+	requested := judgment.start
+	if ok {
+		requested = pending.requested
+	}
+	mjh.judgments[key] = &pendingJudgment{resolved: true, updated: now}
+	mjh.mu.Unlock()
+
+	if mjh.aggregator == nil {
+		return
+	}
+
+	org := mjh.spout.Organization(incoming.Details.Application)
+	tags := judgmentTags(incoming, judgment.stage)
+	tags = append(tags, fmt.Sprintf("outcome:%s", judgment.outcome))
+	if judgment.user != "" {
+		tags = append(tags, fmt.Sprintf("judged_by:%s", judgment.user))
+	}
+
+	mjh.aggregator.Count(org, "spinnaker.manual_judgment.judgments", 1, tags...)
+	if !requested.IsZero() && judgment.at.After(requested) {
+		mjh.aggregator.Distribution(org, "spinnaker.manual_judgment.wait_time", judgment.at.Sub(requested).Seconds(), tags...)
+	}
+}
+
+// evict forgets judgments that haven't been updated within the max age, it
+// must be called with the lock held
+func (mjh *ManualJudgmentHandler) evict(now time.Time) {
+	for key, pending := range mjh.judgments {
+		if now.Sub(pending.updated) > mjh.opts.MaxAge {
+			delete(mjh.judgments, key)
+		}
+	}
+}
+
+// Remind sends a reminder for every judgment still waiting ReminderInterval
+// after its last event. It is called periodically when reminders are enabled.
+func (mjh *ManualJudgmentHandler) Remind(now time.Time) {
+	if mjh.opts.ReminderInterval <= 0 {
+		return
+	}
+
+	mjh.mu.Lock()
+	mjh.evict(now)
+	var due []*pendingJudgment
+	for _, pending := range mjh.judgments {
+		if !pending.resolved && now.Sub(pending.notified) >= mjh.opts.ReminderInterval {
+			pending.notified = now
+			due = append(due, pending)
+		}
+	}
+	mjh.mu.Unlock()
+
+	for _, pending := range due {
+		if err := mjh.post(pending, true, now); err != nil {
+			logrus.WithError(err).WithField("stage", pending.judgment.stage).Error("could not send manual judgment reminder")
+		}
+	}
+}
+
+func (mjh *ManualJudgmentHandler) remindPeriodically() {
+	defer close(mjh.done)
+
+	interval := mjh.opts.ReminderInterval
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-mjh.stop:
+			return
+		case now := <-ticker.C:
+			mjh.Remind(now)
+		}
+	}
+}
+
+// Close stops sending reminders, it implements io.Closer
+func (mjh *ManualJudgmentHandler) Close() error {
+	if mjh.stop != nil {
+		close(mjh.stop)
+		<-mjh.done
+	}
+
+	return nil
+}
+
+// post sends the event of a pending judgment
+func (mjh *ManualJudgmentHandler) post(pending *pendingJudgment, reminder bool, now time.Time) error {
+	incoming, judgment := pending.incoming, pending.judgment
+
+	pipeline := incoming.PipelineName()
+	if pipeline == "" {
+		pipeline = incoming.Content.ExecutionID
+	}
+
+	event := &datadog.Event{}
+	if reminder {
+		event.SetTitle(fmt.Sprintf("%s pipeline %s has been waiting %s for manual judgment: %s",
+			incoming.Details.Application, pipeline, templates.HumanizeDuration(now.Sub(pending.requested)), judgment.stage))
+		event.SetAlertType("warning")
+	} else {
+		event.SetTitle(fmt.Sprintf("%s pipeline %s is waiting for manual judgment: %s", incoming.Details.Application, pipeline, judgment.stage))
+		event.SetAlertType("info")
+		// Reminders are sent in real time, only the request is dated
+		mjh.spout.dateEvent(event, incoming)
+	}
+	event.SetText(judgmentText(incoming, judgment, mjh.spout.deckURL))
+	event.SetAggregation(incoming.Content.ExecutionID)
+	if link := judgmentURL(incoming, judgment, mjh.spout.deckURL); link != "" {
+		event.SetUrl(link)
+	}
+	event.Tags = judgmentTags(incoming, judgment.stage)
+
+	if _, err := mjh.spout.Organization(incoming.Details.Application).Client().PostEvent(event); err != nil {
+		return errors.Wrap(err, "could not post manual judgment to datadog API")
+	}
+
+	return nil
+}
+
+func judgmentTags(incoming *types.IncomingWebhook, stage string) []string {
+	tags := []string{
+		fmt.Sprintf("app:%s", incoming.Details.Application),
+		fmt.Sprintf("stage:%s", stage),
+	}
+	if pipeline := incoming.PipelineName(); pipeline != "" {
+		tags = append(tags, fmt.Sprintf("pipeline:%s", pipeline))
+	}
+
+	return tags
+}
+
+// judgmentURL links to the judgment stage in Deck, the notifications name the
+// stage in stageName rather than name
+func judgmentURL(incoming *types.IncomingWebhook, judgment *judgmentWebhook, deckURL string) string {
+	stage := *incoming
+	stage.Content.Name = judgment.stage
+
+	return stage.StageURL(deckURL)
+}
+
+// judgmentText lists the instructions, who can judge and the inputs to
+// choose from
+func judgmentText(incoming *types.IncomingWebhook, judgment *judgmentWebhook, deckURL string) string {
+	buf := new(bytes.Buffer)
+	buf.WriteString(markdownBlock + " \n")
+
+	if judgment.instructions != "" {
+		fmt.Fprintf(buf, "**Instructions:** %s\n\n", templates.MarkdownEscape(judgment.instructions))
+	}
+
+	if len(judgment.roles) > 0 {
+		fmt.Fprintf(buf, "**Who can judge:** %s", templates.MarkdownEscape(strings.Join(judgment.roles, ", ")))
+	} else {
+		fmt.Fprintf(buf, "**Who can judge:** anyone with access to %s", templates.MarkdownEscape(incoming.Details.Application))
+	}
+
+	if len(judgment.inputs) > 0 {
+		fmt.Fprintf(buf, "\n\n**Options:** %s", templates.MarkdownEscape(strings.Join(judgment.inputs, ", ")))
+	}
+
+	if link := judgmentURL(incoming, judgment, deckURL); link != "" {
+		buf.WriteString("\n\n" + string(templates.MarkdownLink("Judge in Spinnaker", link)))
+	}
+
+	buf.WriteString("\n " + markdownBlock)
+
+	return buf.String()
+}
+
+// EnableManualJudgments makes the spout track manual judgments. The returned
+// handler must be closed when reminders are enabled. It must be called before
+// the spout is attached to a dispatcher.
+func (s *Spout) EnableManualJudgments(aggregator *MetricAggregator, opts JudgmentOptions) *ManualJudgmentHandler {
+	s.judgments = NewManualJudgmentHandler(s, aggregator, opts)
+	return s.judgments
+}
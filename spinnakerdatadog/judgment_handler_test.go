@@ -0,0 +1,197 @@
+package spinnakerdatadog_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	datadog "gopkg.in/zorkian/go-datadog-api.v2"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/templates"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+	spinnakerdatadog "github.com/bobbytables/spinnaker-datadog-bridge/spinnakerdatadog"
+)
+
+// loadEvent decodes the fixture of the hook type shared with the spinnaker
+// package
+func loadEvent(t *testing.T, hookType string) *types.IncomingWebhook {
+	wd, _ := os.Getwd()
+	name := strings.Replace(hookType, ":", "-", -1) + ".json"
+	b, err := ioutil.ReadFile(filepath.Join(wd, "..", "spinnaker", "testdata", "events", name))
+	require.NoError(t, err)
+
+	var incoming types.IncomingWebhook
+	require.NoError(t, json.Unmarshal(b, &incoming))
+
+	return &incoming
+}
+
+// judgmentStage is a stage webhook of a manual judgment stage
+func judgmentStage(hookType string, start, end time.Time, context map[string]interface{}) *types.IncomingWebhook {
+	context["stageDetails"] = map[string]interface{}{"name": "Promote to production?", "type": "manualJudgment"}
+	content := map[string]interface{}{
+		"executionId": "01C5Z6VQ1E6QG1F2Y3X4W5V6T7",
+		"startTime":   start.UnixNano() / int64(time.Millisecond),
+		"context":     context,
+		"execution":   map[string]interface{}{"name": "Deploy to production"},
+	}
+	if !end.IsZero() {
+		content["endTime"] = end.UnixNano() / int64(time.Millisecond)
+	}
+
+	var incoming types.IncomingWebhook
+	b, _ := json.Marshal(map[string]interface{}{
+		"details": map[string]interface{}{"type": hookType, "application": "hcm"},
+		"content": content,
+	})
+	json.Unmarshal(b, &incoming)
+
+	return &incoming
+}
+
+// captureJudgments records the events and distributions sent to Datadog
+func captureJudgments(t *testing.T) (*spinnakerdatadog.Spout, chan datadog.Event, chan submittedSeries, func()) {
+	events := make(chan datadog.Event, 10)
+	distributions := make(chan submittedSeries, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/api/v1/events" {
+			var event datadog.Event
+			assert.NoError(t, json.NewDecoder(req.Body).Decode(&event))
+			events <- event
+			return
+		}
+
+		var body struct {
+			Series []submittedSeries `json:"series"`
+		}
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+		if req.URL.Path == "/api/v1/distribution_points" {
+			for _, series := range body.Series {
+				distributions <- series
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	org := spinnakerdatadog.NewOrganization(spinnakerdatadog.DefaultOrganization, "", "")
+	org.Connect(ts.URL, ts.Client())
+	file, err := templates.ParseFile(nil)
+	require.NoError(t, err)
+	spout, err := spinnakerdatadog.NewSpoutFromFile(org.Client(), file, "https://deck.example.com")
+	require.NoError(t, err)
+
+	return spout, events, distributions, ts.Close
+}
+
+func TestManualJudgmentHandler(t *testing.T) {
+	spout, events, distributions, closeServer := captureJudgments(t)
+	defer closeServer()
+
+	aggregator := spinnakerdatadog.NewMetricAggregator(spinnakerdatadog.MetricsOptions{FlushInterval: time.Hour})
+	handler := spout.EnableManualJudgments(aggregator, spinnakerdatadog.JudgmentOptions{ReminderInterval: time.Hour})
+	defer handler.Close()
+
+	requested := loadEvent(t, types.HookTypeManualJudgment)
+	require.NoError(t, handler.Handle(requested))
+
+	event := <-events
+	assert.Equal(t, "hcm pipeline Deploy to production is waiting for manual judgment: Promote to production?", event.GetTitle())
+	assert.Equal(t, "info", event.GetAlertType())
+	assert.Equal(t, "01C5Z6VQ1E6QG1F2Y3X4W5V6T7", event.GetAggregation())
+	assert.Equal(t, "https://deck.example.com/#/applications/hcm/executions/details/01C5Z6VQ1E6QG1F2Y3X4W5V6T7?stage=1", event.GetUrl())
+	assert.Equal(t, []string{"app:hcm", "stage:Promote to production?", "pipeline:Deploy to production"}, event.Tags)
+	assert.Equal(t, "%%% \n"+
+		"**Instructions:** Check the canary dashboard\n\n"+
+		"**Who can judge:** release\\-managers\n\n"+
+		"**Options:** promote, rollback\n\n"+
+		"[Judge in Spinnaker](https://deck.example.com/#/applications/hcm/executions/details/01C5Z6VQ1E6QG1F2Y3X4W5V6T7?stage=1)\n %%%", event.GetText())
+
+	// The stage webhook of the same judgment doesn't send another event
+	start := requested.Details.Created.Time
+	require.NoError(t, handler.Handle(judgmentStage(types.HookTypeStageStarting, start, time.Time{}, map[string]interface{}{})))
+
+	handler.Remind(time.Now().Add(time.Minute))
+	handler.Remind(time.Now().Add(time.Hour * 2))
+
+	event = <-events
+	assert.Contains(t, event.GetTitle(), "hcm pipeline Deploy to production has been waiting")
+	assert.Equal(t, "warning", event.GetAlertType())
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event %q", event.GetTitle())
+	default:
+	}
+
+	// Both the notification and the stage webhook of the judgment arrive, it
+	// is only recorded once
+	judged := loadEvent(t, types.HookTypeManualJudgmentContinue)
+	judged.Details.Created = types.Timestamp{Time: start.Add(time.Minute * 5)}
+	require.NoError(t, handler.Handle(judged))
+	require.NoError(t, handler.Handle(judgmentStage(types.HookTypeStageComplete, start, start.Add(time.Minute*6), map[string]interface{}{
+		"judgmentStatus": "continue",
+		"lastModifiedBy": "jane@example.com",
+	})))
+	require.NoError(t, aggregator.Close())
+
+	series := <-distributions
+	assert.Equal(t, "spinnaker.manual_judgment.wait_time", series.Metric)
+	assert.Equal(t, []interface{}{300.0}, series.Points[0][1])
+	assert.Subset(t, series.Tags, []string{"app:hcm", "outcome:continue", "judged_by:jane@example.com", "stage:Promote to production?"})
+
+	t.Run("Judged stages aren't reminded", func(t *testing.T) {
+		handler.Remind(time.Now().Add(time.Hour * 24))
+		select {
+		case event := <-events:
+			t.Fatalf("unexpected event %q", event.GetTitle())
+		default:
+		}
+	})
+}
+
+func TestManualJudgmentHandlerStageWebhooks(t *testing.T) {
+	spout, events, distributions, closeServer := captureJudgments(t)
+	defer closeServer()
+
+	aggregator := spinnakerdatadog.NewMetricAggregator(spinnakerdatadog.MetricsOptions{FlushInterval: time.Hour})
+	handler := spinnakerdatadog.NewManualJudgmentHandler(spout, aggregator, spinnakerdatadog.JudgmentOptions{})
+
+	start := time.Unix(1518214000, 0)
+	require.NoError(t, handler.Handle(judgmentStage(types.HookTypeStageStarting, start, time.Time{}, map[string]interface{}{})))
+
+	event := <-events
+	assert.Contains(t, event.GetText(), "**Who can judge:** anyone with access to hcm")
+
+	t.Run("Other stages are ignored", func(t *testing.T) {
+		require.NoError(t, handler.Handle(stageWebhook(types.HookTypeStageStarting, "Deploy", start, 0)))
+		select {
+		case event := <-events:
+			t.Fatalf("unexpected event %q", event.GetTitle())
+		default:
+		}
+	})
+
+	// Failed stages without a judgment status were stopped
+	require.NoError(t, handler.Handle(judgmentStage(types.HookTypeStageFailed, start, start.Add(time.Minute), map[string]interface{}{})))
+	require.NoError(t, aggregator.Close())
+
+	series := <-distributions
+	assert.Equal(t, []interface{}{60.0}, series.Points[0][1])
+	assert.Contains(t, series.Tags, "outcome:stop")
+	assert.NotContains(t, strings.Join(series.Tags, ","), "judged_by")
+
+	t.Run("Restarted stages wait for judgment again", func(t *testing.T) {
+		restart := start.Add(time.Hour)
+		require.NoError(t, handler.Handle(judgmentStage(types.HookTypeStageStarting, restart, time.Time{}, map[string]interface{}{})))
+
+		event := <-events
+		assert.Equal(t, "hcm pipeline Deploy to production is waiting for manual judgment: Promote to production?", event.GetTitle())
+	})
+}
@@ -0,0 +1,61 @@
+package spinnakerdatadog
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+)
+
+// KayentaClient reads the judgment of canary executions from the Kayenta API,
+// orca doesn't put the scores of metric groups and the classification of
+// each metric in the stage context
+type KayentaClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewKayentaClient initializes a client of the Kayenta API at the given base
+// URL, such as http://kayenta:8090
+func NewKayentaClient(baseURL string, client *http.Client) *KayentaClient {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &KayentaClient{baseURL: strings.TrimSuffix(baseURL, "/"), client: client}
+}
+
+// JudgeResult returns Kayenta's judgment of the canary execution, it is nil
+// when the execution has no result yet
+func (kc *KayentaClient) JudgeResult(canaryExecutionID string) (*types.CanaryJudgeResult, error) {
+	resp, err := kc.client.Get(kc.baseURL + "/canary/" + url.PathEscape(canaryExecutionID))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get canary execution from kayenta")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil, errors.Errorf("kayenta responded with %d for canary execution %s", resp.StatusCode, canaryExecutionID)
+	}
+
+	var execution struct {
+		Result *struct {
+			JudgeResult *types.CanaryJudgeResult `json:"judgeResult"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&execution); err != nil {
+		return nil, errors.Wrap(err, "could not decode canary execution")
+	}
+	if execution.Result == nil {
+		return nil, nil
+	}
+
+	return execution.Result.JudgeResult, nil
+}
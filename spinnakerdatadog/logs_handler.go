@@ -0,0 +1,394 @@
+package spinnakerdatadog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+)
+
+// DefaultLogsIntakeURL is the Datadog HTTP logs intake endpoint for the US1 site
+const DefaultLogsIntakeURL = "https://http-intake.logs.datadoghq.com/api/v2/logs"
+
+const (
+	defaultLogsSource        = "spinnaker"
+	defaultLogsBatchSize     = 100
+	defaultLogsFlushInterval = time.Second * 5
+	// defaultLogsMaxBatches is how many batches are kept for retry by default
+	defaultLogsMaxBatches = 10
+	// maxLogsBatchEntries and maxLogsBatchBytes are the most logs and
+	// uncompressed bytes the intake accepts in a single request
+	maxLogsBatchEntries = 1000
+	maxLogsBatchBytes   = 5 * 1024 * 1024
+)
+
+// LogsOptions configures where and how a DatadogLogsHandler ships logs
+type LogsOptions struct {
+	// APIKey is sent as the DD-API-KEY header on intake requests for
+	// applications that aren't routed to an organization with its own key
+	APIKey string
+	// IntakeURL defaults to DefaultLogsIntakeURL
+	IntakeURL string
+	// Source is used as the ddsource attribute, defaults to "spinnaker"
+	Source string
+	// Tags are appended to the ddtags generated for every webhook
+	Tags []string
+	// TemplatedOnly only sends webhooks that have an event template registered
+	// for their hook type
+	TemplatedOnly bool
+	// BatchSize is how many logs are buffered before they are sent, defaults to 100
+	BatchSize int
+	// FlushInterval is how often buffered logs are sent regardless of the
+	// batch size, defaults to 5 seconds
+	FlushInterval time.Duration
+	// MaxBuffered is how many logs are kept to be sent again when the intake
+	// fails, defaults to 10 batches. Older logs are dropped past it.
+	MaxBuffered int
+	// HTTPClient defaults to http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// DatadogLogsHandler sends every webhook it handles as a structured log to the
+// Datadog HTTP logs intake. Logs are buffered and sent in the background in
+// gzipped batches either when the batch size is reached or on every flush
+// interval. Batches are split at the limits of the intake.
+type DatadogLogsHandler struct {
+	spout *Spout
+	opts  LogsOptions
+
+	mu sync.Mutex
+	// buffer contains the logs waiting to be sent keyed by the API key of the
+	// organization they are routed to
+	buffer map[string][]*logEntry
+	size   int
+	// dropped counts the logs that could not be sent
+	dropped int64
+
+	// full asks the flushing goroutine to flush when the batch size is reached
+	full      chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var _ spinnaker.Handler = (*DatadogLogsHandler)(nil)
+
+// logEntry is a single log in the format the Datadog logs intake accepts
+type logEntry struct {
+	Source    string                 `json:"ddsource"`
+	Service   string                 `json:"service"`
+	Tags      string                 `json:"ddtags"`
+	Message   string                 `json:"message"`
+	Spinnaker *types.IncomingWebhook `json:"spinnaker"`
+}
+
+// NewDatadogLogsHandler initializes a datadog logs handler and starts flushing
+// it periodically. Close must be called to flush any remaining logs.
+func NewDatadogLogsHandler(s *Spout, opts LogsOptions) *DatadogLogsHandler {
+	if opts.IntakeURL == "" {
+		opts.IntakeURL = DefaultLogsIntakeURL
+	}
+	if opts.Source == "" {
+		opts.Source = defaultLogsSource
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultLogsBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultLogsFlushInterval
+	}
+	if opts.MaxBuffered <= 0 {
+		opts.MaxBuffered = opts.BatchSize * defaultLogsMaxBatches
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+
+	h := &DatadogLogsHandler{
+		spout:  s,
+		opts:   opts,
+		buffer: make(map[string][]*logEntry),
+		full:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go h.flushPeriodically()
+
+	return h
+}
+
+// Name implements spinnaker.Handler
+func (dlh *DatadogLogsHandler) Name() string {
+	return "DatadogLogsHandler"
+}
+
+// Handle implements spinnaker.Handler. It buffers a log for the given webhook,
+// the batch is sent in the background when it is full.
+func (dlh *DatadogLogsHandler) Handle(incoming *types.IncomingWebhook) error {
+	var template *EventTemplate
+	apiKey := dlh.opts.APIKey
+	if dlh.spout != nil {
+		template = dlh.spout.Template(incoming.Details.Type)
+		if org := dlh.spout.Organization(incoming.Details.Application); org != nil && org.APIKey != "" {
+			apiKey = org.APIKey
+		}
+	}
+	if template == nil && dlh.opts.TemplatedOnly {
+		return nil
+	}
+
+	message := fmt.Sprintf("%s %s", incoming.Details.Application, incoming.Details.Type)
+	if template != nil {
+		title, text, err := template.Render(incoming)
+		if err != nil {
+			return err
+		}
+		message = strings.TrimSpace(title + "\n" + text)
+	}
+
+	tags := append([]string{
+		fmt.Sprintf("app:%s", incoming.Details.Application),
+		fmt.Sprintf("hook_type:%s", incoming.Details.Type),
+	}, dlh.opts.Tags...)
+
+	dlh.mu.Lock()
+	dlh.buffer[apiKey] = append(dlh.buffer[apiKey], &logEntry{
+		Source:    dlh.opts.Source,
+		Service:   incoming.Details.Application,
+		Tags:      strings.Join(tags, ","),
+		Message:   message,
+		Spinnaker: incoming,
+	})
+	dlh.size++
+	full := dlh.size >= dlh.opts.BatchSize
+	dlh.mu.Unlock()
+
+	if full {
+		select {
+		case dlh.full <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Flush sends all of the buffered logs to the intake. Batches that fail with
+// a network error, a 429 or a 5xx are buffered again for the next flush and
+// logged, other failures are dropped. Only the error of the last dropped batch
+// is returned.
+func (dlh *DatadogLogsHandler) Flush() error {
+	dlh.mu.Lock()
+	buffered := dlh.buffer
+	dlh.buffer = make(map[string][]*logEntry)
+	dlh.size = 0
+	dlh.mu.Unlock()
+
+	var lastErr error
+	for apiKey, entries := range buffered {
+		for _, batch := range dlh.split(entries) {
+			err := dlh.send(apiKey, batch.body)
+			if err == nil {
+				continue
+			}
+
+			if retryable(err) {
+				logrus.WithError(err).WithField("logs", len(batch.entries)).Warn("could not send logs to datadog, they will be sent again")
+				dlh.requeue(apiKey, batch.entries)
+			} else {
+				lastErr = err
+				dlh.drop(len(batch.entries))
+			}
+		}
+	}
+
+	return lastErr
+}
+
+// logsBatch is a part of the logs of an organization that fits in a single
+// intake request, body is their uncompressed JSON array
+type logsBatch struct {
+	entries []*logEntry
+	body    []byte
+}
+
+// split encodes the logs in batches of at most maxLogsBatchEntries logs and
+// maxLogsBatchBytes bytes. Logs that can't be encoded are dropped.
+func (dlh *DatadogLogsHandler) split(entries []*logEntry) []logsBatch {
+	var batches []logsBatch
+	current := logsBatch{body: []byte("[")}
+	for _, entry := range entries {
+		b, err := json.Marshal(entry)
+		if err != nil {
+			logrus.WithError(err).Error("could not encode log")
+			dlh.drop(1)
+			continue
+		}
+
+		full := len(current.entries) == maxLogsBatchEntries || len(current.body)+len(b)+1 > maxLogsBatchBytes
+		if len(current.entries) > 0 && full {
+			batches = append(batches, current)
+			current = logsBatch{body: []byte("[")}
+		}
+
+		if len(current.entries) > 0 {
+			current.body = append(current.body, ',')
+		}
+		current.body = append(current.body, b...)
+		current.entries = append(current.entries, entry)
+	}
+
+	if len(current.entries) > 0 {
+		batches = append(batches, current)
+	}
+	for i := range batches {
+		batches[i].body = append(batches[i].body, ']')
+	}
+
+	return batches
+}
+
+// requeue puts the logs of a failed batch in front of the buffer, the oldest
+// logs past MaxBuffered are dropped
+func (dlh *DatadogLogsHandler) requeue(apiKey string, entries []*logEntry) {
+	dlh.mu.Lock()
+	defer dlh.mu.Unlock()
+
+	room := dlh.opts.MaxBuffered - dlh.size
+	if room < 0 {
+		room = 0
+	}
+	if len(entries) > room {
+		dlh.dropped += int64(len(entries) - room)
+		entries = entries[len(entries)-room:]
+	}
+
+	dlh.buffer[apiKey] = append(entries[:len(entries):len(entries)], dlh.buffer[apiKey]...)
+	dlh.size += len(entries)
+}
+
+func (dlh *DatadogLogsHandler) drop(n int) {
+	dlh.mu.Lock()
+	dlh.dropped += int64(n)
+	dlh.mu.Unlock()
+}
+
+// Dropped returns how many logs could not be sent to the intake
+func (dlh *DatadogLogsHandler) Dropped() int64 {
+	dlh.mu.Lock()
+	defer dlh.mu.Unlock()
+
+	return dlh.dropped
+}
+
+// intakeError is a response of the intake that isn't 2xx
+type intakeError struct {
+	status int
+}
+
+func (ie *intakeError) Error() string {
+	return fmt.Sprintf("datadog logs intake responded with %d", ie.status)
+}
+
+// retryable returns true when sending the batch again may succeed
+func retryable(err error) bool {
+	if ie, ok := errors.Cause(err).(*intakeError); ok {
+		return ie.status == http.StatusTooManyRequests || ie.status >= 500
+	}
+
+	return true
+}
+
+// send posts a single batch of logs to the intake with the given API key
+func (dlh *DatadogLogsHandler) send(apiKey string, logs []byte) error {
+	body := new(bytes.Buffer)
+	gz := gzip.NewWriter(body)
+	if _, err := gz.Write(logs); err != nil {
+		return errors.Wrap(err, "could not compress logs")
+	}
+	if err := gz.Close(); err != nil {
+		return errors.Wrap(err, "could not compress logs")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, dlh.opts.IntakeURL, body)
+	if err != nil {
+		return errors.Wrap(err, "could not build logs intake request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("DD-API-KEY", apiKey)
+
+	resp, err := dlh.opts.HTTPClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "could not send logs to datadog")
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &intakeError{status: resp.StatusCode}
+	}
+
+	return nil
+}
+
+// Close stops the periodic flushing and sends any remaining logs, logs that
+// still can't be sent are dropped. Closing again is a no-op.
+func (dlh *DatadogLogsHandler) Close() error {
+	var err error
+	dlh.closeOnce.Do(func() {
+		close(dlh.stop)
+		<-dlh.done
+
+		err = dlh.Flush()
+
+		dlh.mu.Lock()
+		dlh.dropped += int64(dlh.size)
+		dlh.buffer = make(map[string][]*logEntry)
+		dlh.size = 0
+		dropped := dlh.dropped
+		dlh.mu.Unlock()
+
+		if dropped > 0 {
+			logrus.WithField("dropped", dropped).Warn("some logs could not be sent to datadog")
+		}
+	})
+
+	return err
+}
+
+func (dlh *DatadogLogsHandler) flushPeriodically() {
+	defer close(dlh.done)
+
+	ticker := time.NewTicker(dlh.opts.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			dlh.flush()
+		case <-dlh.full:
+			dlh.flush()
+		case <-dlh.stop:
+			return
+		}
+	}
+}
+
+func (dlh *DatadogLogsHandler) flush() {
+	if err := dlh.Flush(); err != nil {
+		logrus.WithError(err).Error("could not flush logs to datadog")
+	}
+}
@@ -0,0 +1,193 @@
+package spinnakerdatadog_test
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+	spinnakerdatadog "github.com/bobbytables/spinnaker-datadog-bridge/spinnakerdatadog"
+)
+
+type intakeRequest struct {
+	header http.Header
+	logs   []map[string]interface{}
+	err    error
+}
+
+func newIntake(t *testing.T) (*httptest.Server, <-chan intakeRequest) {
+	received := make(chan intakeRequest, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r := intakeRequest{header: req.Header}
+		gz, err := gzip.NewReader(req.Body)
+		if err != nil {
+			r.err = err
+		} else {
+			r.err = json.NewDecoder(gz).Decode(&r.logs)
+		}
+
+		received <- r
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	return ts, received
+}
+
+func TestLogsHandlerSendsBatches(t *testing.T) {
+	ts, received := newIntake(t)
+	defer ts.Close()
+
+	wd, _ := os.Getwd()
+	spout, err := spinnakerdatadog.NewSpout(nil, filepath.Join(wd, "testdata", "template.yml"))
+	require.NoError(t, err)
+
+	handler := spinnakerdatadog.NewDatadogLogsHandler(spout, spinnakerdatadog.LogsOptions{
+		APIKey:    "apikey",
+		IntakeURL: ts.URL,
+		BatchSize: 2,
+		Tags:      []string{"env:test"},
+	})
+	defer handler.Close()
+
+	require.NoError(t, handler.Handle(&types.IncomingWebhook{
+		Details: types.Details{Application: "someapp", Type: "orca:stage:complete"},
+		Content: types.Content{ExecutionID: "someid"},
+	}))
+	require.NoError(t, handler.Handle(&types.IncomingWebhook{
+		Details: types.Details{Application: "otherapp", Type: "orca:pipeline:starting"},
+	}))
+
+	select {
+	case r := <-received:
+		require.NoError(t, r.err)
+		assert.Equal(t, "apikey", r.header.Get("DD-API-KEY"))
+		assert.Equal(t, "gzip", r.header.Get("Content-Encoding"))
+		require.Len(t, r.logs, 2)
+
+		first := r.logs[0]
+		assert.Equal(t, "spinnaker", first["ddsource"])
+		assert.Equal(t, "someapp", first["service"])
+		assert.Equal(t, "app:someapp,hook_type:orca:stage:complete,env:test", first["ddtags"])
+		assert.Contains(t, first["message"], "someapp Stage Completed")
+		assert.Equal(t, "someid", first["spinnaker"].(map[string]interface{})["content"].(map[string]interface{})["executionId"])
+
+		assert.Equal(t, "otherapp orca:pipeline:starting", r.logs[1]["message"])
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for logs intake call")
+	}
+}
+
+func TestLogsHandlerTemplatedOnly(t *testing.T) {
+	ts, received := newIntake(t)
+	defer ts.Close()
+
+	wd, _ := os.Getwd()
+	spout, err := spinnakerdatadog.NewSpout(nil, filepath.Join(wd, "testdata", "template.yml"))
+	require.NoError(t, err)
+
+	handler := spinnakerdatadog.NewDatadogLogsHandler(spout, spinnakerdatadog.LogsOptions{
+		IntakeURL:     ts.URL,
+		TemplatedOnly: true,
+	})
+
+	require.NoError(t, handler.Handle(&types.IncomingWebhook{
+		Details: types.Details{Application: "someapp", Type: "orca:pipeline:starting"},
+	}))
+	require.NoError(t, handler.Handle(&types.IncomingWebhook{
+		Details: types.Details{Application: "someapp", Type: "orca:stage:complete"},
+	}))
+	require.NoError(t, handler.Close())
+
+	select {
+	case r := <-received:
+		require.NoError(t, r.err)
+		require.Len(t, r.logs, 1)
+		assert.Equal(t, "app:someapp,hook_type:orca:stage:complete", r.logs[0]["ddtags"])
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for logs intake call")
+	}
+}
+
+func TestLogsHandlerRetriesFailedBatches(t *testing.T) {
+	statuses := make(chan int, 3)
+	statuses <- http.StatusServiceUnavailable
+	statuses <- http.StatusBadRequest
+	received := make(chan int, 3)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var logs []map[string]interface{}
+		gz, err := gzip.NewReader(req.Body)
+		require.NoError(t, err)
+		require.NoError(t, json.NewDecoder(gz).Decode(&logs))
+		received <- len(logs)
+
+		select {
+		case status := <-statuses:
+			w.WriteHeader(status)
+		default:
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer ts.Close()
+
+	handler := spinnakerdatadog.NewDatadogLogsHandler(nil, spinnakerdatadog.LogsOptions{
+		IntakeURL:     ts.URL,
+		FlushInterval: time.Hour,
+	})
+
+	webhook := &types.IncomingWebhook{Details: types.Details{Application: "someapp", Type: "orca:pipeline:starting"}}
+	require.NoError(t, handler.Handle(webhook))
+
+	// 503s are sent again with the next flush, they aren't errors
+	require.NoError(t, handler.Flush())
+	require.NoError(t, handler.Handle(webhook))
+
+	// 400s are dropped
+	require.Error(t, handler.Flush())
+	assert.Equal(t, int64(2), handler.Dropped())
+
+	require.NoError(t, handler.Handle(webhook))
+	require.NoError(t, handler.Close())
+	require.NoError(t, handler.Close(), "closing again is a no-op")
+
+	assert.Equal(t, []int{1, 2, 1}, []int{<-received, <-received, <-received})
+}
+
+func TestLogsHandlerSplitsBatches(t *testing.T) {
+	ts, received := newIntake(t)
+	defer ts.Close()
+
+	handler := spinnakerdatadog.NewDatadogLogsHandler(nil, spinnakerdatadog.LogsOptions{
+		IntakeURL:     ts.URL,
+		BatchSize:     5000,
+		FlushInterval: time.Hour,
+	})
+
+	webhook := &types.IncomingWebhook{Details: types.Details{Application: "someapp", Type: "orca:pipeline:starting"}}
+	for i := 0; i < 1500; i++ {
+		require.NoError(t, handler.Handle(webhook))
+	}
+
+	// Every log repeats the application 4 times, so 4 of them are over 4MB
+	large := &types.IncomingWebhook{Details: types.Details{Application: strings.Repeat("a", 300*1024), Type: "orca:pipeline:starting"}}
+	for i := 0; i < 5; i++ {
+		require.NoError(t, handler.Handle(large))
+	}
+	require.NoError(t, handler.Close())
+
+	var sizes []int
+	for len(received) > 0 {
+		r := <-received
+		require.NoError(t, r.err)
+		sizes = append(sizes, len(r.logs))
+	}
+	assert.Equal(t, []int{1000, 504, 1}, sizes)
+}
@@ -0,0 +1,344 @@
+package spinnakerdatadog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	datadog "gopkg.in/zorkian/go-datadog-api.v2"
+)
+
+// MetricType is how the points of a metric are combined between flushes
+type MetricType string
+
+// The metric types the aggregator supports
+const (
+	// MetricCount sums every point and submits the total
+	MetricCount MetricType = "count"
+	// MetricGauge submits the last point
+	MetricGauge MetricType = "gauge"
+	// MetricRate sums every point and submits the total per second
+	MetricRate MetricType = "rate"
+	// MetricDistribution submits every point so Datadog can compute
+	// percentiles across hosts
+	MetricDistribution MetricType = "distribution"
+)
+
+const (
+	defaultMetricsFlushInterval = time.Second * 10
+	defaultMetricsMaxSeries     = 500
+	// maxMetricsAttempts is how many flushes a series is submitted with before
+	// it is dropped, when Datadog keeps failing
+	maxMetricsAttempts = 3
+)
+
+// MetricsOptions configures a MetricAggregator
+type MetricsOptions struct {
+	// FlushInterval is how often series are submitted, defaults to 10 seconds
+	FlushInterval time.Duration
+	// MaxSeries is how many series are buffered before they are submitted
+	// regardless of the flush interval, defaults to 500
+	MaxSeries int
+	// Tags are added to every series
+	Tags []string
+}
+
+// MetricAggregator buffers metric points in process and submits them as a
+// single series request per organization on every flush
+type MetricAggregator struct {
+	opts MetricsOptions
+
+	mu     sync.Mutex
+	series map[string]*series
+	// dropped counts the series that could not be submitted
+	dropped int64
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// series are the points buffered for a metric name and set of tags
+type series struct {
+	key    string
+	org    *Organization
+	name   string
+	typ    MetricType
+	tags   []string
+	value  float64
+	values []float64
+	// attempts counts the flushes that failed to submit the series
+	attempts int
+}
+
+// NewMetricAggregator initializes an aggregator and starts flushing it
+// periodically. Close must be called to submit any remaining series.
+func NewMetricAggregator(opts MetricsOptions) *MetricAggregator {
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultMetricsFlushInterval
+	}
+	if opts.MaxSeries <= 0 {
+		opts.MaxSeries = defaultMetricsMaxSeries
+	}
+
+	ma := &MetricAggregator{
+		opts:   opts,
+		series: make(map[string]*series),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go ma.flushPeriodically()
+
+	return ma
+}
+
+// Count adds to a count metric
+func (ma *MetricAggregator) Count(org *Organization, name string, value float64, tags ...string) {
+	ma.add(org, MetricCount, name, value, tags)
+}
+
+// Gauge sets a gauge metric
+func (ma *MetricAggregator) Gauge(org *Organization, name string, value float64, tags ...string) {
+	ma.add(org, MetricGauge, name, value, tags)
+}
+
+// Rate adds to a rate metric
+func (ma *MetricAggregator) Rate(org *Organization, name string, value float64, tags ...string) {
+	ma.add(org, MetricRate, name, value, tags)
+}
+
+// Distribution records a point of a distribution metric
+func (ma *MetricAggregator) Distribution(org *Organization, name string, value float64, tags ...string) {
+	ma.add(org, MetricDistribution, name, value, tags)
+}
+
+func (ma *MetricAggregator) add(org *Organization, typ MetricType, name string, value float64, tags []string) {
+	tags = append(append([]string{}, tags...), ma.opts.Tags...)
+	sort.Strings(tags)
+	key := strings.Join([]string{org.Name, string(typ), name, strings.Join(tags, ",")}, "|")
+
+	ma.mu.Lock()
+	s, ok := ma.series[key]
+	if !ok {
+		s = &series{key: key, org: org, name: name, typ: typ, tags: tags}
+		ma.series[key] = s
+	}
+
+	switch typ {
+	case MetricGauge:
+		s.value = value
+	case MetricDistribution:
+		s.values = append(s.values, value)
+	default:
+		s.value += value
+	}
+
+	full := len(ma.series) >= ma.opts.MaxSeries
+	ma.mu.Unlock()
+
+	if full {
+		if err := ma.Flush(); err != nil {
+			logrus.WithError(err).Error("could not flush metrics to datadog")
+		}
+	}
+}
+
+// Flush submits every buffered series. Series of requests that fail are
+// buffered again for the next flush, until they failed maxMetricsAttempts
+// times.
+func (ma *MetricAggregator) Flush() error {
+	ma.mu.Lock()
+	buffered := ma.series
+	ma.series = make(map[string]*series)
+	ma.mu.Unlock()
+
+	if len(buffered) == 0 {
+		return nil
+	}
+
+	now := float64(time.Now().Unix())
+	interval := int(ma.opts.FlushInterval.Seconds())
+	if interval < 1 {
+		interval = 1
+	}
+
+	orgs := make(map[string]*Organization)
+	metrics := make(map[string][]datadog.Metric)
+	distributions := make(map[string][]distributionSeries)
+	// The buffered series of every request, to requeue them when it fails
+	metricsSeries := make(map[string][]*series)
+	distributionsSeries := make(map[string][]*series)
+	for _, s := range buffered {
+		orgs[s.org.Name] = s.org
+
+		if s.typ == MetricDistribution {
+			distributionsSeries[s.org.Name] = append(distributionsSeries[s.org.Name], s)
+			distributions[s.org.Name] = append(distributions[s.org.Name], distributionSeries{
+				Metric: s.name,
+				Type:   string(MetricDistribution),
+				Tags:   s.tags,
+				Points: [][]interface{}{{now, s.values}},
+			})
+			continue
+		}
+
+		value := s.value
+		if s.typ == MetricRate {
+			value = value / float64(interval)
+		}
+
+		metric := datadog.Metric{Tags: s.tags, Points: []datadog.DataPoint{{&now, &value}}}
+		metric.SetMetric(s.name)
+		metric.SetType(string(s.typ))
+		if s.typ != MetricGauge {
+			metric.SetInterval(interval)
+		}
+		metricsSeries[s.org.Name] = append(metricsSeries[s.org.Name], s)
+		metrics[s.org.Name] = append(metrics[s.org.Name], metric)
+	}
+
+	var lastErr error
+	for name, org := range orgs {
+		if len(metrics[name]) > 0 {
+			if err := org.Client().PostMetrics(metrics[name]); err != nil {
+				lastErr = errors.Wrapf(err, "could not post metrics for organization %s", name)
+				ma.requeue(metricsSeries[name])
+			}
+		}
+
+		if len(distributions[name]) > 0 {
+			if err := postDistributions(org, distributions[name]); err != nil {
+				lastErr = errors.Wrapf(err, "could not post distributions for organization %s", name)
+				ma.requeue(distributionsSeries[name])
+			}
+		}
+	}
+
+	return lastErr
+}
+
+// requeue buffers the series of a failed request again, merged with the
+// points recorded since they were flushed. Series that failed too many times
+// are dropped.
+func (ma *MetricAggregator) requeue(failed []*series) {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+
+	var dropped int64
+	for _, s := range failed {
+		s.attempts++
+		if s.attempts >= maxMetricsAttempts {
+			dropped++
+			continue
+		}
+
+		current, ok := ma.series[s.key]
+		if !ok {
+			ma.series[s.key] = s
+			continue
+		}
+
+		// Gauges keep the newer value
+		switch s.typ {
+		case MetricDistribution:
+			current.values = append(s.values, current.values...)
+		case MetricCount, MetricRate:
+			current.value += s.value
+		}
+		if s.attempts > current.attempts {
+			current.attempts = s.attempts
+		}
+	}
+
+	if dropped > 0 {
+		ma.dropped += dropped
+		logrus.WithFields(logrus.Fields{
+			"dropped": dropped,
+			"total":   ma.dropped,
+		}).Warn("dropping metric series datadog kept rejecting")
+	}
+}
+
+// distributionSeries is a series of the distribution points API, which the
+// datadog client doesn't support
+type distributionSeries struct {
+	Metric string          `json:"metric"`
+	Type   string          `json:"type"`
+	Tags   []string        `json:"tags,omitempty"`
+	Points [][]interface{} `json:"points"`
+}
+
+func postDistributions(org *Organization, distributions []distributionSeries) error {
+	client := org.Client()
+
+	body := new(bytes.Buffer)
+	if err := json.NewEncoder(body).Encode(map[string]interface{}{"series": distributions}); err != nil {
+		return errors.Wrap(err, "could not encode distributions")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(client.GetBaseUrl(), "/")+"/api/v1/distribution_points", body)
+	if err != nil {
+		return errors.Wrap(err, "could not build distributions request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("DD-API-KEY", org.APIKey)
+
+	httpClient := client.HttpClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "could not send distributions to datadog")
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("datadog distributions API responded with %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Close stops the periodic flushing and submits any remaining series.
+// Closing again is a no-op.
+func (ma *MetricAggregator) Close() error {
+	var err error
+	ma.closeOnce.Do(func() {
+		close(ma.stop)
+		<-ma.done
+
+		err = ma.Flush()
+	})
+
+	return err
+}
+
+func (ma *MetricAggregator) flushPeriodically() {
+	defer close(ma.done)
+
+	ticker := time.NewTicker(ma.opts.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := ma.Flush(); err != nil {
+				logrus.WithError(err).Error("could not flush metrics to datadog")
+			}
+		case <-ma.stop:
+			return
+		}
+	}
+}
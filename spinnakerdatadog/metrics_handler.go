@@ -0,0 +1,72 @@
+package spinnakerdatadog
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+)
+
+// DatadogMetricsHandler records metrics for every webhook it handles in a
+// metric aggregator:
+//
+//	spinnaker.webhooks          count of webhooks by application and hook type
+//	spinnaker.pipeline.duration distribution of pipeline durations in seconds
+//	spinnaker.stage.duration    distribution of stage durations in seconds
+type DatadogMetricsHandler struct {
+	spout      *Spout
+	aggregator *MetricAggregator
+}
+
+var _ spinnaker.Handler = (*DatadogMetricsHandler)(nil)
+
+// NewDatadogMetricsHandler initializes a handler that records metrics in the
+// given aggregator, for the organization the spout routes each application to.
+// Nothing is recorded when the aggregator is nil.
+func NewDatadogMetricsHandler(s *Spout, aggregator *MetricAggregator) *DatadogMetricsHandler {
+	return &DatadogMetricsHandler{spout: s, aggregator: aggregator}
+}
+
+// Name implements spinnaker.Handler
+func (dmh *DatadogMetricsHandler) Name() string {
+	return "DatadogMetricsHandler"
+}
+
+// Handle implements spinnaker.Handler
+func (dmh *DatadogMetricsHandler) Handle(incoming *types.IncomingWebhook) error {
+	if dmh.aggregator == nil {
+		return nil
+	}
+
+	org := dmh.spout.Organization(incoming.Details.Application)
+	tags := []string{
+		fmt.Sprintf("app:%s", incoming.Details.Application),
+		fmt.Sprintf("hook_type:%s", incoming.Details.Type),
+	}
+
+	dmh.aggregator.Count(org, "spinnaker.webhooks", 1, tags...)
+
+	phase := incoming.Phase()
+	if phase != types.PhaseComplete && phase != types.PhaseFailed {
+		return nil
+	}
+
+	duration := incoming.Duration()
+	if duration <= 0 {
+		return nil
+	}
+
+	tags = append(tags, fmt.Sprintf("status:%s", phase))
+	if pipeline := incoming.PipelineName(); pipeline != "" {
+		tags = append(tags, fmt.Sprintf("pipeline:%s", pipeline))
+	}
+
+	if strings.HasPrefix(incoming.Details.Type, "orca:stage:") {
+		dmh.aggregator.Distribution(org, "spinnaker.stage.duration", duration.Seconds(), append(tags, fmt.Sprintf("stage:%s", incoming.StageName()))...)
+	} else if strings.HasPrefix(incoming.Details.Type, "orca:pipeline:") {
+		dmh.aggregator.Distribution(org, "spinnaker.pipeline.duration", duration.Seconds(), tags...)
+	}
+
+	return nil
+}
@@ -0,0 +1,217 @@
+package spinnakerdatadog_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+	spinnakerdatadog "github.com/bobbytables/spinnaker-datadog-bridge/spinnakerdatadog"
+)
+
+type submittedSeries struct {
+	Metric   string          `json:"metric"`
+	Type     string          `json:"type"`
+	Interval int             `json:"interval"`
+	Tags     []string        `json:"tags"`
+	Points   [][]interface{} `json:"points"`
+}
+
+func captureSeries(t *testing.T) (*httptest.Server, func(path string) []submittedSeries) {
+	var mu sync.Mutex
+	received := make(map[string][]submittedSeries)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body struct {
+			Series []submittedSeries `json:"series"`
+		}
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+
+		mu.Lock()
+		received[req.URL.Path] = append(received[req.URL.Path], body.Series...)
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	return ts, func(path string) []submittedSeries {
+		mu.Lock()
+		defer mu.Unlock()
+		return received[path]
+	}
+}
+
+func TestMetricAggregator(t *testing.T) {
+	ts, received := captureSeries(t)
+	defer ts.Close()
+
+	org := spinnakerdatadog.NewOrganization(spinnakerdatadog.DefaultOrganization, "api-key", "")
+	org.Connect(ts.URL, ts.Client())
+
+	aggregator := spinnakerdatadog.NewMetricAggregator(spinnakerdatadog.MetricsOptions{
+		FlushInterval: time.Hour,
+		Tags:          []string{"env:test"},
+	})
+
+	aggregator.Count(org, "deploys", 1, "app:a")
+	aggregator.Count(org, "deploys", 2, "app:a")
+	aggregator.Count(org, "deploys", 1, "app:b")
+	aggregator.Gauge(org, "queue", 5)
+	aggregator.Gauge(org, "queue", 3)
+	aggregator.Rate(org, "requests", 7200)
+	aggregator.Distribution(org, "duration", 1.5)
+	aggregator.Distribution(org, "duration", 2.5)
+
+	// Close flushes everything still buffered
+	require.NoError(t, aggregator.Close())
+	require.NoError(t, aggregator.Close(), "closing again is a no-op")
+
+	values := make(map[string]float64)
+	for _, s := range received("/api/v1/series") {
+		assert.Contains(t, s.Tags, "env:test")
+		values[s.Metric+" "+s.Type+" "+s.Tags[0]] = s.Points[0][1].(float64)
+
+		if s.Type != "gauge" {
+			assert.Equal(t, 3600, s.Interval)
+		}
+	}
+	assert.Equal(t, map[string]float64{
+		"deploys count app:a":    3,
+		"deploys count app:b":    1,
+		"queue gauge env:test":   3,
+		"requests rate env:test": 2,
+	}, values)
+
+	distributions := received("/api/v1/distribution_points")
+	require.Len(t, distributions, 1)
+	assert.Equal(t, "duration", distributions[0].Metric)
+	assert.Equal(t, []interface{}{1.5, 2.5}, distributions[0].Points[0][1])
+}
+
+func TestMetricAggregatorFlushesWhenFull(t *testing.T) {
+	ts, received := captureSeries(t)
+	defer ts.Close()
+
+	org := spinnakerdatadog.NewOrganization(spinnakerdatadog.DefaultOrganization, "", "")
+	org.Connect(ts.URL, ts.Client())
+
+	aggregator := spinnakerdatadog.NewMetricAggregator(spinnakerdatadog.MetricsOptions{FlushInterval: time.Hour, MaxSeries: 2})
+	defer aggregator.Close()
+
+	aggregator.Count(org, "first", 1)
+	assert.Empty(t, received("/api/v1/series"))
+
+	aggregator.Count(org, "second", 1)
+	assert.Len(t, received("/api/v1/series"), 2)
+}
+
+func TestMetricAggregatorRequeuesFailedSeries(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		failing  = true
+		received []submittedSeries
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var body struct {
+			Series []submittedSeries `json:"series"`
+		}
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+		received = append(received, body.Series...)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+	setFailing := func(f bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		failing = f
+	}
+
+	org := spinnakerdatadog.NewOrganization(spinnakerdatadog.DefaultOrganization, "", "")
+	org.Connect(ts.URL, ts.Client())
+
+	aggregator := spinnakerdatadog.NewMetricAggregator(spinnakerdatadog.MetricsOptions{FlushInterval: time.Hour})
+	defer aggregator.Close()
+
+	t.Run("Series of failed flushes are submitted with the next one", func(t *testing.T) {
+		aggregator.Count(org, "deploys", 1)
+		aggregator.Distribution(org, "duration", 1.5)
+		require.Error(t, aggregator.Flush())
+
+		aggregator.Count(org, "deploys", 2)
+		aggregator.Distribution(org, "duration", 2.5)
+		setFailing(false)
+		require.NoError(t, aggregator.Flush())
+
+		mu.Lock()
+		defer mu.Unlock()
+		require.Len(t, received, 2)
+		for _, s := range received {
+			if s.Type == "distribution" {
+				assert.Equal(t, []interface{}{1.5, 2.5}, s.Points[0][1])
+			} else {
+				assert.Equal(t, 3.0, s.Points[0][1])
+			}
+		}
+		received = nil
+	})
+
+	t.Run("Series are dropped after failing too many times", func(t *testing.T) {
+		setFailing(true)
+		aggregator.Count(org, "deploys", 1)
+		for i := 0; i < 3; i++ {
+			require.Error(t, aggregator.Flush())
+		}
+
+		setFailing(false)
+		require.NoError(t, aggregator.Flush())
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Empty(t, received)
+	})
+}
+
+func TestDatadogMetricsHandler(t *testing.T) {
+	ts, received := captureSeries(t)
+	defer ts.Close()
+
+	org := spinnakerdatadog.NewOrganization(spinnakerdatadog.DefaultOrganization, "", "")
+	org.Connect(ts.URL, ts.Client())
+	spout, _ := spinnakerdatadog.NewSpout(org.Client(), "")
+
+	aggregator := spinnakerdatadog.NewMetricAggregator(spinnakerdatadog.MetricsOptions{FlushInterval: time.Hour})
+	handler := spinnakerdatadog.NewDatadogMetricsHandler(spout, aggregator)
+
+	start := time.Unix(1518214000, 0)
+	require.NoError(t, handler.Handle(&types.IncomingWebhook{
+		Details: types.Details{Application: "payments", Type: "orca:pipeline:complete"},
+		Content: types.Content{
+			StartTime: types.Timestamp{Time: start},
+			EndTime:   types.Timestamp{Time: start.Add(time.Minute)},
+			Execution: &types.Execution{Name: "Release"},
+		},
+	}))
+	require.NoError(t, aggregator.Close())
+
+	series := received("/api/v1/series")
+	require.Len(t, series, 1)
+	assert.Equal(t, "spinnaker.webhooks", series[0].Metric)
+
+	distributions := received("/api/v1/distribution_points")
+	require.Len(t, distributions, 1)
+	assert.Equal(t, "spinnaker.pipeline.duration", distributions[0].Metric)
+	assert.Contains(t, distributions[0].Tags, "pipeline:Release")
+	assert.Equal(t, []interface{}{60.0}, distributions[0].Points[0][1])
+}
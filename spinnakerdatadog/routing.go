@@ -0,0 +1,158 @@
+package spinnakerdatadog
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	datadog "gopkg.in/zorkian/go-datadog-api.v2"
+)
+
+// DefaultOrganization is the name of the organization built from the global
+// datadog flags
+const DefaultOrganization = "default"
+
+// Organization is a set of Datadog credentials that applications are routed to
+type Organization struct {
+	Name   string `json:"-"`
+	APIKey string `json:"apiKey"`
+	AppKey string `json:"appKey"`
+
+	client *datadog.Client
+}
+
+// NewOrganization initializes an organization and its Datadog client
+func NewOrganization(name, apiKey, appKey string) *Organization {
+	return &Organization{
+		Name:   name,
+		APIKey: apiKey,
+		AppKey: appKey,
+		client: datadog.NewClient(apiKey, appKey),
+	}
+}
+
+// Client returns the Datadog client for the organization
+func (o *Organization) Client() *datadog.Client {
+	return o.client
+}
+
+// Destination is the rate limit destination of the organization's requests,
+// Datadog limits every organization separately
+func (o *Organization) Destination() string {
+	return Destination + ":" + o.Name
+}
+
+// Route sends the applications matching any of the given glob patterns to
+// the named organization
+type Route struct {
+	Applications []string `json:"applications"`
+	Organization string   `json:"organization"`
+}
+
+// Router selects the Datadog organization for an application
+type Router struct {
+	fallback      *Organization
+	organizations map[string]*Organization
+	routes        []*Route
+}
+
+// routesFile is the representation of the routing file
+type routesFile struct {
+	Default       string                   `json:"default,omitempty"`
+	Organizations map[string]*Organization `json:"organizations"`
+	Routes        []*Route                 `json:"routes"`
+}
+
+// NewRouter initializes a router that sends every application to the given
+// organization
+func NewRouter(fallback *Organization) *Router {
+	return &Router{
+		fallback:      fallback,
+		organizations: map[string]*Organization{fallback.Name: fallback},
+	}
+}
+
+// LoadRouter initializes a router from the given routing file. Applications
+// that don't match any route go to the organization named by "default" in the
+// file, or the given fallback organization when it isn't set.
+func LoadRouter(routingFile string, fallback *Organization) (*Router, error) {
+	router := NewRouter(fallback)
+	if routingFile == "" {
+		return router, nil
+	}
+
+	f, err := os.Open(routingFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open routing file")
+	}
+	defer f.Close()
+
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read routing file")
+	}
+
+	return LoadRouterFromYAML(b, fallback)
+}
+
+// LoadRouterFromYAML initializes a router from the contents of a routing file
+func LoadRouterFromYAML(b []byte, fallback *Organization) (*Router, error) {
+	router := NewRouter(fallback)
+
+	var rf routesFile
+	if err := yaml.Unmarshal(b, &rf); err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal routing file")
+	}
+
+	for name, org := range rf.Organizations {
+		if org == nil || org.APIKey == "" {
+			return nil, errors.Errorf("organization %s requires an apiKey", name)
+		}
+
+		router.organizations[name] = NewOrganization(name, org.APIKey, org.AppKey)
+	}
+
+	for i, route := range rf.Routes {
+		if _, ok := router.organizations[route.Organization]; !ok {
+			return nil, errors.Errorf("route %d references unknown organization %q", i, route.Organization)
+		}
+
+		for _, pattern := range route.Applications {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, errors.Wrapf(err, "route %d has an invalid application pattern %q", i, pattern)
+			}
+		}
+	}
+	router.routes = rf.Routes
+
+	if rf.Default != "" {
+		org, ok := router.organizations[rf.Default]
+		if !ok {
+			return nil, errors.Errorf("default references unknown organization %q", rf.Default)
+		}
+		router.fallback = org
+	}
+
+	return router, nil
+}
+
+// Route returns the organization for the given application. The first
+// matching route wins.
+func (r *Router) Route(application string) *Organization {
+	for _, route := range r.routes {
+		for _, pattern := range route.Applications {
+			if ok, _ := path.Match(pattern, application); ok {
+				return r.organizations[route.Organization]
+			}
+		}
+	}
+
+	return r.fallback
+}
+
+// Organizations returns every organization the router knows about
+func (r *Router) Organizations() map[string]*Organization {
+	return r.organizations
+}
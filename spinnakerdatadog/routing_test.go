@@ -0,0 +1,78 @@
+package spinnakerdatadog_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+	spinnakerdatadog "github.com/bobbytables/spinnaker-datadog-bridge/spinnakerdatadog"
+)
+
+func TestRouterInitialization(t *testing.T) {
+	wd, _ := os.Getwd()
+	fallback := spinnakerdatadog.NewOrganization(spinnakerdatadog.DefaultOrganization, "default-api-key", "")
+
+	t.Run("Given a valid routing file", func(t *testing.T) {
+		router, err := spinnakerdatadog.LoadRouter(filepath.Join(wd, "testdata", "routes.yml"), fallback)
+		require.NoError(t, err)
+		assert.Len(t, router.Organizations(), 3)
+
+		assert.Equal(t, "payments", router.Route("payments-api").Name)
+		assert.Equal(t, "payments", router.Route("billing").Name)
+		assert.Equal(t, "platform", router.Route("hcm").Name)
+	})
+
+	t.Run("Given no routing file", func(t *testing.T) {
+		router, err := spinnakerdatadog.LoadRouter("", fallback)
+		require.NoError(t, err)
+		assert.Equal(t, fallback, router.Route("hcm"))
+	})
+
+	t.Run("Given a route to an unknown organization", func(t *testing.T) {
+		_, err := spinnakerdatadog.LoadRouter(filepath.Join(wd, "testdata", "bad-routes.yml"), fallback)
+		require.Error(t, err)
+	})
+}
+
+func TestEventsAreSentToTheRoutedOrganization(t *testing.T) {
+	keys := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		keys <- req.URL.Query().Get("api_key")
+	}))
+	defer ts.Close()
+
+	wd, _ := os.Getwd()
+	router, err := spinnakerdatadog.LoadRouter(
+		filepath.Join(wd, "testdata", "routes.yml"),
+		spinnakerdatadog.NewOrganization(spinnakerdatadog.DefaultOrganization, "default-api-key", ""),
+	)
+	require.NoError(t, err)
+	router.Connect(ts.URL, ts.Client())
+
+	spout, _ := spinnakerdatadog.NewSpout(nil, "")
+	spout.UseRouter(router)
+
+	handler := spinnakerdatadog.NewDatadogEventHandler(spout, &spinnakerdatadog.EventTemplate{Title: "deployed"})
+	require.NoError(t, handler.Handle(&types.IncomingWebhook{
+		Details: types.Details{Application: "payments-api"},
+	}))
+
+	select {
+	case key := <-keys:
+		assert.Equal(t, "payments-api-key", key)
+	case <-time.After(time.Millisecond * 100):
+		t.Error("timed out waiting for event call")
+	}
+
+	t.Run("Every organization is rate limited separately", func(t *testing.T) {
+		assert.Equal(t, "datadog:payments", handler.Destination(&types.IncomingWebhook{Details: types.Details{Application: "payments-api"}}))
+		assert.Equal(t, "datadog:platform", handler.Destination(&types.IncomingWebhook{Details: types.Details{Application: "hcm"}}))
+	})
+}
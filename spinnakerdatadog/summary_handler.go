@@ -0,0 +1,284 @@
+package spinnakerdatadog
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	datadog "gopkg.in/zorkian/go-datadog-api.v2"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/ratelimit"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/templates"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+)
+
+const (
+	defaultSummaryTitle  = `{{ .Details.Application }} pipeline {{ .PipelineName | default .Content.ExecutionID }} {{ if eq .Phase "failed" }}failed{{ else }}succeeded{{ end }}`
+	defaultSummaryMaxAge = time.Hour * 24
+)
+
+// The hook types the summary handler buffers stages from and summarizes on
+var (
+	summaryStageHookTypes    = []string{"orca:stage:complete", "orca:stage:failed"}
+	summaryPipelineHookTypes = []string{"orca:pipeline:complete", "orca:pipeline:failed"}
+)
+
+// SummaryOptions configures the pipeline summary events
+type SummaryOptions struct {
+	// Title is rendered as a template against the pipeline webhook
+	Title string
+	// SuppressStageEvents stops the spout from sending an event for every
+	// stage webhook that has a template
+	SuppressStageEvents bool
+	// MaxAge is how long stages of an execution are kept when the pipeline
+	// never completes, defaults to 24 hours
+	MaxAge time.Duration
+}
+
+// PipelineSummaryHandler buffers the stage webhooks of every execution and
+// sends a single event summarizing all of the stages when the pipeline
+// completes or fails
+type PipelineSummaryHandler struct {
+	spout *Spout
+	opts  SummaryOptions
+	title *EventTemplate
+
+	mu         sync.Mutex
+	executions map[string]*executionStages
+}
+
+var (
+	_ spinnaker.Handler = (*PipelineSummaryHandler)(nil)
+	_ ratelimit.Sender  = (*PipelineSummaryHandler)(nil)
+)
+
+// executionStages are the stages buffered for a single execution
+type executionStages struct {
+	updated time.Time
+	stages  []*summaryStage
+}
+
+// summaryStage is a single row of the summary table
+type summaryStage struct {
+	Name     string
+	Status   string
+	Start    time.Time
+	Duration time.Duration
+}
+
+// NewPipelineSummaryHandler initializes a pipeline summary handler
+func NewPipelineSummaryHandler(s *Spout, opts SummaryOptions) *PipelineSummaryHandler {
+	if opts.Title == "" {
+		opts.Title = defaultSummaryTitle
+	}
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = defaultSummaryMaxAge
+	}
+
+	return &PipelineSummaryHandler{
+		spout:      s,
+		opts:       opts,
+		title:      &EventTemplate{Title: opts.Title, DeckURL: s.deckURL},
+		executions: make(map[string]*executionStages),
+	}
+}
+
+// Name implements spinnaker.Handler
+func (psh *PipelineSummaryHandler) Name() string {
+	return "PipelineSummaryHandler"
+}
+
+// HookTypes returns the hook types the handler needs to be attached to
+func (psh *PipelineSummaryHandler) HookTypes() []string {
+	return append(append([]string{}, summaryStageHookTypes...), summaryPipelineHookTypes...)
+}
+
+// Destination implements ratelimit.Sender. Only pipeline webhooks are sent to
+// the Datadog organization of their application, stage webhooks are buffered.
+func (psh *PipelineSummaryHandler) Destination(incoming *types.IncomingWebhook) string {
+	if strings.HasPrefix(incoming.Details.Type, "orca:pipeline:") {
+		return psh.spout.Organization(incoming.Details.Application).Destination()
+	}
+
+	return ""
+}
+
+// Handle implements spinnaker.Handler. Stage webhooks are buffered and
+// pipeline webhooks send the summary of their buffered stages.
+func (psh *PipelineSummaryHandler) Handle(incoming *types.IncomingWebhook) error {
+	if strings.HasPrefix(incoming.Details.Type, "orca:pipeline:") {
+		return psh.summarize(incoming)
+	}
+
+	psh.buffer(incoming)
+	return nil
+}
+
+func (psh *PipelineSummaryHandler) buffer(incoming *types.IncomingWebhook) {
+	name := incoming.StageName()
+	if name == "" {
+		name = "(unnamed stage)"
+	}
+
+	status := "SUCCEEDED"
+	if incoming.Phase() == types.PhaseFailed {
+		status = "FAILED"
+	}
+
+	now := time.Now()
+
+	psh.mu.Lock()
+	defer psh.mu.Unlock()
+
+	psh.evict(now)
+
+	execution, ok := psh.executions[incoming.Content.ExecutionID]
+	if !ok {
+		execution = &executionStages{}
+		psh.executions[incoming.Content.ExecutionID] = execution
+	}
+
+	execution.updated = now
+	execution.stages = append(execution.stages, &summaryStage{
+		Name:     name,
+		Status:   status,
+		Start:    incoming.Content.StartTime.Time,
+		Duration: incoming.Duration(),
+	})
+}
+
+// evict forgets executions that haven't been updated within the max age, it
+// must be called with the lock held
+func (psh *PipelineSummaryHandler) evict(now time.Time) {
+	for id, execution := range psh.executions {
+		if now.Sub(execution.updated) > psh.opts.MaxAge {
+			delete(psh.executions, id)
+		}
+	}
+}
+
+// stages returns (and forgets) the buffered stages of the execution. When no
+// stages were buffered the stages of the execution in the payload are used.
+func (psh *PipelineSummaryHandler) stages(incoming *types.IncomingWebhook) []*summaryStage {
+	psh.mu.Lock()
+	execution, ok := psh.executions[incoming.Content.ExecutionID]
+	delete(psh.executions, incoming.Content.ExecutionID)
+	psh.mu.Unlock()
+
+	var stages []*summaryStage
+	if ok {
+		stages = execution.stages
+	} else if incoming.Content.Execution != nil {
+		for _, stage := range incoming.Content.Execution.Stages {
+			duration := time.Duration(0)
+			if !stage.StartTime.IsZero() && !stage.EndTime.IsZero() {
+				duration = stage.EndTime.Sub(stage.StartTime.Time)
+			}
+
+			stages = append(stages, &summaryStage{
+				Name:     stage.Name,
+				Status:   stage.Status,
+				Start:    stage.StartTime.Time,
+				Duration: duration,
+			})
+		}
+	}
+
+	sort.SliceStable(stages, func(i, j int) bool {
+		return stages[i].Start.Before(stages[j].Start)
+	})
+
+	return stages
+}
+
+func (psh *PipelineSummaryHandler) summarize(incoming *types.IncomingWebhook) error {
+	title, _, err := psh.title.Render(incoming)
+	if err != nil {
+		return err
+	}
+
+	failed := incoming.Phase() == types.PhaseFailed
+
+	event := &datadog.Event{}
+	event.SetTitle(strings.TrimSpace(title))
+	event.SetText(summaryText(incoming, psh.stages(incoming), psh.spout.deckURL))
+	event.SetAggregation(incoming.Content.ExecutionID)
+	psh.spout.dateEvent(event, incoming)
+	if failed {
+		event.SetAlertType("error")
+	} else {
+		event.SetAlertType("success")
+	}
+	if link := incoming.ExecutionURL(psh.spout.deckURL); link != "" {
+		event.SetUrl(link)
+	}
+	event.Tags = []string{
+		fmt.Sprintf("app:%s", incoming.Details.Application),
+		incoming.Details.Type,
+	}
+	if pipeline := incoming.PipelineName(); pipeline != "" {
+		event.Tags = append(event.Tags, fmt.Sprintf("pipeline:%s", pipeline))
+	}
+
+	if _, err := psh.spout.Organization(incoming.Details.Application).Client().PostEvent(event); err != nil {
+		return errors.Wrap(err, "could not post summary to datadog API")
+	}
+
+	return nil
+}
+
+// summaryText builds the markdown table of stages, highlighting failures
+func summaryText(incoming *types.IncomingWebhook, stages []*summaryStage, deckURL string) string {
+	buf := new(bytes.Buffer)
+	buf.WriteString(markdownBlock + " \n")
+
+	if len(stages) == 0 {
+		buf.WriteString("No stages were recorded for this execution.")
+	} else {
+		buf.WriteString(string(templates.MarkdownHeader("Stage", "Status", "Duration")))
+		for _, stage := range stages {
+			duration := "-"
+			if stage.Duration > 0 {
+				duration = templates.HumanizeDuration(stage.Duration)
+			}
+
+			cells := []interface{}{stage.Name, stage.Status, duration}
+			if isFailedStatus(stage.Status) {
+				for i, cell := range cells {
+					cells[i] = templates.Markdown("**" + string(templates.MarkdownEscape(cell)) + "**")
+				}
+			}
+
+			buf.WriteString("\n" + string(templates.MarkdownRow(cells...)))
+		}
+	}
+
+	if link := incoming.ExecutionURL(deckURL); link != "" {
+		buf.WriteString("\n\n" + string(templates.MarkdownLink("View in Spinnaker", link)))
+	}
+
+	buf.WriteString("\n " + markdownBlock)
+
+	return buf.String()
+}
+
+func isFailedStatus(status string) bool {
+	switch status {
+	case "FAILED", "TERMINAL", "FAILED_CONTINUE", "STOPPED", "CANCELED":
+		return true
+	}
+
+	return false
+}
+
+// EnableSummaries makes the spout send a summary event when pipelines
+// complete or fail. It must be called before the spout is attached to a
+// dispatcher.
+func (s *Spout) EnableSummaries(opts SummaryOptions) {
+	s.summary = NewPipelineSummaryHandler(s, opts)
+}
@@ -0,0 +1,123 @@
+package spinnakerdatadog_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+	spinnakerdatadog "github.com/bobbytables/spinnaker-datadog-bridge/spinnakerdatadog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	datadog "gopkg.in/zorkian/go-datadog-api.v2"
+)
+
+func stageWebhook(hookType, name string, start time.Time, d time.Duration) *types.IncomingWebhook {
+	return &types.IncomingWebhook{
+		Details: types.Details{Application: "payments", Type: hookType},
+		Content: types.Content{
+			ExecutionID: "someid",
+			Name:        name,
+			StartTime:   types.Timestamp{Time: start},
+			EndTime:     types.Timestamp{Time: start.Add(d)},
+			Execution:   &types.Execution{Name: "Release"},
+		},
+	}
+}
+
+func TestPipelineSummaryHandler(t *testing.T) {
+	events := make(chan datadog.Event, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		var event datadog.Event
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&event))
+		events <- event
+	}))
+	defer ts.Close()
+
+	org := spinnakerdatadog.NewOrganization(spinnakerdatadog.DefaultOrganization, "", "")
+	org.Connect(ts.URL, ts.Client())
+	spout, _ := spinnakerdatadog.NewSpout(org.Client(), "")
+	handler := spinnakerdatadog.NewPipelineSummaryHandler(spout, spinnakerdatadog.SummaryOptions{})
+
+	start := time.Unix(1518214000, 0)
+	// Stages arrive out of order and are sorted by their start time
+	require.NoError(t, handler.Handle(stageWebhook("orca:stage:failed", "Deploy", start.Add(time.Minute), time.Second*90)))
+	require.NoError(t, handler.Handle(stageWebhook("orca:stage:complete", "Bake", start, time.Second*30)))
+
+	pipeline := stageWebhook("orca:pipeline:failed", "", start, time.Minute*3)
+	require.NoError(t, handler.Handle(pipeline))
+
+	select {
+	case event := <-events:
+		assert.Equal(t, "payments pipeline Release failed", event.GetTitle())
+		assert.Equal(t, "error", event.GetAlertType())
+		assert.Equal(t, "someid", event.GetAggregation())
+		assert.Contains(t, event.Tags, "pipeline:Release")
+		assert.Equal(t, "%%% \n| Stage | Status | Duration |\n| --- | --- | --- |\n| Bake | SUCCEEDED | 30s |\n| **Deploy** | **FAILED** | **1m 30s** |\n %%%", event.GetText())
+	case <-time.After(time.Millisecond * 100):
+		t.Fatal("timed out waiting for the summary")
+	}
+
+	t.Run("Without buffered stages the execution stages are used", func(t *testing.T) {
+		pipeline := stageWebhook("orca:pipeline:complete", "", start, time.Minute)
+		pipeline.Content.Execution.Stages = []types.Stage{
+			{Name: "Bake", Status: "SUCCEEDED", StartTime: types.Timestamp{Time: start}, EndTime: types.Timestamp{Time: start.Add(time.Minute)}},
+		}
+		require.NoError(t, handler.Handle(pipeline))
+
+		event := <-events
+		assert.Equal(t, "payments pipeline Release succeeded", event.GetTitle())
+		assert.Equal(t, "success", event.GetAlertType())
+		assert.Contains(t, event.GetText(), "| Bake | SUCCEEDED | 1m |")
+	})
+
+	t.Run("Failed stage names don't break the table", func(t *testing.T) {
+		require.NoError(t, handler.Handle(stageWebhook("orca:stage:failed", "Deploy\ncanary", start, time.Second)))
+		require.NoError(t, handler.Handle(stageWebhook("orca:pipeline:failed", "", start, time.Minute)))
+
+		event := <-events
+		assert.Contains(t, event.GetText(), "| **Deploy canary** | **FAILED** | **1s** |")
+	})
+
+	t.Run("Replayed summaries keep the time of their webhook", func(t *testing.T) {
+		spout.PreserveTimestamps()
+
+		created := time.Now().Add(-time.Hour)
+		pipeline := stageWebhook("orca:pipeline:complete", "", start, time.Minute)
+		pipeline.Details.Created = types.Timestamp{Time: created}
+		require.NoError(t, handler.Handle(pipeline))
+
+		event := <-events
+		assert.Equal(t, int(created.Unix()), event.GetTime())
+	})
+
+	t.Run("Replayed summaries older than Datadog accepts are dated 18 hours ago", func(t *testing.T) {
+		pipeline := stageWebhook("orca:pipeline:complete", "", start, time.Minute)
+		pipeline.Details.Created = types.Timestamp{Time: start.Add(time.Minute)}
+		require.NoError(t, handler.Handle(pipeline))
+
+		event := <-events
+		oldest := time.Now().Add(-18 * time.Hour)
+		assert.InDelta(t, oldest.Unix(), event.GetTime(), float64(2*time.Minute/time.Second))
+	})
+}
+
+func TestSpoutSuppressesStageEvents(t *testing.T) {
+	wd, _ := os.Getwd()
+	spout, err := spinnakerdatadog.NewSpout(nil, filepath.Join(wd, "testdata", "template.yml"))
+	require.NoError(t, err)
+
+	spout.EnableSummaries(spinnakerdatadog.SummaryOptions{SuppressStageEvents: true})
+
+	d := spinnaker.NewDispatcher()
+	spout.AttachToDispatcher(d)
+	for _, hookType := range []string{"orca:stage:complete", "orca:stage:failed", "orca:pipeline:complete", "orca:pipeline:failed"} {
+		require.Len(t, d.Handlers()[hookType], 1, hookType)
+		assert.Equal(t, "PipelineSummaryHandler", d.Handlers()[hookType][0].Name())
+	}
+}
@@ -0,0 +1,167 @@
+package spinnakerdatadog
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	datadog "gopkg.in/zorkian/go-datadog-api.v2"
+)
+
+// DefaultSite is the Datadog site used when none is configured
+const DefaultSite = "datadoghq.com"
+
+// Sites are the Datadog sites the bridge knows how to reach
+var Sites = map[string]string{
+	"us1": "datadoghq.com",
+	"us3": "us3.datadoghq.com",
+	"us5": "us5.datadoghq.com",
+	"eu":  "datadoghq.eu",
+	"ap1": "ap1.datadoghq.com",
+	"gov": "ddog-gov.com",
+}
+
+// TLS versions that can be configured as the minimum version
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ConnectionOptions configures how every Datadog bound handler reaches Datadog
+type ConnectionOptions struct {
+	// Site is a Datadog site domain (datadoghq.eu) or its short name (eu).
+	// Defaults to DefaultSite.
+	Site string
+	// APIURL overrides the API URL derived from the site
+	APIURL string
+	// ProxyURL is used for every request, when it is empty the standard
+	// HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment variables are honored
+	ProxyURL string
+	// Timeout is the timeout of a whole request, zero means no timeout
+	Timeout time.Duration
+	// CABundle is a PEM file with certificates trusted in addition to the
+	// system roots (for TLS intercepting egress proxies)
+	CABundle           string
+	InsecureSkipVerify bool
+	// TLSMinVersion is one of 1.0, 1.1, 1.2 or 1.3
+	TLSMinVersion string
+}
+
+// site returns the domain of the configured site
+func (co ConnectionOptions) site() (string, error) {
+	if co.Site == "" {
+		return DefaultSite, nil
+	}
+
+	if domain, ok := Sites[co.Site]; ok {
+		return domain, nil
+	}
+
+	for _, domain := range Sites {
+		if domain == co.Site {
+			return domain, nil
+		}
+	}
+
+	known := make([]string, 0, len(Sites))
+	for name, domain := range Sites {
+		known = append(known, name+" ("+domain+")")
+	}
+	sort.Strings(known)
+
+	return "", errors.Errorf("unknown datadog site %q, known sites are: %s", co.Site, strings.Join(known, ", "))
+}
+
+// BaseURL returns the URL of the Datadog API
+func (co ConnectionOptions) BaseURL() (string, error) {
+	if co.APIURL != "" {
+		return strings.TrimSuffix(co.APIURL, "/"), nil
+	}
+
+	site, err := co.site()
+	if err != nil {
+		return "", err
+	}
+
+	return "https://api." + site, nil
+}
+
+// LogsIntakeURL returns the URL of the HTTP logs intake
+func (co ConnectionOptions) LogsIntakeURL() (string, error) {
+	site, err := co.site()
+	if err != nil {
+		return "", err
+	}
+
+	return "https://http-intake.logs." + site + "/api/v2/logs", nil
+}
+
+// HTTPClient builds the HTTP client used for requests to Datadog
+func (co ConnectionOptions) HTTPClient() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if co.ProxyURL != "" {
+		proxy, err := url.Parse(co.ProxyURL)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not parse proxy url")
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: co.InsecureSkipVerify}
+
+	if co.TLSMinVersion != "" {
+		version, ok := tlsVersions[co.TLSMinVersion]
+		if !ok {
+			return nil, errors.Errorf("unknown tls version %q", co.TLSMinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if co.CABundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+
+		pem, err := ioutil.ReadFile(co.CABundle)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read ca bundle")
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates found in ca bundle %s", co.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{Transport: transport, Timeout: co.Timeout}, nil
+}
+
+// Connect points the organization's Datadog client at the given API URL using
+// the given HTTP client
+func (o *Organization) Connect(baseURL string, c *http.Client) {
+	if o.client == nil {
+		o.client = datadog.NewClient(o.APIKey, o.AppKey)
+	}
+
+	o.client.SetBaseUrl(baseURL)
+	o.client.HttpClient = c
+}
+
+// Connect points every organization of the router at the given API URL using
+// the given HTTP client
+func (r *Router) Connect(baseURL string, c *http.Client) {
+	for _, org := range r.organizations {
+		org.Connect(baseURL, c)
+	}
+}
@@ -0,0 +1,108 @@
+package spinnakerdatadog_test
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	spinnakerdatadog "github.com/bobbytables/spinnaker-datadog-bridge/spinnakerdatadog"
+)
+
+func TestConnectionOptionsURLs(t *testing.T) {
+	tests := []struct {
+		opts    spinnakerdatadog.ConnectionOptions
+		baseURL string
+		logsURL string
+		wantErr bool
+	}{
+		{
+			opts:    spinnakerdatadog.ConnectionOptions{},
+			baseURL: "https://api.datadoghq.com",
+			logsURL: "https://http-intake.logs.datadoghq.com/api/v2/logs",
+		},
+		{
+			opts:    spinnakerdatadog.ConnectionOptions{Site: "eu"},
+			baseURL: "https://api.datadoghq.eu",
+			logsURL: "https://http-intake.logs.datadoghq.eu/api/v2/logs",
+		},
+		{
+			opts:    spinnakerdatadog.ConnectionOptions{Site: "us5.datadoghq.com"},
+			baseURL: "https://api.us5.datadoghq.com",
+			logsURL: "https://http-intake.logs.us5.datadoghq.com/api/v2/logs",
+		},
+		{
+			opts:    spinnakerdatadog.ConnectionOptions{Site: "gov", APIURL: "http://localhost:8080/"},
+			baseURL: "http://localhost:8080",
+			logsURL: "https://http-intake.logs.ddog-gov.com/api/v2/logs",
+		},
+		{
+			opts:    spinnakerdatadog.ConnectionOptions{Site: "datadoghq.mars"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.opts.Site, func(t *testing.T) {
+			baseURL, err := test.opts.BaseURL()
+			if test.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.baseURL, baseURL)
+
+			logsURL, err := test.opts.LogsIntakeURL()
+			require.NoError(t, err)
+			assert.Equal(t, test.logsURL, logsURL)
+		})
+	}
+}
+
+func TestConnectionOptionsHTTPClient(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "ca-bundle")
+	require.NoError(t, err)
+	bundle := filepath.Join(dir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: ts.Certificate().Raw,
+	}), 0600))
+
+	t.Run("Without the ca bundle the server isn't trusted", func(t *testing.T) {
+		client, err := spinnakerdatadog.ConnectionOptions{}.HTTPClient()
+		require.NoError(t, err)
+
+		_, err = client.Get(ts.URL)
+		require.Error(t, err)
+	})
+
+	t.Run("With the ca bundle the server is trusted", func(t *testing.T) {
+		client, err := spinnakerdatadog.ConnectionOptions{CABundle: bundle, TLSMinVersion: "1.2"}.HTTPClient()
+		require.NoError(t, err)
+
+		resp, err := client.Get(ts.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	})
+
+	t.Run("Given a missing ca bundle", func(t *testing.T) {
+		_, err := spinnakerdatadog.ConnectionOptions{CABundle: filepath.Join(dir, "nope.pem")}.HTTPClient()
+		require.Error(t, err)
+	})
+
+	t.Run("Given an unknown tls version", func(t *testing.T) {
+		_, err := spinnakerdatadog.ConnectionOptions{TLSMinVersion: "0.9"}.HTTPClient()
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,319 @@
+package spinnakerhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/pkg/errors"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/ratelimit"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/templates"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+)
+
+// ForwardConfig is the representation of a single forwarding destination in
+// the templates file
+type ForwardConfig struct {
+	// Name identifies the destination in logs and dispatch results
+	Name string `json:"name,omitempty"`
+	// URL is rendered as a template against the incoming webhook
+	URL string `json:"url"`
+	// Method defaults to POST
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Body is rendered as a template against the incoming webhook. When it is
+	// empty the webhook itself is sent as JSON.
+	Body string `json:"body,omitempty"`
+	// ContentType of the body, defaults to application/json. JSON bodies are
+	// checked to be valid before they are sent.
+	ContentType string `json:"contentType,omitempty"`
+	// Escape is how values interpolated in the body are escaped. It defaults
+	// to json for JSON content types, so values can be written inside JSON
+	// strings, and to none otherwise.
+	Escape templates.EscapeMode `json:"escape,omitempty"`
+	// SuccessCodes are the response status codes considered successful,
+	// defaults to any 2xx code
+	SuccessCodes []int `json:"successCodes,omitempty"`
+	// DeckURL is the base URL of Deck the URL functions of the templates link
+	// to. It is set by the forwarder before compiling.
+	DeckURL string `json:"-"`
+
+	compiledURL  *template.Template
+	compiledBody *template.Template
+	compileOnce  sync.Once
+	compileErr   error
+}
+
+// Validate checks the configuration can be used to forward webhooks
+func (fc *ForwardConfig) Validate() error {
+	if fc.URL == "" {
+		return errors.New("url is required")
+	}
+
+	return fc.Compile()
+}
+
+// Compile parses the url and body templates. It is safe to call multiple
+// times, the templates are only parsed once.
+func (fc *ForwardConfig) Compile() error {
+	fc.compileOnce.Do(func() {
+		fc.compiledURL, fc.compileErr = template.New("forwardURL").Funcs(templates.Funcs(fc.DeckURL)).Parse(fc.URL)
+		if fc.compileErr != nil {
+			fc.compileErr = errors.Wrap(fc.compileErr, "could not compile url")
+			return
+		}
+
+		if fc.Body == "" {
+			return
+		}
+
+		fc.compiledBody, fc.compileErr = template.New("forwardBody").Funcs(templates.Funcs(fc.DeckURL)).Parse(fc.Body)
+		if fc.compileErr != nil {
+			fc.compileErr = errors.Wrap(fc.compileErr, "could not compile body")
+			return
+		}
+
+		escape := fc.Escape
+		if escape == "" && fc.isJSON() {
+			escape = templates.EscapeJSON
+		}
+		if err := templates.Escape(fc.compiledBody, escape); err != nil {
+			fc.compileErr = errors.Wrap(err, "could not escape body")
+		}
+	})
+
+	return fc.compileErr
+}
+
+// defaultContentType is the content type of forwards that don't set one
+const defaultContentType = "application/json"
+
+func (fc *ForwardConfig) contentType() string {
+	if fc.ContentType == "" {
+		return defaultContentType
+	}
+
+	return fc.ContentType
+}
+
+// isJSON returns true when the body is sent as JSON (application/json or a
+// +json media type)
+func (fc *ForwardConfig) isJSON() bool {
+	mediaType, _, err := mime.ParseMediaType(fc.contentType())
+	if err != nil {
+		return false
+	}
+
+	return mediaType == defaultContentType || strings.HasSuffix(mediaType, "+json")
+}
+
+func (fc *ForwardConfig) isSuccess(code int) bool {
+	if len(fc.SuccessCodes) == 0 {
+		return code >= 200 && code <= 299
+	}
+
+	for _, c := range fc.SuccessCodes {
+		if c == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ForwardHandler sends incoming webhooks to an arbitrary HTTP endpoint with a
+// body rendered from its configuration
+type ForwardHandler struct {
+	client *http.Client
+	config *ForwardConfig
+}
+
+var (
+	_ spinnaker.Handler   = (*ForwardHandler)(nil)
+	_ spinnaker.Previewer = (*ForwardHandler)(nil)
+	_ ratelimit.Sender    = (*ForwardHandler)(nil)
+)
+
+// NewForwardHandler initializes a forward handler for the given destination
+func NewForwardHandler(c *http.Client, config *ForwardConfig) *ForwardHandler {
+	if c == nil {
+		c = http.DefaultClient
+	}
+
+	return &ForwardHandler{
+		client: c,
+		config: config,
+	}
+}
+
+// Name implements spinnaker.Handler
+func (fh *ForwardHandler) Name() string {
+	if fh.config.Name != "" {
+		return "HTTPForwardHandler:" + fh.config.Name
+	}
+
+	return "HTTPForwardHandler"
+}
+
+// Destination implements ratelimit.Sender, it is the host of the URL rendered
+// for the webhook
+func (fh *ForwardHandler) Destination(incoming *types.IncomingWebhook) string {
+	rawURL, err := fh.renderURL(incoming)
+	if err != nil {
+		return ""
+	}
+
+	return ratelimit.URLDestination(rawURL)
+}
+
+// Handle implements spinnaker.Handler. It renders the request for the given
+// webhook and sends it to the configured destination.
+func (fh *ForwardHandler) Handle(incoming *types.IncomingWebhook) error {
+	forward, err := fh.render(incoming)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(forward.Method, forward.URL, strings.NewReader(forward.Body))
+	if err != nil {
+		return errors.Wrap(err, "could not build forward request")
+	}
+	req.Header.Set("Content-Type", fh.config.contentType())
+	for k, v := range fh.config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := fh.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "could not forward webhook")
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if !fh.config.isSuccess(resp.StatusCode) {
+		return fmt.Errorf("forward destination responded with %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// renderedForward is the request a forward sends for a webhook
+type renderedForward struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Body   string `json:"body"`
+}
+
+// Preview implements spinnaker.Previewer, it returns the request that would be
+// sent for the webhook. Headers are left out and the query values and
+// credentials of the URL are redacted since they often hold secrets.
+func (fh *ForwardHandler) Preview(incoming *types.IncomingWebhook) (interface{}, error) {
+	forward, err := fh.render(incoming)
+	if err != nil {
+		return nil, err
+	}
+
+	return forward.redacted(fh.config.URL + fh.config.Body), nil
+}
+
+// redactedValue replaces secrets in previews
+const redactedValue = "REDACTED"
+
+// minSecretLength is the length from which the secrets of the URL are also
+// redacted from the body, shorter query values are unlikely to be tokens and
+// replacing them would mangle the body
+const minSecretLength = 8
+
+// redacted returns a copy of the forward with the query values and the
+// password of the URL replaced. Secrets written in the templates (rather than
+// rendered from the webhook) are also replaced in the body.
+func (rf *renderedForward) redacted(templates string) *renderedForward {
+	redacted := &renderedForward{Method: rf.Method, URL: redactedValue, Body: rf.Body}
+
+	u, err := url.Parse(rf.URL)
+	if err != nil {
+		return redacted
+	}
+
+	var secrets []string
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			secrets = append(secrets, password)
+		}
+		u.User = nil
+	}
+
+	query := u.Query()
+	for _, values := range query {
+		for i, value := range values {
+			secrets = append(secrets, value)
+			values[i] = redactedValue
+		}
+	}
+	u.RawQuery = query.Encode()
+	redacted.URL = u.String()
+
+	for _, secret := range secrets {
+		if len(secret) < minSecretLength || !strings.Contains(templates, secret) {
+			continue
+		}
+		redacted.Body = strings.Replace(redacted.Body, secret, redactedValue, -1)
+		redacted.Body = strings.Replace(redacted.Body, url.QueryEscape(secret), redactedValue, -1)
+	}
+
+	return redacted
+}
+
+func (fh *ForwardHandler) renderURL(incoming *types.IncomingWebhook) (string, error) {
+	if err := fh.config.Compile(); err != nil {
+		return "", errors.Wrap(err, "could not compile forward")
+	}
+
+	buf := new(bytes.Buffer)
+	if err := fh.config.compiledURL.Execute(buf, incoming); err != nil {
+		return "", errors.Wrap(err, "could not render url from webhook")
+	}
+
+	return strings.TrimSpace(buf.String()), nil
+}
+
+func (fh *ForwardHandler) render(incoming *types.IncomingWebhook) (*renderedForward, error) {
+	rawURL, err := fh.renderURL(incoming)
+	if err != nil {
+		return nil, err
+	}
+
+	body := new(bytes.Buffer)
+
+	if fh.config.compiledBody != nil {
+		if err := fh.config.compiledBody.Execute(body, incoming); err != nil {
+			return nil, errors.Wrap(err, "could not render body from webhook")
+		}
+		if fh.config.isJSON() && !json.Valid(body.Bytes()) {
+			return nil, errors.Errorf("rendered body isn't valid JSON: %s", body.String())
+		}
+	} else if err := json.NewEncoder(body).Encode(incoming); err != nil {
+		return nil, errors.Wrap(err, "could not encode webhook")
+	}
+
+	method := strings.ToUpper(fh.config.Method)
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	return &renderedForward{
+		Method: method,
+		URL:    rawURL,
+		Body:   body.String(),
+	}, nil
+}
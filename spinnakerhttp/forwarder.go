@@ -0,0 +1,105 @@
+package spinnakerhttp
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/templates"
+)
+
+// Forwarder reads the forwarding configuration out of the event templates file
+// and attaches a ForwardHandler for every configured destination to a dispatcher
+type Forwarder struct {
+	client   *http.Client
+	forwards map[string][]*ForwardConfig
+}
+
+// forwardEntry is the part of a hook type entry in the templates file that
+// the forwarder cares about. Other keys (title, text) belong to other handlers.
+type forwardEntry struct {
+	Forward []*ForwardConfig `json:"forward,omitempty"`
+}
+
+// NewForwarder initializes a forwarder from the "forward" sections of the
+// given templates file. The HTTP client is used for every forwarded request.
+func NewForwarder(c *http.Client, templateFile string) (*Forwarder, error) {
+	file, err := templates.LoadFile(templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewForwarderFromFile(c, file, "")
+}
+
+// NewForwarderFromYAML initializes a forwarder from the contents of a templates file
+func NewForwarderFromYAML(c *http.Client, b []byte) (*Forwarder, error) {
+	file, err := templates.ParseFile(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewForwarderFromFile(c, file, "")
+}
+
+// NewForwarderFromFile initializes a forwarder from a parsed templates file.
+// The URL functions of its templates link to Deck hosted at deckURL.
+func NewForwarderFromFile(c *http.Client, file *templates.File, deckURL string) (*Forwarder, error) {
+	if c == nil {
+		c = http.DefaultClient
+	}
+
+	forwarder := &Forwarder{client: c, forwards: make(map[string][]*ForwardConfig)}
+
+	entries := make(map[string]*forwardEntry)
+	if err := file.Decode(&entries); err != nil {
+		return nil, err
+	}
+
+	for hookType, entry := range entries {
+		if entry == nil || len(entry.Forward) == 0 {
+			continue
+		}
+
+		for _, config := range entry.Forward {
+			config.DeckURL = deckURL
+			if err := config.Validate(); err != nil {
+				return nil, errors.Wrapf(err, "invalid forward for %s", hookType)
+			}
+		}
+
+		forwarder.forwards[hookType] = entry.Forward
+	}
+
+	return forwarder, nil
+}
+
+// TotalForwards returns how many destinations are configured across all hook types
+func (f *Forwarder) TotalForwards() int {
+	total := 0
+	for _, configs := range f.forwards {
+		total += len(configs)
+	}
+
+	return total
+}
+
+// Handlers returns the handlers that get attached to a dispatcher when AttachToDispatcher is called
+func (f *Forwarder) Handlers() map[string][]spinnaker.Handler {
+	hs := make(map[string][]spinnaker.Handler)
+
+	for hookType, configs := range f.forwards {
+		for _, config := range configs {
+			hs[hookType] = append(hs[hookType], NewForwardHandler(f.client, config))
+		}
+	}
+
+	return hs
+}
+
+// AttachToDispatcher registers all of the handlers for this forwarder to a
+// spinnaker dispatcher.
+func (f *Forwarder) AttachToDispatcher(d *spinnaker.Dispatcher) {
+	d.AddHandlers(f.Handlers())
+}
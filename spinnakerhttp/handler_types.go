@@ -0,0 +1,28 @@
+package spinnakerhttp
+
+import (
+	"net/http"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+)
+
+// ForwardHandlerType forwards webhooks, its options are a forward
+// configuration (url, method, headers, body, successCodes)
+const ForwardHandlerType = "http-forward"
+
+// RegisterHandlerTypes registers the forward handler type with the registry.
+// The HTTP client is used for every forwarded request and the URL functions
+// of the templates link to Deck hosted at deckURL.
+func RegisterHandlerTypes(r *spinnaker.Registry, c *http.Client, deckURL string) {
+	r.Register(ForwardHandlerType, func(config spinnaker.HandlerConfig) (spinnaker.Handler, error) {
+		forward := &ForwardConfig{DeckURL: deckURL}
+		if err := config.DecodeOptions(forward); err != nil {
+			return nil, err
+		}
+		if err := forward.Validate(); err != nil {
+			return nil, err
+		}
+
+		return NewForwardHandler(c, forward), nil
+	})
+}
@@ -0,0 +1,279 @@
+package spinnakerpagerduty
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/ratelimit"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnakerdatadog"
+)
+
+// DefaultEventsURL is the PagerDuty Events API v2 endpoint
+const DefaultEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+const defaultSummary = "{{ .Details.Application }} {{ with .PipelineName }}pipeline {{ . }} {{ end }}failed in Spinnaker"
+
+// AlertConfig is the representation of a PagerDuty service in the templates file
+type AlertConfig struct {
+	// RoutingKey is the integration key of the PagerDuty service
+	RoutingKey string `json:"routingKey"`
+	// Applications, Pipelines and Accounts limit which executions alert. Glob
+	// patterns such as "payments-*" are supported and an empty list matches
+	// everything. Accounts are matched against the "account" and "credentials"
+	// of the stage (or any stage of the pipeline).
+	Applications []string `json:"applications,omitempty"`
+	Pipelines    []string `json:"pipelines,omitempty"`
+	Accounts     []string `json:"accounts,omitempty"`
+	// Severity is one of critical, error, warning or info. Defaults to critical.
+	Severity string `json:"severity,omitempty"`
+	// Summary is rendered as a template against the incoming webhook
+	Summary string `json:"summary,omitempty"`
+	// DeckURL is the base URL of Spinnaker's UI, used to link to the execution
+	DeckURL string `json:"deckUrl,omitempty"`
+	// EventsURL defaults to DefaultEventsURL
+	EventsURL string `json:"eventsUrl,omitempty"`
+
+	summary *spinnakerdatadog.EventTemplate
+}
+
+// Validate checks the configuration can be used to send alerts
+func (ac *AlertConfig) Validate() error {
+	if ac.RoutingKey == "" {
+		return errors.New("routingKey is required")
+	}
+
+	switch ac.Severity {
+	case "":
+		ac.Severity = "critical"
+	case "critical", "error", "warning", "info":
+	default:
+		return fmt.Errorf("unknown severity %q", ac.Severity)
+	}
+
+	if ac.EventsURL == "" {
+		ac.EventsURL = DefaultEventsURL
+	}
+
+	summary := ac.Summary
+	if summary == "" {
+		summary = defaultSummary
+	}
+	ac.summary = &spinnakerdatadog.EventTemplate{Title: summary, DeckURL: ac.DeckURL}
+
+	return ac.summary.Compile()
+}
+
+// matches returns true if the execution of the webhook should alert
+func (ac *AlertConfig) matches(incoming *types.IncomingWebhook) bool {
+	if !matchAny(ac.Applications, incoming.Details.Application) {
+		return false
+	}
+
+	if !matchAny(ac.Pipelines, incoming.PipelineName()) {
+		return false
+	}
+
+	if len(ac.Accounts) == 0 {
+		return true
+	}
+
+	for _, account := range accounts(incoming) {
+		if matchAny(ac.Accounts, account) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// accounts returns every account the webhook's stage (or pipeline) deploys to
+func accounts(incoming *types.IncomingWebhook) []string {
+	contexts := []map[string]interface{}{incoming.Content.Context}
+	if incoming.Content.Execution != nil {
+		for _, stage := range incoming.Content.Execution.Stages {
+			contexts = append(contexts, stage.Context)
+		}
+	}
+
+	var found []string
+	for _, ctx := range contexts {
+		for _, key := range []string{"account", "credentials"} {
+			if account, ok := ctx[key].(string); ok && account != "" {
+				found = append(found, account)
+			}
+		}
+	}
+
+	return found
+}
+
+// AlertHandler triggers a PagerDuty alert when a matching execution fails and
+// resolves it when a subsequent run of the same pipeline succeeds
+type AlertHandler struct {
+	client *http.Client
+	config *AlertConfig
+}
+
+var (
+	_ spinnaker.Handler = (*AlertHandler)(nil)
+	_ ratelimit.Sender  = (*AlertHandler)(nil)
+)
+
+// NewAlertHandler initializes an alert handler for the given PagerDuty service.
+// The config must have been validated.
+func NewAlertHandler(c *http.Client, config *AlertConfig) *AlertHandler {
+	if c == nil {
+		c = http.DefaultClient
+	}
+
+	return &AlertHandler{client: c, config: config}
+}
+
+// Name implements spinnaker.Handler
+func (ah *AlertHandler) Name() string {
+	return "PagerDutyAlertHandler"
+}
+
+// Destination implements ratelimit.Sender, it is the host of the events URL
+// for the webhooks the alert matches
+func (ah *AlertHandler) Destination(incoming *types.IncomingWebhook) string {
+	if !ah.config.matches(incoming) {
+		return ""
+	}
+
+	return ratelimit.URLDestination(ah.config.EventsURL)
+}
+
+// Handle implements spinnaker.Handler. Failed webhooks trigger an alert and
+// complete webhooks resolve the alert of their pipeline. The dedup key is the
+// same for every execution of a pipeline, so repeated failures are grouped in
+// one alert and completions resolve it even after a restart of the bridge
+// (PagerDuty ignores resolves of keys without an open alert).
+func (ah *AlertHandler) Handle(incoming *types.IncomingWebhook) error {
+	if !ah.config.matches(incoming) {
+		return nil
+	}
+
+	switch incoming.Phase() {
+	case types.PhaseFailed:
+		return ah.trigger(incoming, dedupKey(incoming))
+	case types.PhaseComplete:
+		return ah.send(&event{RoutingKey: ah.config.RoutingKey, Action: "resolve", DedupKey: dedupKey(incoming)})
+	}
+
+	return nil
+}
+
+// dedupKey identifies a pipeline (or a stage of it for stage events) across
+// executions
+func dedupKey(incoming *types.IncomingWebhook) string {
+	pipeline := incoming.PipelineName()
+	if ex := incoming.Content.Execution; ex != nil && ex.PipelineConfigID != "" {
+		pipeline = ex.PipelineConfigID
+	}
+
+	parts := []string{"spinnaker", incoming.Details.Application, pipeline}
+	if stage := incoming.StageName(); stage != "" {
+		parts = append(parts, stage)
+	}
+
+	return strings.Join(parts, "/")
+}
+
+type event struct {
+	RoutingKey string   `json:"routing_key"`
+	Action     string   `json:"event_action"`
+	DedupKey   string   `json:"dedup_key"`
+	Payload    *payload `json:"payload,omitempty"`
+	Links      []link   `json:"links,omitempty"`
+}
+
+type payload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	Component     string            `json:"component,omitempty"`
+	Group         string            `json:"group,omitempty"`
+	Class         string            `json:"class,omitempty"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+type link struct {
+	Href string `json:"href"`
+	Text string `json:"text"`
+}
+
+func (ah *AlertHandler) trigger(incoming *types.IncomingWebhook, dedupKey string) error {
+	summary, _, err := ah.config.summary.Render(incoming)
+	if err != nil {
+		return err
+	}
+
+	e := &event{
+		RoutingKey: ah.config.RoutingKey,
+		Action:     "trigger",
+		DedupKey:   dedupKey,
+		Payload: &payload{
+			Summary:   strings.TrimSpace(summary),
+			Source:    "spinnaker",
+			Severity:  ah.config.Severity,
+			Component: incoming.Details.Application,
+			Group:     incoming.PipelineName(),
+			Class:     incoming.Details.Type,
+			CustomDetails: map[string]string{
+				"execution_id": incoming.Content.ExecutionID,
+				"stage":        incoming.StageName(),
+			},
+		},
+	}
+
+	if url := incoming.ExecutionURL(ah.config.DeckURL); url != "" {
+		e.Links = []link{{Href: url, Text: "View in Spinnaker"}}
+	}
+
+	return ah.send(e)
+}
+
+func (ah *AlertHandler) send(e *event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "could not encode pagerduty event")
+	}
+
+	resp, err := ah.client.Post(ah.config.EventsURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "could not send pagerduty event")
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("pagerduty responded with %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package spinnakerpagerduty
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/templates"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+)
+
+// Alerter reads the PagerDuty configuration out of the event templates file and
+// attaches an AlertHandler for every configured service to a dispatcher
+type Alerter struct {
+	client   *http.Client
+	handlers map[string][]spinnaker.Handler
+}
+
+// alertEntry is the part of a hook type entry in the templates file that the
+// alerter cares about
+type alertEntry struct {
+	PagerDuty []*AlertConfig `json:"pagerduty,omitempty"`
+}
+
+// NewAlerter initializes an alerter from the "pagerduty" sections of the given
+// templates file. Alerts configured for a ":failed" hook type are also
+// attached to the matching ":complete" hook type so they can be resolved.
+func NewAlerter(c *http.Client, templateFile string) (*Alerter, error) {
+	file, err := templates.LoadFile(templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewAlerterFromFile(c, file, "")
+}
+
+// NewAlerterFromYAML initializes an alerter from the contents of a templates file
+func NewAlerterFromYAML(c *http.Client, b []byte) (*Alerter, error) {
+	file, err := templates.ParseFile(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewAlerterFromFile(c, file, "")
+}
+
+// NewAlerterFromFile initializes a alerter from a parsed templates file.
+// Alerts link to Deck hosted at deckURL unless they have their own deckUrl.
+func NewAlerterFromFile(c *http.Client, file *templates.File, deckURL string) (*Alerter, error) {
+	alerter := &Alerter{client: c, handlers: make(map[string][]spinnaker.Handler)}
+
+	entries := make(map[string]*alertEntry)
+	if err := file.Decode(&entries); err != nil {
+		return nil, err
+	}
+
+	for hookType, entry := range entries {
+		if entry == nil {
+			continue
+		}
+
+		for _, config := range entry.PagerDuty {
+			if config.DeckURL == "" {
+				config.DeckURL = deckURL
+			}
+			if err := config.Validate(); err != nil {
+				return nil, errors.Wrapf(err, "invalid pagerduty alert for %s", hookType)
+			}
+
+			handler := NewAlertHandler(c, config)
+			alerter.handlers[hookType] = append(alerter.handlers[hookType], handler)
+
+			if resolveType := resolvingHookType(hookType); resolveType != "" {
+				alerter.handlers[resolveType] = append(alerter.handlers[resolveType], handler)
+			}
+		}
+	}
+
+	return alerter, nil
+}
+
+// resolvingHookType returns the hook type that resolves alerts triggered by
+// the given one, for example "orca:pipeline:complete" for "orca:pipeline:failed"
+func resolvingHookType(hookType string) string {
+	suffix := ":" + types.PhaseFailed
+	if !strings.HasSuffix(hookType, suffix) {
+		return ""
+	}
+
+	return strings.TrimSuffix(hookType, suffix) + ":" + types.PhaseComplete
+}
+
+// Handlers returns the handlers that get attached to a dispatcher when AttachToDispatcher is called
+func (a *Alerter) Handlers() map[string][]spinnaker.Handler {
+	return a.handlers
+}
+
+// AttachToDispatcher registers all of the handlers for this alerter to a
+// spinnaker dispatcher.
+func (a *Alerter) AttachToDispatcher(d *spinnaker.Dispatcher) {
+	d.AddHandlers(a.Handlers())
+}
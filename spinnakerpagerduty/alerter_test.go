@@ -0,0 +1,131 @@
+package spinnakerpagerduty_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker/types"
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnakerpagerduty"
+)
+
+func TestAlerterInitialization(t *testing.T) {
+	wd, _ := os.Getwd()
+
+	t.Run("Given a valid template file", func(t *testing.T) {
+		alerter, err := spinnakerpagerduty.NewAlerter(nil, filepath.Join(wd, "testdata", "template.yml"))
+		require.NoError(t, err)
+
+		d := spinnaker.NewDispatcher()
+		alerter.AttachToDispatcher(d)
+		assert.Len(t, d.Handlers()["orca:pipeline:failed"], 1)
+		assert.Len(t, d.Handlers()["orca:pipeline:complete"], 1, "failures are resolved by completions")
+		assert.Len(t, d.Handlers()["orca:stage:failed"], 1)
+		assert.Len(t, d.Handlers()["orca:stage:complete"], 1)
+	})
+
+	t.Run("Given an unknown severity", func(t *testing.T) {
+		_, err := spinnakerpagerduty.NewAlerter(nil, filepath.Join(wd, "testdata", "bad-severity.yml"))
+		require.Error(t, err)
+	})
+}
+
+type enqueued struct {
+	RoutingKey string `json:"routing_key"`
+	Action     string `json:"event_action"`
+	DedupKey   string `json:"dedup_key"`
+	Payload    struct {
+		Summary   string `json:"summary"`
+		Severity  string `json:"severity"`
+		Component string `json:"component"`
+	} `json:"payload"`
+	Links []struct {
+		Href string `json:"href"`
+	} `json:"links"`
+}
+
+func pipelineWebhook(hookType, executionID, account string) *types.IncomingWebhook {
+	return &types.IncomingWebhook{
+		Details: types.Details{Application: "payments-api", Type: hookType},
+		Content: types.Content{
+			ExecutionID: executionID,
+			Execution: &types.Execution{
+				ID:               executionID,
+				Name:             "Deploy to production",
+				PipelineConfigID: "pipeline-config",
+				Stages: []types.Stage{
+					{Name: "Deploy", Context: map[string]interface{}{"account": account}},
+				},
+			},
+		},
+	}
+}
+
+func TestAlertHandler(t *testing.T) {
+	var events []enqueued
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var e enqueued
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&e))
+		events = append(events, e)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	config := &spinnakerpagerduty.AlertConfig{
+		RoutingKey: "R0UT1NGK3Y",
+		Accounts:   []string{"prod-*"},
+		DeckURL:    "https://spinnaker.example.com",
+		EventsURL:  ts.URL,
+	}
+	require.NoError(t, config.Validate())
+	handler := spinnakerpagerduty.NewAlertHandler(ts.Client(), config)
+
+	t.Run("Failures in non matching accounts don't alert", func(t *testing.T) {
+		require.NoError(t, handler.Handle(pipelineWebhook("orca:pipeline:failed", "exec-1", "staging")))
+		assert.Len(t, events, 0)
+	})
+
+	t.Run("A failure triggers an alert", func(t *testing.T) {
+		require.NoError(t, handler.Handle(pipelineWebhook("orca:pipeline:failed", "exec-2", "prod-us")))
+		require.Len(t, events, 1)
+
+		e := events[0]
+		assert.Equal(t, "trigger", e.Action)
+		assert.Equal(t, "R0UT1NGK3Y", e.RoutingKey)
+		assert.Equal(t, "spinnaker/payments-api/pipeline-config", e.DedupKey)
+		assert.Equal(t, "critical", e.Payload.Severity)
+		assert.Equal(t, "payments-api", e.Payload.Component)
+		assert.Equal(t, "payments-api pipeline Deploy to production failed in Spinnaker", e.Payload.Summary)
+		require.Len(t, e.Links, 1)
+		assert.Equal(t, "https://spinnaker.example.com/#/applications/payments-api/executions/details/exec-2", e.Links[0].Href)
+	})
+
+	t.Run("Failures of other executions of the pipeline use the same dedup key", func(t *testing.T) {
+		require.NoError(t, handler.Handle(pipelineWebhook("orca:pipeline:failed", "exec-3", "prod-us")))
+		require.Len(t, events, 2)
+		assert.Equal(t, "trigger", events[1].Action)
+		assert.Equal(t, "spinnaker/payments-api/pipeline-config", events[1].DedupKey)
+	})
+
+	t.Run("A subsequent successful run resolves the alert", func(t *testing.T) {
+		require.NoError(t, handler.Handle(pipelineWebhook("orca:pipeline:complete", "exec-4", "prod-us")))
+		require.Len(t, events, 3)
+		assert.Equal(t, "resolve", events[2].Action)
+		assert.Equal(t, "spinnaker/payments-api/pipeline-config", events[2].DedupKey)
+	})
+
+	t.Run("Successful runs resolve without a triggered alert", func(t *testing.T) {
+		restarted := spinnakerpagerduty.NewAlertHandler(ts.Client(), config)
+		require.NoError(t, restarted.Handle(pipelineWebhook("orca:pipeline:complete", "exec-5", "prod-us")))
+		require.Len(t, events, 4)
+		assert.Equal(t, "resolve", events[3].Action)
+		assert.Equal(t, "spinnaker/payments-api/pipeline-config", events[3].DedupKey)
+	})
+}
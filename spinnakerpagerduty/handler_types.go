@@ -0,0 +1,32 @@
+package spinnakerpagerduty
+
+import (
+	"net/http"
+
+	"github.com/bobbytables/spinnaker-datadog-bridge/spinnaker"
+)
+
+// AlertHandlerType triggers and resolves PagerDuty alerts, its options are an
+// alert configuration. Unlike the templates file, alerts aren't attached to
+// the ":complete" hook type automatically, it has to be listed to resolve them.
+const AlertHandlerType = "pagerduty"
+
+// RegisterHandlerTypes registers the PagerDuty handler type with the registry.
+// The HTTP client is used for every alert sent and alerts link to Deck hosted
+// at deckURL unless they have their own deckUrl.
+func RegisterHandlerTypes(r *spinnaker.Registry, c *http.Client, deckURL string) {
+	r.Register(AlertHandlerType, func(config spinnaker.HandlerConfig) (spinnaker.Handler, error) {
+		alert := &AlertConfig{}
+		if err := config.DecodeOptions(alert); err != nil {
+			return nil, err
+		}
+		if alert.DeckURL == "" {
+			alert.DeckURL = deckURL
+		}
+		if err := alert.Validate(); err != nil {
+			return nil, err
+		}
+
+		return NewAlertHandler(c, alert), nil
+	})
+}